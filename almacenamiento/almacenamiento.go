@@ -0,0 +1,111 @@
+package almacenamiento
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNoExiste se devuelve cuando el archivo solicitado no está en el almacenamiento
+var ErrNoExiste = errors.New("el archivo no existe en el almacenamiento")
+
+// Storage abstrae dónde se guardan los archivos subidos (disco local, S3, etc.)
+type Storage interface {
+	// Put guarda el contenido bajo el nombre indicado, reemplazándolo si ya existe
+	Put(ctx context.Context, nombre string, contenido io.Reader, contentType string) error
+	// Get abre el archivo para lectura. Devuelve ErrNoExiste si no está.
+	Get(ctx context.Context, nombre string) (io.ReadCloser, error)
+	// Delete elimina el archivo. Devuelve ErrNoExiste si no está.
+	Delete(ctx context.Context, nombre string) error
+	// URL devuelve la dirección pública desde la que se puede descargar el archivo
+	URL(nombre string) string
+	// List devuelve todos los archivos guardados
+	List(ctx context.Context) ([]Archivo, error)
+}
+
+// Archivo describe un archivo guardado en el almacenamiento
+type Archivo struct {
+	Nombre     string
+	Tamano     int64
+	Modificado time.Time
+}
+
+// DesdeEntorno construye el almacenamiento según la variable ALMACENAMIENTO (local por defecto)
+func DesdeEntorno() (Storage, error) {
+	switch driver := strings.ToLower(os.Getenv("ALMACENAMIENTO")); driver {
+	case "", "local":
+		return NuevoLocal(
+			valorOPorDefecto(os.Getenv("ALMACENAMIENTO_DIRECTORIO"), "public/uploads/productos"),
+			valorOPorDefecto(os.Getenv("IMAGENES_URL_BASE"), "http://localhost:8086/imagenes/"),
+		)
+	case "s3":
+		s3 := &S3{
+			Endpoint:   os.Getenv("S3_ENDPOINT"),
+			Region:     valorOPorDefecto(os.Getenv("S3_REGION"), "us-east-1"),
+			Bucket:     os.Getenv("S3_BUCKET"),
+			AccessKey:  os.Getenv("S3_ACCESS_KEY"),
+			SecretKey:  os.Getenv("S3_SECRET_KEY"),
+			Prefijo:    os.Getenv("S3_PREFIJO"),
+			URLPublica: os.Getenv("IMAGENES_URL_BASE"),
+		}
+		if s3.Endpoint == "" || s3.Bucket == "" || s3.AccessKey == "" || s3.SecretKey == "" {
+			return nil, errors.New("S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY y S3_SECRET_KEY son obligatorias con ALMACENAMIENTO=s3")
+		}
+		return s3, nil
+	default:
+		return nil, fmt.Errorf("ALMACENAMIENTO '%s' no soportado (use local o s3)", driver)
+	}
+}
+
+// CuarentenaDesdeEntorno construye el almacenamiento donde gc mueve los archivos huérfanos, fuera de lo que se
+// publica: con almacenamiento local es el directorio ALMACENAMIENTO_CUARENTENA (por defecto "cuarentena"), que no
+// puede quedar dentro del directorio servido; con S3 es el bucket S3_CUARENTENA_BUCKET, privado y distinto del
+// público, con las mismas credenciales y prefijo.
+func CuarentenaDesdeEntorno(principal Storage) (Storage, error) {
+	switch principal := principal.(type) {
+	case *Local:
+		directorio := valorOPorDefecto(os.Getenv("ALMACENAMIENTO_CUARENTENA"), "cuarentena")
+		if dentroDe(directorio, principal.Directorio) {
+			return nil, fmt.Errorf("ALMACENAMIENTO_CUARENTENA '%s' no puede estar dentro del directorio público '%s'", directorio, principal.Directorio)
+		}
+		return NuevoLocal(directorio, "")
+	case *S3:
+		bucket := os.Getenv("S3_CUARENTENA_BUCKET")
+		if bucket == "" {
+			return nil, errors.New("S3_CUARENTENA_BUCKET es obligatoria para la cuarentena con ALMACENAMIENTO=s3")
+		}
+		if bucket == principal.Bucket {
+			return nil, fmt.Errorf("S3_CUARENTENA_BUCKET no puede ser el bucket público '%s'", principal.Bucket)
+		}
+		cuarentena := *principal
+		cuarentena.Bucket = bucket
+		cuarentena.URLPublica = ""
+		return &cuarentena, nil
+	default:
+		return nil, fmt.Errorf("almacenamiento %T sin cuarentena", principal)
+	}
+}
+
+func valorOPorDefecto(valor, porDefecto string) string {
+	if valor == "" {
+		return porDefecto
+	}
+	return valor
+}
+
+// nombreValido evita rutas absolutas y saltos de directorio en los nombres de archivo
+func nombreValido(nombre string) error {
+	if nombre == "" || strings.HasPrefix(nombre, "/") || strings.Contains(nombre, "\\") {
+		return fmt.Errorf("nombre de archivo inválido: '%s'", nombre)
+	}
+	for _, parte := range strings.Split(nombre, "/") {
+		if parte == "" || parte == "." || parte == ".." {
+			return fmt.Errorf("nombre de archivo inválido: '%s'", nombre)
+		}
+	}
+	return nil
+}
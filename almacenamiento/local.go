@@ -0,0 +1,133 @@
+package almacenamiento
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local guarda los archivos en un directorio del servidor
+type Local struct {
+	Directorio string
+	URLBase    string
+}
+
+// NuevoLocal crea el directorio si no existe
+func NuevoLocal(directorio, urlBase string) (*Local, error) {
+	if err := os.MkdirAll(directorio, 0o755); err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(urlBase, "/") {
+		urlBase += "/"
+	}
+	return &Local{Directorio: directorio, URLBase: urlBase}, nil
+}
+
+// dentroDe indica si la ruta es el directorio base o queda dentro de él
+func dentroDe(ruta, base string) bool {
+	ruta, errRuta := filepath.Abs(ruta)
+	base, errBase := filepath.Abs(base)
+	if errRuta != nil || errBase != nil {
+		return false
+	}
+	relativa, err := filepath.Rel(base, ruta)
+	return err == nil && relativa != ".." && !strings.HasPrefix(relativa, ".."+string(filepath.Separator))
+}
+
+func (l *Local) ruta(nombre string) (string, error) {
+	if err := nombreValido(nombre); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.Directorio, filepath.FromSlash(nombre)), nil
+}
+
+func (l *Local) Put(ctx context.Context, nombre string, contenido io.Reader, contentType string) error {
+	destino, err := l.ruta(nombre)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(destino), 0o755); err != nil {
+		return err
+	}
+
+	// Se escribe en un temporal y luego se renombra para no dejar archivos a medias
+	tmp, err := os.CreateTemp(filepath.Dir(destino), ".subida-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, contenido); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), destino)
+}
+
+func (l *Local) Get(ctx context.Context, nombre string) (io.ReadCloser, error) {
+	origen, err := l.ruta(nombre)
+	if err != nil {
+		return nil, err
+	}
+	archivo, err := os.Open(origen)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNoExiste
+	}
+	return archivo, err
+}
+
+func (l *Local) Delete(ctx context.Context, nombre string) error {
+	ruta, err := l.ruta(nombre)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(ruta); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ErrNoExiste
+		}
+		return err
+	}
+	return nil
+}
+
+func (l *Local) URL(nombre string) string {
+	return l.URLBase + nombre
+}
+
+func (l *Local) List(ctx context.Context) ([]Archivo, error) {
+	archivos := []Archivo{}
+	err := filepath.WalkDir(l.Directorio, func(ruta string, entrada fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Los temporales de Put empiezan con punto
+		if entrada.IsDir() || strings.HasPrefix(entrada.Name(), ".") {
+			return nil
+		}
+		info, err := entrada.Info()
+		if err != nil {
+			return err
+		}
+		relativa, err := filepath.Rel(l.Directorio, ruta)
+		if err != nil {
+			return err
+		}
+		archivos = append(archivos, Archivo{
+			Nombre:     filepath.ToSlash(relativa),
+			Tamano:     info.Size(),
+			Modificado: info.ModTime(),
+		})
+		return ctx.Err()
+	})
+	return archivos, err
+}
@@ -0,0 +1,321 @@
+package almacenamiento
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3 guarda los archivos en un bucket compatible con S3 (AWS, MinIO, etc.).
+// Las peticiones se firman con AWS Signature V4 y usan direcciones estilo ruta: {endpoint}/{bucket}/{clave}
+type S3 struct {
+	Endpoint   string // ej. https://s3.us-east-1.amazonaws.com o http://localhost:9000
+	Region     string
+	Bucket     string
+	AccessKey  string
+	SecretKey  string
+	Prefijo    string       // Prefijo opcional para las claves, ej. "productos/"
+	URLPublica string       // Base pública para URL(); por defecto {endpoint}/{bucket}/
+	Cliente    *http.Client // Por defecto uno con timeout, para que un endpoint colgado no bloquee las peticiones
+}
+
+// clienteS3PorDefecto tiene un timeout amplio porque Put envía el archivo completo
+var clienteS3PorDefecto = &http.Client{Timeout: 60 * time.Second}
+
+func (s *S3) clave(nombre string) (string, error) {
+	if err := nombreValido(nombre); err != nil {
+		return "", err
+	}
+	return s.Prefijo + nombre, nil
+}
+
+func (s *S3) Put(ctx context.Context, nombre string, contenido io.Reader, contentType string) error {
+	clave, err := s.clave(nombre)
+	if err != nil {
+		return err
+	}
+	// Se lee completo para poder firmar el hash del contenido
+	datos, err := io.ReadAll(contenido)
+	if err != nil {
+		return err
+	}
+
+	cabeceras := http.Header{}
+	if contentType != "" {
+		cabeceras.Set("Content-Type", contentType)
+	}
+	resp, err := s.peticion(ctx, http.MethodPut, clave, datos, cabeceras)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errorS3(resp)
+	}
+	return nil
+}
+
+func (s *S3) Get(ctx context.Context, nombre string) (io.ReadCloser, error) {
+	clave, err := s.clave(nombre)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.peticion(ctx, http.MethodGet, clave, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNoExiste
+	default:
+		defer resp.Body.Close()
+		return nil, errorS3(resp)
+	}
+}
+
+func (s *S3) Delete(ctx context.Context, nombre string) error {
+	clave, err := s.clave(nombre)
+	if err != nil {
+		return err
+	}
+
+	// S3 responde 204 aunque la clave no exista, por eso se consulta antes
+	resp, err := s.peticion(ctx, http.MethodHead, clave, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNoExiste
+	}
+
+	resp, err = s.peticion(ctx, http.MethodDelete, clave, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return errorS3(resp)
+	}
+	return nil
+}
+
+func (s *S3) URL(nombre string) string {
+	if s.URLPublica != "" {
+		return strings.TrimSuffix(s.URLPublica, "/") + "/" + nombre
+	}
+	return strings.TrimSuffix(s.Endpoint, "/") + "/" + s.Bucket + "/" + s.Prefijo + nombre
+}
+
+func (s *S3) List(ctx context.Context) ([]Archivo, error) {
+	archivos := []Archivo{}
+	consulta := url.Values{"list-type": {"2"}}
+	if s.Prefijo != "" {
+		consulta.Set("prefix", s.Prefijo)
+	}
+
+	// ListObjectsV2 entrega hasta 1000 claves por página
+	for {
+		resp, err := s.peticionConsulta(ctx, http.MethodGet, "", consulta, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			return nil, errorS3(resp)
+		}
+
+		var pagina struct {
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+			Contents              []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&pagina)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("respuesta inválida de S3: %w", err)
+		}
+
+		for _, objeto := range pagina.Contents {
+			archivos = append(archivos, Archivo{
+				Nombre:     strings.TrimPrefix(objeto.Key, s.Prefijo),
+				Tamano:     objeto.Size,
+				Modificado: objeto.LastModified,
+			})
+		}
+		if !pagina.IsTruncated || pagina.NextContinuationToken == "" {
+			return archivos, nil
+		}
+		consulta.Set("continuation-token", pagina.NextContinuationToken)
+	}
+}
+
+// peticion arma y firma una petición contra el objeto indicado
+func (s *S3) peticion(ctx context.Context, metodo, clave string, cuerpo []byte, cabeceras http.Header) (*http.Response, error) {
+	return s.peticionConsulta(ctx, metodo, clave, nil, cuerpo, cabeceras)
+}
+
+// peticionConsulta es peticion con parámetros de consulta; con clave vacía apunta al bucket
+func (s *S3) peticionConsulta(ctx context.Context, metodo, clave string, consulta url.Values, cuerpo []byte, cabeceras http.Header) (*http.Response, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(s.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	endpoint.Path += "/" + s.Bucket
+	if clave != "" {
+		endpoint.Path += "/" + clave
+	}
+	endpoint.RawPath = codificarRuta(endpoint.Path)
+	if len(consulta) > 0 {
+		endpoint.RawQuery = consultaCanonica(consulta)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, metodo, endpoint.String(), bytes.NewReader(cuerpo))
+	if err != nil {
+		return nil, err
+	}
+	for nombre, valores := range cabeceras {
+		req.Header[nombre] = valores
+	}
+	s.firmar(req, cuerpo, time.Now().UTC())
+	return s.cliente().Do(req)
+}
+
+func (s *S3) cliente() *http.Client {
+	if s.Cliente != nil {
+		return s.Cliente
+	}
+	return clienteS3PorDefecto
+}
+
+// firmar agrega la cabecera Authorization según AWS Signature V4
+func (s *S3) firmar(req *http.Request, cuerpo []byte, ahora time.Time) {
+	fechaHora := ahora.Format("20060102T150405Z")
+	fecha := ahora.Format("20060102")
+	hashCuerpo := hashHex(cuerpo)
+
+	req.Header.Set("X-Amz-Date", fechaHora)
+	req.Header.Set("X-Amz-Content-Sha256", hashCuerpo)
+
+	// Cabeceras firmadas: host + todas las x-amz-* + content-type
+	firmadas := map[string]string{"host": req.URL.Host}
+	for nombre := range req.Header {
+		minuscula := strings.ToLower(nombre)
+		if strings.HasPrefix(minuscula, "x-amz-") || minuscula == "content-type" {
+			firmadas[minuscula] = strings.TrimSpace(req.Header.Get(nombre))
+		}
+	}
+	nombres := make([]string, 0, len(firmadas))
+	for nombre := range firmadas {
+		nombres = append(nombres, nombre)
+	}
+	sort.Strings(nombres)
+
+	var canonicas strings.Builder
+	for _, nombre := range nombres {
+		canonicas.WriteString(nombre + ":" + firmadas[nombre] + "\n")
+	}
+	cabecerasFirmadas := strings.Join(nombres, ";")
+
+	peticionCanonica := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		consultaCanonica(req.URL.Query()),
+		canonicas.String(),
+		cabecerasFirmadas,
+		hashCuerpo,
+	}, "\n")
+
+	alcance := fecha + "/" + s.Region + "/s3/aws4_request"
+	textoAFirmar := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		fechaHora,
+		alcance,
+		hashHex([]byte(peticionCanonica)),
+	}, "\n")
+
+	clave := hmacSHA256([]byte("AWS4"+s.SecretKey), fecha)
+	clave = hmacSHA256(clave, s.Region)
+	clave = hmacSHA256(clave, "s3")
+	clave = hmacSHA256(clave, "aws4_request")
+	firma := hex.EncodeToString(hmacSHA256(clave, textoAFirmar))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, alcance, cabecerasFirmadas, firma,
+	))
+}
+
+// codificarRuta aplica la codificación URI de S3 a cada segmento, manteniendo las "/"
+func codificarRuta(ruta string) string {
+	segmentos := strings.Split(ruta, "/")
+	for i, segmento := range segmentos {
+		segmentos[i] = codificarURI(segmento)
+	}
+	return strings.Join(segmentos, "/")
+}
+
+func consultaCanonica(valores url.Values) string {
+	claves := make([]string, 0, len(valores))
+	for clave := range valores {
+		claves = append(claves, clave)
+	}
+	sort.Strings(claves)
+
+	var partes []string
+	for _, clave := range claves {
+		lista := append([]string(nil), valores[clave]...)
+		sort.Strings(lista)
+		for _, valor := range lista {
+			partes = append(partes, codificarURI(clave)+"="+codificarURI(valor))
+		}
+	}
+	return strings.Join(partes, "&")
+}
+
+// codificarURI codifica todo excepto los caracteres no reservados de RFC 3986
+func codificarURI(texto string) string {
+	var b strings.Builder
+	for _, c := range []byte(texto) {
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hashHex(datos []byte) string {
+	suma := sha256.Sum256(datos)
+	return hex.EncodeToString(suma[:])
+}
+
+func hmacSHA256(clave []byte, texto string) []byte {
+	mac := hmac.New(sha256.New, clave)
+	mac.Write([]byte(texto))
+	return mac.Sum(nil)
+}
+
+func errorS3(resp *http.Response) error {
+	cuerpo, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("S3 respondió %d: %s", resp.StatusCode, strings.TrimSpace(string(cuerpo)))
+}
@@ -0,0 +1,54 @@
+package comandos
+
+import (
+	"clase_6_echo_mongo/almacenamiento"
+	"clase_6_echo_mongo/database"
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Entorno son las dependencias ya configuradas que reciben los subcomandos
+type Entorno struct {
+	MongoClient *database.MongoDBClient
+	DBName      string
+	Colecciones map[string]string
+	Storage     almacenamiento.Storage
+	Salida      io.Writer // os.Stdout si es nil
+}
+
+// comando es un subcomando de mantenimiento del binario del servidor
+type comando struct {
+	descripcion string
+	ejecutar    func(ctx context.Context, entorno Entorno, args []string) error
+}
+
+var registrados = map[string]comando{
+	"gc":      {"Reconcilia los archivos subidos con productos_fotos y limpia los huérfanos", GC},
+	"migrate": {"Aplica (up), revierte (down) o lista (status) las migraciones de datos", Migrate},
+}
+
+// Ejecutar corre el subcomando indicado en args[0] con el resto de los argumentos
+func Ejecutar(ctx context.Context, entorno Entorno, args []string) error {
+	if entorno.Salida == nil {
+		entorno.Salida = os.Stdout
+	}
+	if len(args) == 0 {
+		return ayuda(entorno.Salida)
+	}
+	cmd, ok := registrados[args[0]]
+	if !ok {
+		ayuda(entorno.Salida)
+		return fmt.Errorf("subcomando '%s' desconocido", args[0])
+	}
+	return cmd.ejecutar(ctx, entorno, args[1:])
+}
+
+func ayuda(salida io.Writer) error {
+	fmt.Fprintln(salida, "Subcomandos disponibles:")
+	for nombre, cmd := range registrados {
+		fmt.Fprintf(salida, "  %-10s %s\n", nombre, cmd.descripcion)
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package comandos
+
+import (
+	"clase_6_echo_mongo/almacenamiento"
+	"clase_6_echo_mongo/database"
+	"clase_6_echo_mongo/modelos"
+	"clase_6_echo_mongo/utilidades"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"mime"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Acciones de GC sobre los huérfanos encontrados
+const (
+	accionReportar   = "reportar"
+	accionEliminar   = "eliminar"
+	accionCuarentena = "cuarentena"
+)
+
+// Carpeta donde versiones anteriores de GC dejaban la cuarentena dentro del almacenamiento público; GC no la
+// revisa como huérfanos y mueve lo que encuentre al almacenamiento de cuarentena
+const carpetaCuarentena = "cuarentena/"
+
+// fotoHuerfana es un documento de productos_fotos sin archivo o sin producto
+type fotoHuerfana struct {
+	foto   modelos.FotoProducto
+	motivo string
+}
+
+// GC reconcilia el almacenamiento con productos_fotos. Reporta los archivos que ninguna foto usa y
+// las fotos cuyo archivo original o producto ya no existe; con -accion los elimina o los pone en cuarentena.
+//
+//	gc [-accion reportar|eliminar|cuarentena] [-dry-run] [-antiguedad 1h]
+func GC(ctx context.Context, entorno Entorno, args []string) error {
+	opciones := flag.NewFlagSet("gc", flag.ContinueOnError)
+	opciones.SetOutput(entorno.Salida)
+	accion := opciones.String("accion", accionReportar, "qué hacer con los huérfanos: reportar, eliminar o cuarentena")
+	simular := opciones.Bool("dry-run", false, "muestra lo que haría la acción sin modificar nada")
+	antiguedad := opciones.Duration("antiguedad", time.Hour, "los archivos más recientes se ignoran, pueden ser subidas en curso")
+	if err := opciones.Parse(args); err != nil {
+		return err
+	}
+	switch *accion {
+	case accionReportar, accionEliminar, accionCuarentena:
+	default:
+		return fmt.Errorf("acción '%s' no soportada (use reportar, eliminar o cuarentena)", *accion)
+	}
+
+	fotos := database.NewRepository[modelos.FotoProducto](entorno.MongoClient, entorno.DBName, entorno.Colecciones["productos_fotos"])
+	productos := database.NewRepository[modelos.Producto](entorno.MongoClient, entorno.DBName, entorno.Colecciones["productos"])
+	cuarentena := database.NewRepository[modelos.FotoProducto](entorno.MongoClient, entorno.DBName, entorno.Colecciones["productos_fotos_cuarentena"])
+	referencias := database.NewRepository[modelos.ReferenciaArchivo](entorno.MongoClient, entorno.DBName, entorno.Colecciones["archivos"])
+
+	archivos, err := entorno.Storage.List(ctx)
+	if err != nil {
+		return fmt.Errorf("error al listar el almacenamiento: %w", err)
+	}
+	existentes := make(map[string]almacenamiento.Archivo, len(archivos))
+	for _, archivo := range archivos {
+		existentes[archivo.Nombre] = archivo
+	}
+
+	documentos, err := fotos.Find(ctx, bson.M{})
+	if err != nil {
+		return fmt.Errorf("error al listar productos_fotos: %w", err)
+	}
+	listaProductos, err := productos.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return fmt.Errorf("error al listar productos: %w", err)
+	}
+	idsProductos := make(map[primitive.ObjectID]bool, len(listaProductos))
+	for _, producto := range listaProductos {
+		idsProductos[producto.ID] = true
+	}
+
+	// Fotos huérfanas, y archivos en uso por las fotos que se conservan. Las fotos recientes se conservan
+	// siempre: el documento puede haberse guardado después de listar el almacenamiento.
+	limite := time.Now().Add(-*antiguedad)
+	var fotosHuerfanas []fotoHuerfana
+	enUso := map[string]bool{}
+	for _, foto := range documentos {
+		motivo := ""
+		switch _, existe := existentes[foto.Nombre]; {
+		case time.Unix(foto.Timestamp, 0).After(limite):
+			// Puede ser una subida en curso
+		case !idsProductos[foto.ProductoID]:
+			motivo = "producto inexistente"
+		case !existe:
+			motivo = "archivo inexistente"
+		}
+		if motivo != "" {
+			fotosHuerfanas = append(fotosHuerfanas, fotoHuerfana{foto: foto, motivo: motivo})
+			// Solo reportando la foto se conserva, y con ella sus archivos
+			if *accion != accionReportar {
+				continue
+			}
+		}
+		archivo := utilidades.ArchivoSubido{Nombre: foto.Nombre, Variantes: foto.Variantes}
+		for _, nombre := range archivo.Nombres() {
+			enUso[nombre] = true
+		}
+	}
+
+	var archivosHuerfanos, cuarentenaPublica []almacenamiento.Archivo
+	for _, archivo := range archivos {
+		if strings.HasPrefix(archivo.Nombre, carpetaCuarentena) {
+			cuarentenaPublica = append(cuarentenaPublica, archivo)
+			continue
+		}
+		if enUso[archivo.Nombre] || archivo.Modificado.After(limite) {
+			continue
+		}
+		archivosHuerfanos = append(archivosHuerfanos, archivo)
+	}
+	sort.Slice(archivosHuerfanos, func(i, j int) bool { return archivosHuerfanos[i].Nombre < archivosHuerfanos[j].Nombre })
+
+	salida := entorno.Salida
+	prefijoLinea := ""
+	if *simular {
+		prefijoLinea = "[dry-run] "
+	}
+
+	fmt.Fprintf(salida, "Fotos sin archivo o producto: %d\n", len(fotosHuerfanas))
+	for _, huerfana := range fotosHuerfanas {
+		fmt.Fprintf(salida, "  %s%s %s (producto %s): %s\n", prefijoLinea, huerfana.foto.ID.Hex(), huerfana.foto.Nombre, huerfana.foto.ProductoID.Hex(), huerfana.motivo)
+	}
+	var bytesHuerfanos int64
+	for _, archivo := range archivosHuerfanos {
+		bytesHuerfanos += archivo.Tamano
+	}
+	fmt.Fprintf(salida, "Archivos sin foto: %d (%d bytes)\n", len(archivosHuerfanos), bytesHuerfanos)
+	for _, archivo := range archivosHuerfanos {
+		fmt.Fprintf(salida, "  %s%s (%d bytes, %s)\n", prefijoLinea, archivo.Nombre, archivo.Tamano, archivo.Modificado.Format(time.RFC3339))
+	}
+	if len(cuarentenaPublica) > 0 {
+		fmt.Fprintf(salida, "Archivos en cuarentena dentro del almacenamiento público: %d\n", len(cuarentenaPublica))
+		for _, archivo := range cuarentenaPublica {
+			fmt.Fprintf(salida, "  %s%s\n", prefijoLinea, archivo.Nombre)
+		}
+	}
+
+	if *accion == accionReportar {
+		return nil
+	}
+	if *simular {
+		fmt.Fprintf(salida, "dry-run: no se aplicó la acción '%s'\n", *accion)
+		return nil
+	}
+
+	// El almacenamiento de cuarentena se configura solo si hay algo que mover
+	var almacenCuarentena almacenamiento.Storage
+	if (*accion == accionCuarentena && len(archivosHuerfanos) > 0) || len(cuarentenaPublica) > 0 {
+		almacenCuarentena, err = almacenamiento.CuarentenaDesdeEntorno(entorno.Storage)
+		if err != nil {
+			return fmt.Errorf("error al configurar la cuarentena: %w", err)
+		}
+	}
+
+	// Primero las fotos, así un archivo no queda referenciado por un documento que apunta a la nada
+	fallos := 0
+	var reclamados []string
+	for _, huerfana := range fotosHuerfanas {
+		if *accion == accionCuarentena {
+			if _, err := cuarentena.Collection().InsertOne(ctx, huerfana.foto); err != nil {
+				fmt.Fprintf(salida, "Error al poner en cuarentena la foto %s: %v\n", huerfana.foto.ID.Hex(), err)
+				fallos++
+				continue
+			}
+		}
+		if _, err := fotos.Delete(ctx, huerfana.foto.ID.Hex()); err != nil {
+			fmt.Fprintf(salida, "Error al eliminar la foto %s: %v\n", huerfana.foto.ID.Hex(), err)
+			fallos++
+			continue
+		}
+		ultima, err := utilidades.SoltarReferencia(ctx, referencias, huerfana.foto.Nombre)
+		if err != nil {
+			fmt.Fprintf(salida, "Error al soltar la referencia de la foto %s: %v\n", huerfana.foto.ID.Hex(), err)
+			fallos++
+			continue
+		}
+		if ultima {
+			reclamados = append(reclamados, huerfana.foto.Nombre)
+		}
+	}
+
+	destino := time.Now().Format("20060102-150405") + "/"
+	for _, archivo := range archivosHuerfanos {
+		var err error
+		if *accion == accionCuarentena {
+			err = moverArchivo(ctx, entorno.Storage, almacenCuarentena, archivo.Nombre, destino+archivo.Nombre)
+		} else {
+			_, err = utilidades.EliminarArchivo(ctx, entorno.Storage, archivo.Nombre)
+		}
+		if err != nil && !errors.Is(err, almacenamiento.ErrNoExiste) {
+			fmt.Fprintf(salida, "Error al procesar el archivo %s: %v\n", archivo.Nombre, err)
+			fallos++
+		}
+	}
+
+	// Los archivos de las últimas referencias ya se procesaron como huérfanos; si alguno era reciente o
+	// falló, queda para la próxima pasada
+	for _, nombre := range reclamados {
+		if err := utilidades.TerminarBorrado(ctx, referencias, nombre, true); err != nil {
+			fmt.Fprintf(salida, "Error al liberar el contador del archivo %s: %v\n", nombre, err)
+			fallos++
+		}
+	}
+
+	// La cuarentena anterior sale del almacenamiento público conservando su carpeta con fecha
+	for _, archivo := range cuarentenaPublica {
+		err := moverArchivo(ctx, entorno.Storage, almacenCuarentena, archivo.Nombre, strings.TrimPrefix(archivo.Nombre, carpetaCuarentena))
+		if err != nil && !errors.Is(err, almacenamiento.ErrNoExiste) {
+			fmt.Fprintf(salida, "Error al mover el archivo en cuarentena %s: %v\n", archivo.Nombre, err)
+			fallos++
+		}
+	}
+
+	fmt.Fprintf(salida, "Acción '%s' aplicada a %d fotos y %d archivos\n", *accion, len(fotosHuerfanas), len(archivosHuerfanos))
+	if fallos > 0 {
+		return fmt.Errorf("%d elementos no se pudieron procesar", fallos)
+	}
+	return nil
+}
+
+// moverArchivo copia el archivo a otro almacenamiento y luego borra el original
+func moverArchivo(ctx context.Context, desde, hacia almacenamiento.Storage, origen, destino string) error {
+	contenido, err := desde.Get(ctx, origen)
+	if err != nil {
+		return err
+	}
+	defer contenido.Close()
+
+	if err := hacia.Put(ctx, destino, contenido, mime.TypeByExtension(path.Ext(origen))); err != nil {
+		return err
+	}
+	return desde.Delete(ctx, origen)
+}
@@ -0,0 +1,84 @@
+package comandos
+
+import (
+	"clase_6_echo_mongo/migraciones"
+	"context"
+	"flag"
+	"fmt"
+	"time"
+)
+
+// Migrate aplica, revierte o lista las migraciones de datos del paquete migraciones.
+//
+//	migrate up [-hasta N]
+//	migrate down [-pasos 1]
+//	migrate status
+func Migrate(ctx context.Context, entorno Entorno, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("falta la acción de migrate (use up, down o status)")
+	}
+	accion, args := args[0], args[1:]
+	opciones := flag.NewFlagSet("migrate "+accion, flag.ContinueOnError)
+	opciones.SetOutput(entorno.Salida)
+	hasta := opciones.Int("hasta", 0, "última versión a aplicar; 0 aplica todas las pendientes")
+	pasos := opciones.Int("pasos", 1, "cantidad de migraciones a revertir")
+	if err := opciones.Parse(args); err != nil {
+		return err
+	}
+
+	runner := migraciones.Nuevo(entorno.MongoClient, entorno.DBName, entorno.Colecciones)
+	salida := entorno.Salida
+
+	switch accion {
+	case "up":
+		aplicadas, err := runner.Subir(ctx, *hasta)
+		for _, migracion := range aplicadas {
+			fmt.Fprintf(salida, "Aplicada %04d %s\n", migracion.Version, migracion.Nombre)
+		}
+		if err != nil {
+			return err
+		}
+		if len(aplicadas) == 0 {
+			fmt.Fprintln(salida, "No hay migraciones pendientes")
+		}
+		return nil
+
+	case "down":
+		if *pasos <= 0 {
+			return fmt.Errorf("-pasos debe ser mayor que 0")
+		}
+		revertidas, err := runner.Bajar(ctx, *pasos)
+		for _, migracion := range revertidas {
+			fmt.Fprintf(salida, "Revertida %04d %s\n", migracion.Version, migracion.Nombre)
+		}
+		if err != nil {
+			return err
+		}
+		if len(revertidas) == 0 {
+			fmt.Fprintln(salida, "No hay migraciones aplicadas")
+		}
+		return nil
+
+	case "status":
+		estados, err := runner.Estado(ctx)
+		if err != nil {
+			return err
+		}
+		for _, estado := range estados {
+			descripcion := "pendiente"
+			switch {
+			case estado.Desconocida():
+				descripcion = "aplicada, no existe en este binario"
+			case estado.Modificada():
+				descripcion = "aplicada, el archivo cambió desde entonces"
+			case estado.Aplicada():
+				descripcion = "aplicada " + estado.Registro.AplicadaEn.Local().Format(time.RFC3339)
+			}
+			fmt.Fprintf(salida, "%04d  %-45s %s\n", estado.Version, estado.Nombre, descripcion)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("acción '%s' no soportada (use up, down o status)", accion)
+	}
+}
@@ -1,8 +1,21 @@
 package config
 
 var Collections = map[string]string{
-	"categorias":      "categorias",
-	"productos":       "productos",
-	"productos_fotos": "productos_fotos",
-	"usuarios":        "usuarios",
+	"categorias":                 "categorias",
+	"productos":                  "productos",
+	"productos_fotos":            "productos_fotos",
+	"usuarios":                   "usuarios",
+	"refresh_tokens":             "refresh_tokens",
+	"tokens_revocados":           "tokens_revocados",
+	"password_resets":            "password_resets",
+	"verificaciones_correo":      "verificaciones_correo",
+	"intentos_login":             "intentos_login",
+	"claves_jwt":                 "claves_jwt",
+	"api_keys":                   "api_keys",
+	"oidc_estados":               "oidc_estados",
+	"archivos_pendientes":        "archivos_pendientes",
+	"archivos":                   "archivos",
+	"productos_fotos_cuarentena": "productos_fotos_cuarentena",
+	"migraciones":                "migraciones",
+	"migraciones_bloqueo":        "migraciones_bloqueo",
 }
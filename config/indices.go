@@ -0,0 +1,104 @@
+package config
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Indice declara un índice de MongoDB. database.Connect crea los que faltan y reporta los que difieren.
+type Indice struct {
+	Nombre  string
+	Campos  bson.D // 1 o -1 por campo; "text" para índices de texto
+	Unico   bool
+	Expira  *time.Duration // TTL: el documento se elimina este tiempo después de la fecha del campo
+	Parcial bson.M         // Solo se indexan los documentos que cumplen el filtro
+	Pesos   bson.M         // Peso de cada campo en los índices de texto
+}
+
+// RetencionIntentosLogin es el TTL de intentos_login desde el último fallo. LOGIN_VENTANA y LOGIN_BLOQUEO_MAXIMO
+// no pueden superarlo, o el contador y el bloqueo desaparecerían antes de tiempo.
+const RetencionIntentosLogin = 24 * time.Hour
+
+// ttl arma el Expira de un índice TTL; 0 elimina el documento en la fecha del campo
+func ttl(duracion time.Duration) *time.Duration {
+	return &duracion
+}
+
+func asc(campos ...string) bson.D {
+	d := bson.D{}
+	for _, campo := range campos {
+		d = append(d, bson.E{Key: campo, Value: 1})
+	}
+	return d
+}
+
+// Indices son los índices de cada colección, por el mismo nombre lógico de Collections
+var Indices = map[string][]Indice{
+	"categorias": {
+		{Nombre: "slug_unico", Campos: asc("slug"), Unico: true},
+		{Nombre: "slugs_anteriores", Campos: asc("slugs_anteriores")},
+		{Nombre: "parent_id", Campos: asc("parent_id")},
+		{Nombre: "ancestros", Campos: asc("ancestros")},
+	},
+	"productos": {
+		{Nombre: "categoria_id", Campos: asc("categoria_id")},
+		{Nombre: "nombre", Campos: asc("nombre")},
+		{Nombre: "busqueda", Campos: bson.D{{Key: "nombre", Value: "text"}, {Key: "descripcion", Value: "text"}},
+			Pesos: bson.M{"nombre": 5, "descripcion": 1}},
+		// Los productos anteriores a los slugs no tienen el campo hasta que AsegurarIdentificadoresProductos lo asigna
+		{Nombre: "slug_unico", Campos: asc("slug"), Unico: true, Parcial: bson.M{"slug": bson.M{"$type": "string"}}},
+		{Nombre: "slugs_anteriores", Campos: asc("slugs_anteriores")},
+		{Nombre: "sku_unico", Campos: asc("sku"), Unico: true, Parcial: bson.M{"sku": bson.M{"$type": "string"}}},
+	},
+	"productos_fotos": {
+		{Nombre: "producto_id", Campos: asc("producto_id")},
+		{Nombre: "nombre", Campos: asc("nombre")}, // Cuenta las referencias a un archivo compartido
+	},
+	"usuarios": {
+		{Nombre: "correo_unico", Campos: asc("correo"), Unico: true},
+		{Nombre: "correo_pendiente", Campos: asc("correo_pendiente"), Parcial: bson.M{"correo_pendiente": bson.M{"$exists": true}}},
+		{Nombre: "eliminado_en", Campos: asc("eliminado_en"), Parcial: bson.M{"eliminado_en": bson.M{"$exists": true}}},
+		{Nombre: "identidad_externa", Campos: asc("identidades.emisor", "identidades.sujeto"), Unico: true,
+			Parcial: bson.M{"identidades.sujeto": bson.M{"$exists": true}}},
+	},
+	"refresh_tokens": {
+		{Nombre: "hash_unico", Campos: asc("hash"), Unico: true},
+		{Nombre: "usuario_revocado", Campos: asc("usuario_id", "revocado")},
+		{Nombre: "familia", Campos: asc("familia")},
+		{Nombre: "expira_ttl", Campos: asc("expira"), Expira: ttl(0)},
+	},
+	"tokens_revocados": {
+		{Nombre: "jti_unico", Campos: asc("jti"), Unico: true},
+		{Nombre: "expira_ttl", Campos: asc("expira"), Expira: ttl(0)},
+	},
+	"password_resets": {
+		{Nombre: "hash_unico", Campos: asc("hash"), Unico: true},
+		{Nombre: "usuario_usado", Campos: asc("usuario_id", "usado")},
+		{Nombre: "expira_ttl", Campos: asc("expira"), Expira: ttl(24 * time.Hour)},
+	},
+	"verificaciones_correo": {
+		{Nombre: "hash_unico", Campos: asc("hash"), Unico: true},
+		{Nombre: "usuario_usado", Campos: asc("usuario_id", "usado")},
+		{Nombre: "expira_ttl", Campos: asc("expira"), Expira: ttl(24 * time.Hour)},
+	},
+	"intentos_login": {
+		{Nombre: "clave_unica", Campos: asc("clave"), Unico: true},
+		{Nombre: "bloqueado_hasta", Campos: asc("bloqueado_hasta")},
+		{Nombre: "ultimo_fallo_ttl", Campos: asc("ultimo_fallo"), Expira: ttl(RetencionIntentosLogin)},
+	},
+	"claves_jwt": {
+		// Evita que dos réplicas creen claves distintas para el mismo periodo
+		{Nombre: "algoritmo_1_periodo_1", Campos: asc("algoritmo", "periodo"), Unico: true},
+	},
+	"api_keys": {
+		{Nombre: "prefijo_unico", Campos: asc("prefijo"), Unico: true},
+	},
+	"oidc_estados": {
+		{Nombre: "hash_unico", Campos: asc("hash"), Unico: true},
+		{Nombre: "expira_ttl", Campos: asc("expira"), Expira: ttl(0)},
+	},
+	"archivos_pendientes": {
+		{Nombre: "proximo_intento", Campos: asc("proximo_intento")},
+	},
+}
@@ -0,0 +1,45 @@
+package correo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Mensaje es un correo de texto plano
+type Mensaje struct {
+	Para   string
+	Asunto string
+	Cuerpo string
+}
+
+// Mailer envía correos. Hay una implementación SMTP y otra en memoria para desarrollo y pruebas.
+type Mailer interface {
+	Enviar(ctx context.Context, mensaje Mensaje) error
+}
+
+// DesdeEntorno construye el mailer según MAILER (log por defecto, o smtp)
+func DesdeEntorno() (Mailer, error) {
+	switch driver := strings.ToLower(os.Getenv("MAILER")); driver {
+	case "", "log":
+		return NuevoMemoria(true), nil
+	case "smtp":
+		smtp := &SMTP{
+			Host:      os.Getenv("SMTP_HOST"),
+			Puerto:    os.Getenv("SMTP_PUERTO"),
+			Usuario:   os.Getenv("SMTP_USUARIO"),
+			Password:  os.Getenv("SMTP_PASSWORD"),
+			Remitente: os.Getenv("SMTP_REMITENTE"),
+		}
+		if smtp.Puerto == "" {
+			smtp.Puerto = "587"
+		}
+		if smtp.Host == "" || smtp.Remitente == "" {
+			return nil, fmt.Errorf("SMTP_HOST y SMTP_REMITENTE son obligatorias con MAILER=smtp")
+		}
+		return smtp, nil
+	default:
+		return nil, fmt.Errorf("MAILER '%s' no soportado (use log o smtp)", driver)
+	}
+}
@@ -0,0 +1,37 @@
+package correo
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// Memoria guarda los mensajes en lugar de enviarlos. Con Registrar=true además los escribe en el log.
+type Memoria struct {
+	Registrar bool
+
+	mu       sync.Mutex
+	enviados []Mensaje
+}
+
+func NuevoMemoria(registrar bool) *Memoria {
+	return &Memoria{Registrar: registrar}
+}
+
+func (m *Memoria) Enviar(ctx context.Context, mensaje Mensaje) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.enviados = append(m.enviados, mensaje)
+	if m.Registrar {
+		log.Printf("Correo para %s | %s\n%s", mensaje.Para, mensaje.Asunto, mensaje.Cuerpo)
+	}
+	return nil
+}
+
+// Enviados devuelve una copia de los mensajes recibidos hasta ahora
+func (m *Memoria) Enviados() []Mensaje {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Mensaje(nil), m.enviados...)
+}
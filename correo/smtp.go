@@ -0,0 +1,81 @@
+package correo
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTP envía los correos a través de un servidor SMTP, usando STARTTLS si el servidor lo ofrece
+type SMTP struct {
+	Host      string
+	Puerto    string
+	Usuario   string
+	Password  string
+	Remitente string
+}
+
+func (s *SMTP) Enviar(ctx context.Context, mensaje Mensaje) error {
+	direccion := net.JoinHostPort(s.Host, s.Puerto)
+
+	var dialer net.Dialer
+	conexion, err := dialer.DialContext(ctx, "tcp", direccion)
+	if err != nil {
+		return fmt.Errorf("no se pudo conectar a %s: %w", direccion, err)
+	}
+	if limite, ok := ctx.Deadline(); ok {
+		conexion.SetDeadline(limite)
+	} else {
+		conexion.SetDeadline(time.Now().Add(30 * time.Second))
+	}
+
+	cliente, err := smtp.NewClient(conexion, s.Host)
+	if err != nil {
+		conexion.Close()
+		return err
+	}
+	defer cliente.Close()
+
+	if ok, _ := cliente.Extension("STARTTLS"); ok {
+		if err := cliente.StartTLS(&tls.Config{ServerName: s.Host}); err != nil {
+			return err
+		}
+	}
+	if s.Usuario != "" {
+		if err := cliente.Auth(smtp.PlainAuth("", s.Usuario, s.Password, s.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := cliente.Mail(s.Remitente); err != nil {
+		return err
+	}
+	if err := cliente.Rcpt(mensaje.Para); err != nil {
+		return err
+	}
+	escritor, err := cliente.Data()
+	if err != nil {
+		return err
+	}
+
+	cabeceras := []string{
+		"From: " + s.Remitente,
+		"To: " + mensaje.Para,
+		"Subject: " + mensaje.Asunto,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"Date: " + time.Now().Format(time.RFC1123Z),
+	}
+	cuerpo := strings.Join(cabeceras, "\r\n") + "\r\n\r\n" + strings.ReplaceAll(mensaje.Cuerpo, "\n", "\r\n")
+	if _, err := escritor.Write([]byte(cuerpo)); err != nil {
+		return err
+	}
+	if err := escritor.Close(); err != nil {
+		return err
+	}
+	return cliente.Quit()
+}
@@ -19,7 +19,8 @@ type MongoDBClient struct {
 	Client *mongo.Client
 }
 
-// Inicializar la conexión a MongoDB y crea las colecciones necesarias
+// Inicializar la conexión a MongoDB y crea las colecciones necesarias. MongoDB debe correr como replica set
+// (basta uno de un solo nodo) o como clúster shardeado, porque las transacciones lo requieren.
 func Connect(uri, dbName string) (*MongoDBClient, error) {
 	clientOptions := options.Client().ApplyURI(uri)
 	client, err := mongo.Connect(context.TODO(), clientOptions)
@@ -31,6 +32,10 @@ func Connect(uri, dbName string) (*MongoDBClient, error) {
 		return nil, err
 	}
 
+	if err := verificarTransacciones(client); err != nil {
+		return nil, err
+	}
+
 	db := client.Database(dbName)
 
 	// Crear automáticamente todas las colecciones
@@ -38,10 +43,35 @@ func Connect(uri, dbName string) (*MongoDBClient, error) {
 		return nil, err
 	}
 
+	// Crear los índices declarados en config.Indices; los que difieren solo se reportan
+	if err := reportarIndices(db); err != nil {
+		return nil, err
+	}
+
 	log.Println("Conectado correctamente a MongoDB y colecciones listas.")
 	return &MongoDBClient{Client: client}, nil
 }
 
+// verificarTransacciones falla al conectar a un mongod standalone, que rechaza las transacciones: de lo contrario
+// editar o eliminar categorías y eliminar productos devolverían 500 en cada petición
+func verificarTransacciones(client *mongo.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return fmt.Errorf("error al consultar la topología de MongoDB: %w", err)
+	}
+	if hello.SetName == "" && hello.Msg != "isdbgrid" {
+		return errors.New("MongoDB corre como standalone y no admite transacciones: inícielo como replica set " +
+			"(ej. mongod --replSet rs0 y luego rs.initiate()) y agregue replicaSet=rs0 a MONGODB_URI")
+	}
+	return nil
+}
+
 func createAllCollections(db *mongo.Database, collections map[string]string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -69,6 +99,20 @@ func createAllCollections(db *mongo.Database, collections map[string]string) err
 	return nil
 }
 
+func reportarIndices(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	diferencias, err := aplicarIndices(ctx, db, config.Collections, config.Indices)
+	if err != nil {
+		return err
+	}
+	for _, diferencia := range diferencias {
+		log.Printf("Índice fuera de la especificación: %s", diferencia)
+	}
+	return nil
+}
+
 // Close cierra la conexión a MongoDB
 func (c *MongoDBClient) Close() {
 	if err := c.Client.Disconnect(context.TODO()); err != nil {
@@ -76,6 +120,21 @@ func (c *MongoDBClient) Close() {
 	}
 }
 
+// Transaccion ejecuta fn dentro de una transacción y la confirma si no devuelve error.
+// Las operaciones de fn deben usar el ctx recibido. Requiere que MongoDB corra como replica set, Connect lo verifica.
+func (c *MongoDBClient) Transaccion(ctx context.Context, fn func(ctx mongo.SessionContext) error) error {
+	sesion, err := c.Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer sesion.EndSession(ctx)
+
+	_, err = sesion.WithTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(ctx)
+	})
+	return err
+}
+
 // GetCollection devuelve una colección especifica de una base de datos
 func (c *MongoDBClient) GetCollection(dbName, collectionName string) *mongo.Collection {
 	return c.Client.Database(dbName).Collection(collectionName)
@@ -113,6 +172,12 @@ func (c *MongoDBClient) ListDocumentos(ctx context.Context, dbName, collectionNa
 	return resultados, nil
 }
 
+// ContarDocumentos cuenta los documentos de una colección que cumplen el filtro
+func (c *MongoDBClient) ContarDocumentos(ctx context.Context, dbName, collectionName string, filter bson.M) (int64, error) {
+	collection := c.GetCollection(dbName, collectionName)
+	return collection.CountDocuments(ctx, filter)
+}
+
 func (c *MongoDBClient) ListDocumentoPorId(ctx context.Context, dbName, collectionName string, pipeline mongo.Pipeline) ([]bson.M, error) {
 	collection := c.GetCollection(dbName, collectionName)
 
@@ -0,0 +1,278 @@
+package database
+
+import (
+	"clase_6_echo_mongo/config"
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DiferenciaIndice describe un índice existente que no coincide con config.Indices.
+// Nunca se corrige sola: borrar o recrear un índice en producción es una decisión manual.
+type DiferenciaIndice struct {
+	Coleccion string
+	Indice    string
+	Detalle   string
+}
+
+func (d DiferenciaIndice) String() string {
+	return fmt.Sprintf("%s.%s: %s", d.Coleccion, d.Indice, d.Detalle)
+}
+
+// indiceExistente es la forma en que listIndexes devuelve cada índice
+type indiceExistente struct {
+	Nombre  string `bson:"name"`
+	Campos  bson.D `bson:"key"`
+	Unico   bool   `bson:"unique"`
+	Expira  *int64 `bson:"expireAfterSeconds"`
+	Parcial bson.M `bson:"partialFilterExpression"`
+	Pesos   bson.M `bson:"weights"`
+}
+
+// AplicarIndices crea los índices declarados que faltan en las colecciones indicadas (todas si no se indica ninguna)
+// y devuelve las diferencias con los que ya existen
+func (c *MongoDBClient) AplicarIndices(ctx context.Context, dbName string, colecciones ...string) ([]DiferenciaIndice, error) {
+	return aplicarIndices(ctx, c.Client.Database(dbName), config.Collections, config.Indices, colecciones...)
+}
+
+func aplicarIndices(ctx context.Context, db *mongo.Database, collections map[string]string, indices map[string][]config.Indice, soloColecciones ...string) ([]DiferenciaIndice, error) {
+	logicos := make([]string, 0, len(indices))
+	for logico := range indices {
+		logicos = append(logicos, logico)
+	}
+	sort.Strings(logicos)
+
+	diferencias := []DiferenciaIndice{}
+	for _, logico := range logicos {
+		nombre, ok := collections[logico]
+		if !ok {
+			return nil, fmt.Errorf("índices declarados para la colección desconocida %s", logico)
+		}
+		if len(soloColecciones) > 0 && !contiene(soloColecciones, nombre) {
+			continue
+		}
+		encontradas, err := aplicarIndicesColeccion(ctx, db.Collection(nombre), indices[logico])
+		if err != nil {
+			return nil, fmt.Errorf("error revisando índices de %s: %w", nombre, err)
+		}
+		diferencias = append(diferencias, encontradas...)
+	}
+	return diferencias, nil
+}
+
+func aplicarIndicesColeccion(ctx context.Context, coleccion *mongo.Collection, declarados []config.Indice) ([]DiferenciaIndice, error) {
+	cursor, err := coleccion.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var existentes []indiceExistente
+	if err := cursor.All(ctx, &existentes); err != nil {
+		return nil, err
+	}
+	porNombre := make(map[string]indiceExistente, len(existentes))
+	for _, existente := range existentes {
+		porNombre[existente.Nombre] = existente
+	}
+
+	diferencias := []DiferenciaIndice{}
+	diferencia := func(indice, detalle string) {
+		diferencias = append(diferencias, DiferenciaIndice{Coleccion: coleccion.Name(), Indice: indice, Detalle: detalle})
+	}
+
+	declaradosPorNombre := make(map[string]bool, len(declarados))
+	for _, declarado := range declarados {
+		declaradosPorNombre[declarado.Nombre] = true
+
+		if existente, ok := porNombre[declarado.Nombre]; ok {
+			for _, detalle := range compararIndice(declarado, existente) {
+				diferencia(declarado.Nombre, detalle)
+			}
+			continue
+		}
+		// Mismas claves con otro nombre: MongoDB rechazaría crearlo de nuevo
+		if otro, ok := mismasClaves(declarado, existentes); ok {
+			diferencia(declarado.Nombre, fmt.Sprintf("existe con el nombre '%s'", otro))
+			continue
+		}
+
+		if _, err := coleccion.Indexes().CreateOne(ctx, modeloIndice(declarado)); err != nil {
+			// Por ejemplo, datos repetidos que impiden un índice único
+			diferencia(declarado.Nombre, fmt.Sprintf("no se pudo crear: %v", err))
+			continue
+		}
+		log.Printf("Índice %s.%s creado", coleccion.Name(), declarado.Nombre)
+	}
+
+	for _, existente := range existentes {
+		if existente.Nombre != "_id_" && !declaradosPorNombre[existente.Nombre] {
+			diferencia(existente.Nombre, "no está declarado")
+		}
+	}
+	return diferencias, nil
+}
+
+func modeloIndice(indice config.Indice) mongo.IndexModel {
+	opciones := options.Index().SetName(indice.Nombre)
+	if indice.Unico {
+		opciones.SetUnique(true)
+	}
+	if indice.Expira != nil {
+		opciones.SetExpireAfterSeconds(int32(*indice.Expira / time.Second))
+	}
+	if indice.Parcial != nil {
+		opciones.SetPartialFilterExpression(indice.Parcial)
+	}
+	if esIndiceTexto(indice) {
+		opciones.SetDefaultLanguage("spanish")
+		if indice.Pesos != nil {
+			opciones.SetWeights(indice.Pesos)
+		}
+	}
+	return mongo.IndexModel{Keys: indice.Campos, Options: opciones}
+}
+
+// compararIndice devuelve en qué difiere el índice existente del declarado
+func compararIndice(declarado config.Indice, existente indiceExistente) []string {
+	detalles := []string{}
+	if !mismosCampos(declarado, existente) {
+		detalles = append(detalles, fmt.Sprintf("campos %s, se esperaba %s", formatoCampos(existente.Campos), formatoCampos(declarado.Campos)))
+	}
+	if declarado.Unico != existente.Unico {
+		detalles = append(detalles, fmt.Sprintf("unique=%t, se esperaba %t", existente.Unico, declarado.Unico))
+	}
+
+	esperado := "sin TTL"
+	if declarado.Expira != nil {
+		esperado = fmt.Sprintf("TTL %ds", int64(*declarado.Expira/time.Second))
+	}
+	actual := "sin TTL"
+	if existente.Expira != nil {
+		actual = fmt.Sprintf("TTL %ds", *existente.Expira)
+	}
+	if esperado != actual {
+		detalles = append(detalles, fmt.Sprintf("%s, se esperaba %s", actual, esperado))
+	}
+
+	if !reflect.DeepEqual(normalizar(existente.Parcial), normalizar(declarado.Parcial)) {
+		detalles = append(detalles, fmt.Sprintf("filtro parcial %v, se esperaba %v", existente.Parcial, declarado.Parcial))
+	}
+	if esIndiceTexto(declarado) && declarado.Pesos != nil && !reflect.DeepEqual(pesos(existente.Pesos), pesos(declarado.Pesos)) {
+		detalles = append(detalles, fmt.Sprintf("pesos %v, se esperaba %v", existente.Pesos, declarado.Pesos))
+	}
+	return detalles
+}
+
+// mismasClaves busca un índice existente con las mismas claves que el declarado
+func mismasClaves(declarado config.Indice, existentes []indiceExistente) (string, bool) {
+	for _, existente := range existentes {
+		if mismosCampos(declarado, existente) {
+			return existente.Nombre, true
+		}
+	}
+	return "", false
+}
+
+func mismosCampos(declarado config.Indice, existente indiceExistente) bool {
+	// MongoDB guarda los índices de texto como {_fts: "text", _ftsx: 1} y los campos quedan en weights
+	if esIndiceTexto(declarado) {
+		if existente.Pesos == nil {
+			return false
+		}
+		campos := map[string]bool{}
+		for _, campo := range declarado.Campos {
+			if campo.Value == "text" {
+				campos[campo.Key] = true
+			}
+		}
+		if len(campos) != len(existente.Pesos) {
+			return false
+		}
+		for campo := range existente.Pesos {
+			if !campos[campo] {
+				return false
+			}
+		}
+		return true
+	}
+
+	if len(declarado.Campos) != len(existente.Campos) {
+		return false
+	}
+	for i, campo := range declarado.Campos {
+		if campo.Key != existente.Campos[i].Key || fmt.Sprint(numero(campo.Value)) != fmt.Sprint(numero(existente.Campos[i].Value)) {
+			return false
+		}
+	}
+	return true
+}
+
+func esIndiceTexto(indice config.Indice) bool {
+	for _, campo := range indice.Campos {
+		if campo.Value == "text" {
+			return true
+		}
+	}
+	return false
+}
+
+// numero unifica int, int32, int64 y float64 para comparar la dirección de cada campo
+func numero(valor interface{}) interface{} {
+	switch v := valor.(type) {
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return valor
+}
+
+func pesos(valores bson.M) map[string]interface{} {
+	resultado := make(map[string]interface{}, len(valores))
+	for campo, peso := range valores {
+		resultado[campo] = numero(peso)
+	}
+	return resultado
+}
+
+// normalizar pasa el documento por BSON para que los tipos coincidan con los leídos desde MongoDB
+func normalizar(documento bson.M) bson.M {
+	if len(documento) == 0 {
+		return nil
+	}
+	datos, err := bson.Marshal(documento)
+	if err != nil {
+		return documento
+	}
+	var resultado bson.M
+	if err := bson.Unmarshal(datos, &resultado); err != nil {
+		return documento
+	}
+	return resultado
+}
+
+func formatoCampos(campos bson.D) string {
+	partes := make([]string, 0, len(campos))
+	for _, campo := range campos {
+		partes = append(partes, fmt.Sprintf("%s:%v", campo.Key, campo.Value))
+	}
+	return "{" + strings.Join(partes, ", ") + "}"
+}
+
+func contiene(lista []string, buscado string) bool {
+	for _, valor := range lista {
+		if valor == buscado {
+			return true
+		}
+	}
+	return false
+}
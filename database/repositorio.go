@@ -0,0 +1,129 @@
+package database
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Repository entrega operaciones tipadas sobre una colección,
+// decodificando los documentos directamente en T (ej. modelos.Producto)
+type Repository[T any] struct {
+	collection *mongo.Collection
+}
+
+// NewRepository crea un repositorio para la colección indicada
+func NewRepository[T any](c *MongoDBClient, dbName, collectionName string) *Repository[T] {
+	return &Repository[T]{collection: c.GetCollection(dbName, collectionName)}
+}
+
+// Collection devuelve la colección subyacente para operaciones no cubiertas por el repositorio
+func (r *Repository[T]) Collection() *mongo.Collection {
+	return r.collection
+}
+
+// Find devuelve todos los documentos que cumplen el filtro. Nunca devuelve un slice nil.
+func (r *Repository[T]) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) ([]T, error) {
+	if filter == nil {
+		filter = bson.M{}
+	}
+	cursor, err := r.collection.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	resultados := []T{}
+	if err := cursor.All(ctx, &resultados); err != nil {
+		return nil, err
+	}
+	return resultados, nil
+}
+
+// FindOne devuelve el primer documento que cumple el filtro o mongo.ErrNoDocuments
+func (r *Repository[T]) FindOne(ctx context.Context, filter interface{}) (*T, error) {
+	resultado := new(T)
+	if err := r.collection.FindOne(ctx, filter).Decode(resultado); err != nil {
+		return nil, err
+	}
+	return resultado, nil
+}
+
+// FindByID busca por _id a partir de su representación hexadecimal
+func (r *Repository[T]) FindByID(ctx context.Context, id string) (*T, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	return r.FindOne(ctx, bson.M{"_id": objID})
+}
+
+// Insert inserta el documento y devuelve el _id asignado
+func (r *Repository[T]) Insert(ctx context.Context, documento *T) (primitive.ObjectID, error) {
+	resultado, err := r.collection.InsertOne(ctx, documento)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	objID, _ := resultado.InsertedID.(primitive.ObjectID)
+	return objID, nil
+}
+
+// Update aplica $set con los campos entregados. Devuelve mongo.ErrNoDocuments si el _id no existe.
+func (r *Repository[T]) Update(ctx context.Context, id string, updateFields bson.M) (*mongo.UpdateResult, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	resultado, err := r.collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.D{{Key: "$set", Value: updateFields}})
+	if err != nil {
+		return nil, err
+	}
+	if resultado.MatchedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return resultado, nil
+}
+
+// Delete elimina por _id. Devuelve mongo.ErrNoDocuments si no se eliminó nada.
+func (r *Repository[T]) Delete(ctx context.Context, id string) (*mongo.DeleteResult, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	resultado, err := r.collection.DeleteOne(ctx, bson.M{"_id": objID})
+	if err != nil {
+		return nil, err
+	}
+	if resultado.DeletedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return resultado, nil
+}
+
+// Count cuenta los documentos que cumplen el filtro
+func (r *Repository[T]) Count(ctx context.Context, filter interface{}) (int64, error) {
+	if filter == nil {
+		filter = bson.M{}
+	}
+	return r.collection.CountDocuments(ctx, filter)
+}
+
+// Aggregate ejecuta un pipeline y decodifica cada resultado en T
+func (r *Repository[T]) Aggregate(ctx context.Context, pipeline mongo.Pipeline) ([]T, error) {
+	return Aggregate[T](ctx, r.collection, pipeline)
+}
+
+// Aggregate ejecuta un pipeline sobre cualquier colección y decodifica en R.
+// Útil cuando el resultado tiene otra forma que el documento guardado (ej. tras un $lookup).
+func Aggregate[R any](ctx context.Context, collection *mongo.Collection, pipeline mongo.Pipeline) ([]R, error) {
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	resultados := []R{}
+	if err := cursor.All(ctx, &resultados); err != nil {
+		return nil, err
+	}
+	return resultados, nil
+}
@@ -0,0 +1,374 @@
+package jwt
+
+import (
+	"clase_6_echo_mongo/database"
+	"clase_6_echo_mongo/modelos"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Algoritmos de firma soportados
+const (
+	AlgoritmoRS256 = "RS256"
+	AlgoritmoEdDSA = "EdDSA"
+	AlgoritmoHS256 = "HS256"
+)
+
+// Cada cuánto se vuelven a leer las claves desde MongoDB, para ver las que crearon otras réplicas
+const recargaClaves = time.Minute
+
+// Mínimo entre dos recargas provocadas por un kid desconocido; sin él cada token con un kid
+// inventado costaría una consulta a MongoDB
+const recargaForzadaMinima = 10 * time.Second
+
+// claveCargada es una ClaveFirma con sus claves ya decodificadas
+type claveCargada struct {
+	modelos.ClaveFirma
+	privada crypto.Signer
+	publica crypto.PublicKey
+}
+
+// Servicio firma y valida los tokens. Con RS256 o EdDSA las claves se guardan en MongoDB,
+// rotan cada JWT_ROTACION y se siguen aceptando durante JWT_GRACIA después de dejar de firmar.
+type Servicio struct {
+	claves    *database.Repository[modelos.ClaveFirma]
+	algoritmo string
+	rotacion  time.Duration
+	gracia    time.Duration
+	secreto   []byte // SECRET_JWT, solo para HS256
+	legadoHS  bool   // Aceptar tokens HS256 mientras se migra a claves asimétricas
+
+	mu       sync.RWMutex
+	cache    []claveCargada
+	cargadas time.Time
+	forzada  time.Time // Última recarga por kid desconocido
+}
+
+// NuevoServicio lee JWT_ALGORITMO (RS256 por defecto, EdDSA o HS256), JWT_ROTACION (720h),
+// JWT_GRACIA (48h), SECRET_JWT y JWT_HS256_LEGADO, y deja lista la clave del periodo actual
+func NuevoServicio(mongoClient *database.MongoDBClient, dbName, collectionName string) (*Servicio, error) {
+	s := &Servicio{
+		claves:    database.NewRepository[modelos.ClaveFirma](mongoClient, dbName, collectionName),
+		algoritmo: os.Getenv("JWT_ALGORITMO"),
+		rotacion:  duracionDesdeEntorno("JWT_ROTACION", 30*24*time.Hour),
+		gracia:    duracionDesdeEntorno("JWT_GRACIA", 48*time.Hour),
+		secreto:   []byte(os.Getenv("SECRET_JWT")),
+	}
+	s.legadoHS, _ = strconv.ParseBool(os.Getenv("JWT_HS256_LEGADO"))
+
+	if s.algoritmo == "" {
+		s.algoritmo = AlgoritmoRS256
+	}
+	switch s.algoritmo {
+	case AlgoritmoRS256, AlgoritmoEdDSA:
+	case AlgoritmoHS256:
+		s.legadoHS = true
+	default:
+		return nil, fmt.Errorf("JWT_ALGORITMO '%s' no soportado, use RS256, EdDSA o HS256", s.algoritmo)
+	}
+	if s.legadoHS && len(s.secreto) == 0 {
+		return nil, errors.New("SECRET_JWT es requerido para firmar o validar tokens HS256")
+	}
+	// Un token firmado justo antes de la rotación debe poder validarse hasta que expire
+	if s.gracia < DuracionAcceso() {
+		return nil, errors.New("JWT_GRACIA no puede ser menor que JWT_DURACION_ACCESO")
+	}
+	if s.algoritmo == AlgoritmoHS256 {
+		return s, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// El índice único {algoritmo, periodo} de config.Indices evita que dos réplicas creen claves distintas para el mismo periodo
+	if _, err := s.claveActiva(ctx); err != nil {
+		return nil, fmt.Errorf("error preparando la clave de firma: %w", err)
+	}
+	return s, nil
+}
+
+// cargar lee desde MongoDB las claves que aún no expiran; sin forzar usa la copia en memoria reciente
+func (s *Servicio) cargar(ctx context.Context, forzar bool) ([]claveCargada, error) {
+	s.mu.RLock()
+	if !forzar && time.Since(s.cargadas) < recargaClaves {
+		cache := s.cache
+		s.mu.RUnlock()
+		return cache, nil
+	}
+	s.mu.RUnlock()
+
+	documentos, err := s.claves.Find(ctx, bson.M{"expira": bson.M{"$gt": time.Now()}},
+		options.Find().SetSort(bson.D{{Key: "creada", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	cargadas := make([]claveCargada, 0, len(documentos))
+	for _, documento := range documentos {
+		clave, err := decodificarClave(documento)
+		if err != nil {
+			return nil, fmt.Errorf("clave %s inválida: %w", documento.Kid, err)
+		}
+		cargadas = append(cargadas, clave)
+	}
+
+	s.mu.Lock()
+	s.cache, s.cargadas = cargadas, time.Now()
+	s.mu.Unlock()
+	return cargadas, nil
+}
+
+func decodificarClave(documento modelos.ClaveFirma) (claveCargada, error) {
+	privada, err := x509.ParsePKCS8PrivateKey(documento.Privada)
+	if err != nil {
+		return claveCargada{}, err
+	}
+	firmante, ok := privada.(crypto.Signer)
+	if !ok {
+		return claveCargada{}, errors.New("tipo de clave privada no soportado")
+	}
+	publica, err := x509.ParsePKIXPublicKey(documento.Publica)
+	if err != nil {
+		return claveCargada{}, err
+	}
+	return claveCargada{ClaveFirma: documento, privada: firmante, publica: publica}, nil
+}
+
+// claveActiva devuelve la clave que firma en el periodo actual, creándola si es la primera vez
+func (s *Servicio) claveActiva(ctx context.Context) (*claveCargada, error) {
+	periodo := time.Now().Unix() / int64(s.rotacion.Seconds())
+
+	buscar := func(claves []claveCargada) *claveCargada {
+		for i := range claves {
+			if claves[i].Algoritmo == s.algoritmo && claves[i].Periodo == periodo {
+				return &claves[i]
+			}
+		}
+		return nil
+	}
+
+	claves, err := s.cargar(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	if clave := buscar(claves); clave != nil {
+		return clave, nil
+	}
+
+	if err := s.crearClave(ctx, periodo); err != nil {
+		return nil, err
+	}
+	claves, err = s.cargar(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	if clave := buscar(claves); clave != nil {
+		return clave, nil
+	}
+	return nil, errors.New("no se encontró la clave del periodo actual")
+}
+
+// crearClave genera el par de claves del periodo. Si otra réplica se adelantó se conserva la suya.
+func (s *Servicio) crearClave(ctx context.Context, periodo int64) error {
+	var privada crypto.Signer
+	var err error
+	switch s.algoritmo {
+	case AlgoritmoRS256:
+		privada, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgoritmoEdDSA:
+		_, privada, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return err
+	}
+
+	privadaDER, err := x509.MarshalPKCS8PrivateKey(privada)
+	if err != nil {
+		return err
+	}
+	publicaDER, err := x509.MarshalPKIXPublicKey(privada.Public())
+	if err != nil {
+		return err
+	}
+	huella := sha256.Sum256(publicaDER)
+
+	activaHasta := time.Unix((periodo+1)*int64(s.rotacion.Seconds()), 0)
+	clave := modelos.ClaveFirma{
+		Kid:         hex.EncodeToString(huella[:8]),
+		Algoritmo:   s.algoritmo,
+		Periodo:     periodo,
+		Privada:     privadaDER,
+		Publica:     publicaDER,
+		Creada:      time.Now(),
+		ActivaHasta: activaHasta,
+		Expira:      activaHasta.Add(s.gracia),
+	}
+
+	_, err = s.claves.Collection().UpdateOne(ctx,
+		bson.M{"algoritmo": s.algoritmo, "periodo": periodo},
+		bson.M{"$setOnInsert": clave},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+
+	// Las claves que ya no validan ningún token se eliminan al rotar
+	_, err = s.claves.Collection().DeleteMany(ctx, bson.M{"expira": bson.M{"$lte": time.Now()}})
+	return err
+}
+
+// permitirRecargaForzada limita las recargas por kid desconocido a una cada recargaForzadaMinima
+func (s *Servicio) permitirRecargaForzada() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.forzada) < recargaForzadaMinima {
+		return false
+	}
+	s.forzada = time.Now()
+	return true
+}
+
+// firmar emite el token con la clave activa, o con SECRET_JWT en modo HS256. tipo va en la cabecera typ.
+func (s *Servicio) firmar(claims jwt.MapClaims, tipo string) (string, error) {
+	if s.algoritmo == AlgoritmoHS256 {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		token.Header["typ"] = tipo
+		return token.SignedString(s.secreto)
+	}
+
+	clave, err := s.claveActiva(context.TODO())
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(clave.Algoritmo), claims)
+	token.Header["kid"] = clave.Kid
+	token.Header["typ"] = tipo
+	return token.SignedString(clave.privada)
+}
+
+// claveVerificacion elige la clave según el algoritmo y el kid del token.
+// El algoritmo de la clave debe coincidir con el del token para impedir confusiones de algoritmo.
+func (s *Servicio) claveVerificacion(token *jwt.Token) (interface{}, error) {
+	alg := token.Method.Alg()
+	if alg == AlgoritmoHS256 {
+		if !s.legadoHS {
+			return nil, errors.New("tokens HS256 no aceptados")
+		}
+		return s.secreto, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("el token no indica kid")
+	}
+	buscar := func(claves []claveCargada) *claveCargada {
+		for i := range claves {
+			if claves[i].Kid == kid {
+				return &claves[i]
+			}
+		}
+		return nil
+	}
+
+	claves, err := s.cargar(context.TODO(), false)
+	if err != nil {
+		return nil, err
+	}
+	clave := buscar(claves)
+	if clave == nil && s.permitirRecargaForzada() {
+		// Puede ser una clave recién creada por otra réplica
+		if claves, err = s.cargar(context.TODO(), true); err != nil {
+			return nil, err
+		}
+		clave = buscar(claves)
+	}
+	if clave == nil || time.Now().After(clave.Expira) {
+		return nil, errors.New("kid desconocido o expirado")
+	}
+	if clave.Algoritmo != alg {
+		return nil, errors.New("el algoritmo del token no corresponde a su clave")
+	}
+	return clave.publica, nil
+}
+
+// validar verifica firma, expiración, emisor, audiencia y tipo del token y devuelve sus claims
+func (s *Servicio) validar(tokenString, tipo, audiencia string) (Claims, error) {
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, s.claveVerificacion,
+		jwt.WithValidMethods([]string{AlgoritmoRS256, AlgoritmoEdDSA, AlgoritmoHS256}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(Emisor()),
+		jwt.WithAudience(audiencia),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if typ, _ := token.Header["typ"].(string); typ != tipo {
+		return nil, errors.New("el tipo del token no corresponde")
+	}
+	return claims, nil
+}
+
+// JWK es una clave pública en formato JSON Web Key (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`   // RSA
+	E   string `json:"e,omitempty"`   // RSA
+	Crv string `json:"crv,omitempty"` // EdDSA
+	X   string `json:"x,omitempty"`   // EdDSA
+}
+
+// JWKS devuelve las claves públicas vigentes, incluidas las que están en periodo de gracia
+func (s *Servicio) JWKS(ctx context.Context) ([]JWK, error) {
+	if s.algoritmo == AlgoritmoHS256 {
+		return []JWK{}, nil
+	}
+
+	claves, err := s.cargar(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	codificar := base64.RawURLEncoding.EncodeToString
+	jwks := make([]JWK, 0, len(claves))
+	for _, clave := range claves {
+		jwk := JWK{Kid: clave.Kid, Alg: clave.Algoritmo, Use: "sig"}
+		switch publica := clave.publica.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = codificar(publica.N.Bytes())
+			jwk.E = codificar(big.NewInt(int64(publica.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = codificar(publica)
+		default:
+			continue
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks, nil
+}
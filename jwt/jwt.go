@@ -1,29 +1,112 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"os"
 	"time"
 
 	jwt "github.com/golang-jwt/jwt/v5"
 )
 
-func GenerarJWT(correo, nombre, id string) (string, error) {
-	miClave := []byte(os.Getenv("SECRET_JWT"))
+// Claims son los datos de un token validado
+type Claims = jwt.MapClaims
 
-	/*if len(miClave) == 0 {
-		return nil,
-	}*/
+// DuracionAcceso es la vigencia del token de acceso (JWT_DURACION_ACCESO, por defecto 15 minutos)
+func DuracionAcceso() time.Duration {
+	return duracionDesdeEntorno("JWT_DURACION_ACCESO", 15*time.Minute)
+}
+
+// DuracionRefresh es la vigencia del refresh token (JWT_DURACION_REFRESH, por defecto 30 días)
+func DuracionRefresh() time.Duration {
+	return duracionDesdeEntorno("JWT_DURACION_REFRESH", 30*24*time.Hour)
+}
+
+func duracionDesdeEntorno(variable string, porDefecto time.Duration) time.Duration {
+	if duracion, err := time.ParseDuration(os.Getenv(variable)); err == nil && duracion > 0 {
+		return duracion
+	}
+	return porDefecto
+}
+
+// Tipo (cabecera typ) y audiencia de cada clase de token. Ambos se firman con las claves publicadas en la JWKS,
+// así que otro servicio debe poder distinguir el token de acceso del que solo completa el segundo factor.
+const (
+	TipoAcceso            = "at+jwt" // RFC 9068
+	TipoMFAPendiente      = "mfa-pendiente+jwt"
+	AudienciaAcceso       = "api"
+	AudienciaMFAPendiente = "login-2fa"
+)
+
+// Emisor es el claim iss de los tokens (JWT_EMISOR, por defecto clase_6_echo_mongo)
+func Emisor() string {
+	if emisor := os.Getenv("JWT_EMISOR"); emisor != "" {
+		return emisor
+	}
+	return "clase_6_echo_mongo"
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+// DuracionMFAPendiente es la vigencia del token que se canjea junto al código TOTP en el segundo paso del login
+const DuracionMFAPendiente = 5 * time.Minute
+
+// nuevoJti genera el identificador único de un token, permite revocarlo antes de que expire
+func nuevoJti() (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(jti), nil
+}
+
+// GenerarJWT emite el token de acceso. mfa indica que la sesión se inició con segundo factor
+// y version es la versión de tokens del usuario, que ValidarJWT compara con la guardada.
+func (s *Servicio) GenerarJWT(correo, nombre, id, rol string, mfa bool, version int) (string, error) {
+	jti, err := nuevoJti()
+	if err != nil {
+		return "", err
+	}
+
+	return s.firmar(jwt.MapClaims{
 		"correo":         correo,
 		"nombre":         nombre,
 		"generado_desde": "https://www.cesarcancino.com",
 		"id":             id,
+		"rol":            rol,
+		"jti":            jti,
+		"mfa":            mfa,
+		"ver":            version,
+		"iss":            Emisor(),
+		"aud":            AudienciaAcceso,
 		"iat":            time.Now().Unix(),
-		"exp":            time.Now().Add(time.Hour * 24).Unix(), // 24 horas
-	})
+		"exp":            time.Now().Add(DuracionAcceso()).Unix(),
+	}, TipoAcceso)
+}
 
-	tokenString, err := token.SignedString(miClave)
+// ValidarAcceso verifica un token emitido por GenerarJWT y devuelve sus claims
+func (s *Servicio) ValidarAcceso(tokenString string) (Claims, error) {
+	return s.validar(tokenString, TipoAcceso, AudienciaAcceso)
+}
+
+// GenerarJWTPendienteMFA emite el token de corta duración que entrega el login cuando falta el código TOTP.
+// No sirve como token de acceso: su tipo y audiencia son otros.
+func (s *Servicio) GenerarJWTPendienteMFA(id string) (string, error) {
+	jti, err := nuevoJti()
+	if err != nil {
+		return "", err
+	}
+
+	return s.firmar(jwt.MapClaims{
+		"id":            id,
+		"mfa_pendiente": true,
+		"jti":           jti,
+		"iss":           Emisor(),
+		"aud":           AudienciaMFAPendiente,
+		"iat":           time.Now().Unix(),
+		"exp":           time.Now().Add(DuracionMFAPendiente).Unix(),
+	}, TipoMFAPendiente)
+}
 
-	return tokenString, err
+// ValidarJWTPendienteMFA verifica un token emitido por GenerarJWTPendienteMFA y devuelve sus claims
+func (s *Servicio) ValidarJWTPendienteMFA(tokenString string) (Claims, error) {
+	return s.validar(tokenString, TipoMFAPendiente, AudienciaMFAPendiente)
 }
@@ -0,0 +1,205 @@
+package jwt
+
+import (
+	"clase_6_echo_mongo/modelos"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// claveDePrueba arma una claveCargada como las que decodifica cargar desde MongoDB
+func claveDePrueba(t *testing.T, kid, algoritmo string, privada crypto.Signer, periodo int64, expira time.Time) claveCargada {
+	t.Helper()
+	privadaDER, err := x509.MarshalPKCS8PrivateKey(privada)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicaDER, err := x509.MarshalPKIXPublicKey(privada.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	clave, err := decodificarClave(modelos.ClaveFirma{
+		Kid:       kid,
+		Algoritmo: algoritmo,
+		Periodo:   periodo,
+		Privada:   privadaDER,
+		Publica:   publicaDER,
+		Expira:    expira,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clave
+}
+
+// servicioDePrueba firma con EdDSA y valida además una clave RS256 vigente y una expirada. Las claves
+// quedan en la caché y la recarga forzada recién usada, así que nunca se consulta MongoDB.
+func servicioDePrueba(t *testing.T) (*Servicio, *rsa.PrivateKey) {
+	t.Helper()
+	_, privadaEd, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privadaRSA, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, privadaVieja, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotacion := 24 * time.Hour
+	periodo := time.Now().Unix() / int64(rotacion.Seconds())
+	vigente := time.Now().Add(time.Hour)
+	return &Servicio{
+		algoritmo: AlgoritmoEdDSA,
+		rotacion:  rotacion,
+		gracia:    48 * time.Hour,
+		secreto:   []byte("secreto-de-prueba"),
+		cache: []claveCargada{
+			claveDePrueba(t, "ed-1", AlgoritmoEdDSA, privadaEd, periodo, vigente),
+			claveDePrueba(t, "rsa-1", AlgoritmoRS256, privadaRSA, periodo-1, vigente),
+			claveDePrueba(t, "vieja", AlgoritmoEdDSA, privadaVieja, periodo-2, time.Now().Add(-time.Minute)),
+		},
+		cargadas: time.Now(),
+		forzada:  time.Now(),
+	}, privadaRSA
+}
+
+// firmarPrueba firma claims arbitrarios con la cabecera indicada
+func firmarPrueba(t *testing.T, metodo jwt.SigningMethod, clave interface{}, cabecera map[string]interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(metodo, claims)
+	for nombre, valor := range cabecera {
+		token.Header[nombre] = valor
+	}
+	firmado, err := token.SignedString(clave)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return firmado
+}
+
+func claimsAcceso() jwt.MapClaims {
+	return jwt.MapClaims{
+		"id":  "usuario",
+		"iss": Emisor(),
+		"aud": AudienciaAcceso,
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+}
+
+// conClaims devuelve los claims de acceso con los reemplazos indicados; un valor nil quita el claim
+func conClaims(reemplazos jwt.MapClaims) jwt.MapClaims {
+	claims := claimsAcceso()
+	for nombre, valor := range reemplazos {
+		if valor == nil {
+			delete(claims, nombre)
+			continue
+		}
+		claims[nombre] = valor
+	}
+	return claims
+}
+
+func TestGenerarYValidar(t *testing.T) {
+	s, _ := servicioDePrueba(t)
+
+	token, err := s.GenerarJWT("ana@example.com", "Ana", "usuario", modelos.RolAdmin, true, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := s.ValidarAcceso(token)
+	if err != nil {
+		t.Fatalf("ValidarAcceso: %v", err)
+	}
+	if claims["id"] != "usuario" || claims["mfa"] != true {
+		t.Fatalf("claims inesperados: %v", claims)
+	}
+	if _, err := s.ValidarJWTPendienteMFA(token); err == nil {
+		t.Fatal("un token de acceso validó como token pendiente de MFA")
+	}
+
+	pendiente, err := s.GenerarJWTPendienteMFA("usuario")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ValidarJWTPendienteMFA(pendiente); err != nil {
+		t.Fatalf("ValidarJWTPendienteMFA: %v", err)
+	}
+	if _, err := s.ValidarAcceso(pendiente); err == nil {
+		t.Fatal("un token pendiente de MFA validó como token de acceso")
+	}
+}
+
+func TestValidarAccesoRechazos(t *testing.T) {
+	s, privadaRSA := servicioDePrueba(t)
+	ed := s.cache[0].privada
+	vieja := s.cache[2].privada
+	acceso := map[string]interface{}{"typ": TipoAcceso}
+	conKid := func(kid string) map[string]interface{} {
+		return map[string]interface{}{"typ": TipoAcceso, "kid": kid}
+	}
+
+	casos := []struct {
+		nombre string
+		token  string
+		error  string // "" si el token debe validar
+	}{
+		{nombre: "RS256 con su clave", token: firmarPrueba(t, jwt.SigningMethodRS256, privadaRSA, conKid("rsa-1"), claimsAcceso())},
+		{nombre: "sin kid", token: firmarPrueba(t, jwt.SigningMethodEdDSA, ed, acceso, claimsAcceso()), error: "kid"},
+		{nombre: "kid desconocido", token: firmarPrueba(t, jwt.SigningMethodEdDSA, ed, conKid("otra"), claimsAcceso()), error: "kid desconocido"},
+		{nombre: "clave expirada", token: firmarPrueba(t, jwt.SigningMethodEdDSA, vieja, conKid("vieja"), claimsAcceso()), error: "expirado"},
+		{nombre: "algoritmo distinto al de la clave", token: firmarPrueba(t, jwt.SigningMethodRS256, privadaRSA, conKid("ed-1"), claimsAcceso()), error: "algoritmo"},
+		{nombre: "HS256 sin modo legado", token: firmarPrueba(t, jwt.SigningMethodHS256, s.secreto, acceso, claimsAcceso()), error: "HS256"},
+		{nombre: "HS256 firmado con la clave pública", token: firmarPrueba(t, jwt.SigningMethodHS256, s.cache[1].Publica, conKid("rsa-1"), claimsAcceso()), error: "HS256"},
+		{nombre: "alg none", token: firmarPrueba(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, conKid("ed-1"), claimsAcceso()), error: "signing method"},
+		{nombre: "typ de MFA pendiente", token: firmarPrueba(t, jwt.SigningMethodEdDSA, ed, map[string]interface{}{"typ": TipoMFAPendiente, "kid": "ed-1"}, claimsAcceso()), error: "tipo"},
+		{nombre: "sin typ", token: firmarPrueba(t, jwt.SigningMethodEdDSA, ed, map[string]interface{}{"kid": "ed-1"}, claimsAcceso()), error: "tipo"},
+		{nombre: "audiencia de MFA pendiente", token: firmarPrueba(t, jwt.SigningMethodEdDSA, ed, conKid("ed-1"), conClaims(jwt.MapClaims{"aud": AudienciaMFAPendiente})), error: "audience"},
+		{nombre: "sin audiencia", token: firmarPrueba(t, jwt.SigningMethodEdDSA, ed, conKid("ed-1"), conClaims(jwt.MapClaims{"aud": nil})), error: "aud"},
+		{nombre: "otro emisor", token: firmarPrueba(t, jwt.SigningMethodEdDSA, ed, conKid("ed-1"), conClaims(jwt.MapClaims{"iss": "otro"})), error: "issuer"},
+		{nombre: "sin exp", token: firmarPrueba(t, jwt.SigningMethodEdDSA, ed, conKid("ed-1"), conClaims(jwt.MapClaims{"exp": nil})), error: "exp"},
+		{nombre: "expirado", token: firmarPrueba(t, jwt.SigningMethodEdDSA, ed, conKid("ed-1"), conClaims(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})), error: "expired"},
+	}
+	for _, caso := range casos {
+		t.Run(caso.nombre, func(t *testing.T) {
+			_, err := s.ValidarAcceso(caso.token)
+			if caso.error == "" {
+				if err != nil {
+					t.Fatalf("ValidarAcceso: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), caso.error) {
+				t.Fatalf("error = %v, se esperaba uno con %q", err, caso.error)
+			}
+		})
+	}
+}
+
+func TestHS256Legado(t *testing.T) {
+	s, _ := servicioDePrueba(t)
+	s.legadoHS = true
+
+	// Durante la migración se aceptan los tokens HS256, pero siguen sujetos al tipo y la audiencia
+	token := firmarPrueba(t, jwt.SigningMethodHS256, s.secreto, map[string]interface{}{"typ": TipoAcceso}, claimsAcceso())
+	if _, err := s.ValidarAcceso(token); err != nil {
+		t.Fatalf("ValidarAcceso: %v", err)
+	}
+	if _, err := s.ValidarJWTPendienteMFA(token); err == nil {
+		t.Fatal("un token HS256 de acceso validó como token pendiente de MFA")
+	}
+	otroSecreto := firmarPrueba(t, jwt.SigningMethodHS256, []byte("otro"), map[string]interface{}{"typ": TipoAcceso}, claimsAcceso())
+	if _, err := s.ValidarAcceso(otroSecreto); err == nil {
+		t.Fatal("se aceptó un token HS256 firmado con otro secreto")
+	}
+}
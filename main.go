@@ -1,12 +1,22 @@
 package main
 
 import (
+	"clase_6_echo_mongo/almacenamiento"
+	"clase_6_echo_mongo/comandos"
 	"clase_6_echo_mongo/config"
+	"clase_6_echo_mongo/correo"
 	"clase_6_echo_mongo/database"
+	"clase_6_echo_mongo/jwt"
 	"clase_6_echo_mongo/middleware_custom"
+	"clase_6_echo_mongo/migraciones"
+	"clase_6_echo_mongo/modelos"
+	"clase_6_echo_mongo/oidc"
 	"clase_6_echo_mongo/rutas"
+	"clase_6_echo_mongo/utilidades"
+	"context"
 	"log"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 	echo "github.com/labstack/echo/v4"
@@ -38,24 +48,131 @@ func main() {
 		log.Fatal("Las variables de entorno no están bien definidas!")
 	}
 
-	// Conectar a MongoDB y crear colecciones
+	// Conectar a MongoDB y crear colecciones. MONGODB_URI debe apuntar a un replica set (puede ser de un solo
+	// nodo): las transacciones de categorías y productos no funcionan con un mongod standalone.
 	mongoClient, err := database.Connect(mongoURI, dbName)
 	if err != nil {
 		log.Fatal("Error al conectar a MongoDB", err)
 	}
 	defer mongoClient.Close()
 
+	// Almacenamiento de archivos subidos (local o S3 según ALMACENAMIENTO)
+	storage, err := almacenamiento.DesdeEntorno()
+	if err != nil {
+		log.Fatal("Error al configurar el almacenamiento: ", err)
+	}
+
+	// Tipos, tamaño y dimensiones aceptados para las fotos de producto
+	limitesFotos, err := utilidades.LimitesSubidaDesdeEntorno()
+	if err != nil {
+		log.Fatal("Error en la configuración de límites de fotos: ", err)
+	}
+
+	// Variantes redimensionadas que se generan para cada foto de producto
+	variantes, err := utilidades.ConfigVariantesDesdeEntorno()
+	if err != nil {
+		log.Fatal("Error en la configuración de variantes de fotos: ", err)
+	}
+
+	// Envío de correos (log por defecto, o SMTP según MAILER)
+	mailer, err := correo.DesdeEntorno()
+	if err != nil {
+		log.Fatal("Error al configurar el envío de correos: ", err)
+	}
+
+	// Protección contra fuerza bruta del login
+	limitesLogin, err := utilidades.LimitesLoginDesdeEntorno()
+	if err != nil {
+		log.Fatal("Error en la configuración de límites de login: ", err)
+	}
+
 	// Alias local para las colecciones
 	cols := config.Collections
 
+	// Subcomandos de mantenimiento, ej. "./servidor gc -accion cuarentena -dry-run" o "./servidor migrate up"
+	if len(os.Args) > 1 {
+		entorno := comandos.Entorno{MongoClient: mongoClient, DBName: dbName, Colecciones: cols, Storage: storage}
+		if err := comandos.Ejecutar(context.Background(), entorno, os.Args[1:]); err != nil {
+			log.Fatal("Error en el subcomando: ", err)
+		}
+		return
+	}
+
+	// Las migraciones se aplican con el subcomando migrate; el servidor solo avisa si faltan
+	if pendientes, err := migraciones.Nuevo(mongoClient, dbName, cols).Pendientes(context.Background()); err != nil {
+		log.Printf("No se pudo revisar las migraciones: %v", err)
+	} else if len(pendientes) > 0 {
+		log.Printf("Hay %d migraciones pendientes, ejecute 'migrate up'", len(pendientes))
+	}
+
+	// Firma y validación de tokens (RS256, EdDSA o HS256 según JWT_ALGORITMO)
+	tokens, err := jwt.NuevoServicio(mongoClient, dbName, cols["claves_jwt"])
+	if err != nil {
+		log.Fatal("Error al configurar la firma de tokens: ", err)
+	}
+
+	// Slugs de categoría únicos, las URLs de la tienda dependen de ellos
+	if err := rutas.AsegurarSlugsCategorias(context.Background(), mongoClient, dbName, cols["categorias"]); err != nil {
+		log.Fatal("Error al crear el índice de slugs de categorías: ", err)
+	}
+
+	// Slug y código de barras únicos de productos
+	if err := rutas.AsegurarIdentificadoresProductos(context.Background(), mongoClient, dbName, cols["productos"]); err != nil {
+		log.Fatal("Error al crear los índices de productos: ", err)
+	}
+
+	// Proveedores OpenID Connect para iniciar sesión con SSO (OIDC_PROVEEDORES)
+	proveedoresOIDC, err := oidc.DesdeEntorno()
+	if err != nil {
+		log.Fatal("Error en la configuración de OIDC: ", err)
+	}
+
+	// Purga periódica de las cuentas eliminadas cuyo periodo de gracia terminó
+	go func() {
+		for {
+			purgadas, err := rutas.PurgarCuentasEliminadas(context.Background(), mongoClient, dbName, cols["usuarios"],
+				cols["refresh_tokens"], cols["password_resets"], cols["verificaciones_correo"])
+			if err != nil {
+				log.Printf("Error al purgar cuentas eliminadas: %v", err)
+			} else if purgadas > 0 {
+				log.Printf("Cuentas eliminadas purgadas: %d", purgadas)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+
+	// Reintento del borrado de archivos de fotos que falló al eliminar productos
+	go func() {
+		for {
+			borrados, err := rutas.ReintentarArchivosPendientes(context.Background(), mongoClient, dbName, cols["archivos_pendientes"], cols["archivos"], storage)
+			if err != nil {
+				log.Printf("Error al reintentar el borrado de archivos: %v", err)
+			} else if borrados > 0 {
+				log.Printf("Archivos pendientes borrados: %d", borrados)
+			}
+			time.Sleep(time.Minute)
+		}
+	}()
+
 	// Instancia de echo framework
 	e := echo.New()
 
+	// La IP del cliente se toma de X-Forwarded-For solo detrás de un proxy confiable (PROXY_CONFIABLE=true),
+	// de lo contrario cualquiera podría falsificarla para saltarse el límite por IP
+	if os.Getenv("PROXY_CONFIABLE") == "true" {
+		e.IPExtractor = echo.ExtractIPFromXFFHeader()
+	} else {
+		e.IPExtractor = echo.ExtractIPDirect()
+	}
+
 	// Middleware
 	// e.Use(middleware.Logger())
 	e.Use(middleware.BodyLimit("5M"))
 
-	e.Static("/imagenes", "public/uploads/productos")
+	// Con almacenamiento local las imágenes se sirven desde este mismo servidor
+	if local, ok := storage.(*almacenamiento.Local); ok {
+		e.Static("/imagenes", local.Directorio)
+	}
 
 	// Rutas ejemplo
 	ejemploGroup := e.Group(prefijo + "ejemplo")
@@ -67,37 +184,93 @@ func main() {
 	e.GET(prefijo+"query-string", rutas.Ejemplo_query_string)
 	e.POST(prefijo+"upload", rutas.Ejemplo_upload)
 
+	// Validación del token de acceso, rechaza los revocados con logout y los de cuentas deshabilitadas
+	validarJWT := middleware_custom.ValidarJWT(mongoClient, dbName, cols["usuarios"], cols["tokens_revocados"], tokens)
+
+	// Las integraciones se autentican con API key en lugar de token
+	validarApiKey := middleware_custom.ValidarApiKey(mongoClient, dbName, cols["api_keys"])
+	autenticado := middleware_custom.ValidarJWTOApiKey(validarJWT, validarApiKey)
+
+	// Roles autorizados para leer, escribir y eliminar; las API keys se autorizan por scope
+	lectura := []string{modelos.RolAdmin, modelos.RolEditor, modelos.RolViewer}
+	escritura := []string{modelos.RolAdmin, modelos.RolEditor}
+	eliminacion := middleware_custom.RequireRole(modelos.RolAdmin)
+
+	// Eliminar del catálogo exige además haber iniciado sesión con segundo factor
+	requiereMFA := middleware_custom.RequireMFA()
+
 	// Rutas MongoDB 'Categorias'
-	categoriaGroup := e.Group(prefijo + "categorias")
-	categoriaGroup.GET("", rutas.ListarCategorias(mongoClient, dbName, cols["categorias"]))
-	categoriaGroup.GET("/:id", rutas.ListarCategoriaPorId(mongoClient, dbName, cols["categorias"]))
-	categoriaGroup.POST("", rutas.CrearCategoria(mongoClient, dbName, cols["categorias"]))
-	categoriaGroup.PUT("/:id", rutas.EditarCategoria(mongoClient, dbName, cols["categorias"]))
-	categoriaGroup.DELETE("/:id", rutas.EliminarCategoria(mongoClient, dbName, cols["categorias"]))
+	categoriaGroup := e.Group(prefijo+"categorias", autenticado)
+	categoriaGroup.GET("", rutas.ListarCategorias(mongoClient, dbName, cols["categorias"]), middleware_custom.Permitir("categorias:leer", lectura...))
+	categoriaGroup.GET("/arbol", rutas.ArbolCategorias(mongoClient, dbName, cols["categorias"]), middleware_custom.Permitir("categorias:leer", lectura...))
+	categoriaGroup.GET("/slug/:slug", rutas.ListarCategoriaPorSlug(mongoClient, dbName, cols["categorias"]), middleware_custom.Permitir("categorias:leer", lectura...))
+	categoriaGroup.GET("/:id", rutas.ListarCategoriaPorId(mongoClient, dbName, cols["categorias"]), middleware_custom.Permitir("categorias:leer", lectura...))
+	categoriaGroup.POST("", rutas.CrearCategoria(mongoClient, dbName, cols["categorias"]), middleware_custom.Permitir("categorias:escribir", escritura...))
+	categoriaGroup.PUT("/:id", rutas.EditarCategoria(mongoClient, dbName, cols["categorias"]), middleware_custom.Permitir("categorias:escribir", escritura...))
+	categoriaGroup.DELETE("/:id", rutas.EliminarCategoria(mongoClient, dbName, cols["categorias"], cols["productos"], cols["productos_fotos"], cols["archivos_pendientes"], cols["archivos"], storage), middleware_custom.Permitir("categorias:eliminar", modelos.RolAdmin), requiereMFA)
 
 	// Rutas MongoDB 'Productos'
-	productoGroup := e.Group(prefijo+"productos", middleware_custom.ValidarJWT) // Validación de token para acceder a productos
-	productoGroup.GET("", rutas.ListarProductos(mongoClient, dbName, cols["productos"], cols["categorias"]))
-	productoGroup.GET("/:id", rutas.ListarProductoPorId(mongoClient, dbName, cols["productos"], cols["categorias"]))
-	productoGroup.POST("", rutas.CrearProducto(mongoClient, dbName, cols["productos"]))
-	productoGroup.PUT("/:id", rutas.EditarProducto(mongoClient, dbName, cols["productos"]))
-	productoGroup.DELETE("/:id", rutas.EliminarProducto(mongoClient, dbName, cols["productos"]))
+	productoGroup := e.Group(prefijo+"productos", autenticado) // Validación de token para acceder a productos
+	productoGroup.GET("", rutas.ListarProductos(mongoClient, dbName, cols["productos"], cols["categorias"]), middleware_custom.Permitir("productos:leer", lectura...))
+	productoGroup.GET("/slug/:slug", rutas.ListarProductoPorSlug(mongoClient, dbName, cols["productos"], cols["categorias"]), middleware_custom.Permitir("productos:leer", lectura...))
+	productoGroup.GET("/sku/:sku", rutas.ListarProductoPorSku(mongoClient, dbName, cols["productos"], cols["categorias"]), middleware_custom.Permitir("productos:leer", lectura...))
+	productoGroup.GET("/:id", rutas.ListarProductoPorId(mongoClient, dbName, cols["productos"], cols["categorias"]), middleware_custom.Permitir("productos:leer", lectura...))
+	productoGroup.POST("", rutas.CrearProducto(mongoClient, dbName, cols["productos"]), middleware_custom.Permitir("productos:escribir", escritura...))
+	productoGroup.PUT("/:id", rutas.EditarProducto(mongoClient, dbName, cols["productos"]), middleware_custom.Permitir("productos:escribir", escritura...))
+	productoGroup.DELETE("/:id", rutas.EliminarProducto(mongoClient, dbName, cols["productos"], cols["productos_fotos"], cols["archivos_pendientes"], cols["archivos"], storage), middleware_custom.Permitir("productos:eliminar", modelos.RolAdmin), requiereMFA)
 
 	// Rutas MongoDB 'Productos-fotos'
-	productoFotosGroup := e.Group(prefijo + "productos-fotos")
-	productoFotosGroup.GET("/:id", rutas.ListarFotosPorIdProducto(mongoClient, dbName, cols["productos_fotos"]))
-	productoFotosGroup.POST("/:id", rutas.UploadFotoProducto(mongoClient, dbName, cols["productos_fotos"]))
-	productoFotosGroup.DELETE("/:id", rutas.EliminarFotoProducto(mongoClient, dbName, cols["productos_fotos"]))
+	productoFotosGroup := e.Group(prefijo+"productos-fotos", autenticado)
+	productoFotosGroup.GET("/:id", rutas.ListarFotosPorIdProducto(mongoClient, dbName, cols["productos_fotos"], storage), middleware_custom.Permitir("productos_fotos:leer", lectura...))
+	productoFotosGroup.POST("/:id", rutas.UploadFotoProducto(mongoClient, dbName, cols["productos_fotos"], cols["archivos"], storage, limitesFotos, variantes), middleware_custom.Permitir("productos_fotos:escribir", escritura...))
+	productoFotosGroup.DELETE("/:id", rutas.EliminarFotoProducto(mongoClient, dbName, cols["productos_fotos"], cols["archivos"], storage), middleware_custom.Permitir("productos_fotos:eliminar", modelos.RolAdmin), requiereMFA)
+
+	// Claves públicas para que otros servicios validen los tokens sin compartir un secreto
+	e.GET("/.well-known/jwks.json", rutas.JWKS(tokens))
+
+	// Rutas de administración de usuarios, solo para administradores
+	usuarioGroup := e.Group(prefijo+"usuarios", validarJWT, eliminacion, requiereMFA)
+	usuarioGroup.GET("", rutas.ListarUsuarios(mongoClient, dbName, cols["usuarios"]))
+	usuarioGroup.GET("/:id", rutas.ObtenerUsuario(mongoClient, dbName, cols["usuarios"]))
+	usuarioGroup.PUT("/:id/rol", rutas.CambiarRolUsuario(mongoClient, dbName, cols["usuarios"], cols["refresh_tokens"]))
+	usuarioGroup.PUT("/:id/estado", rutas.CambiarEstadoUsuario(mongoClient, dbName, cols["usuarios"], cols["refresh_tokens"]))
+	usuarioGroup.POST("/:id/reset-password", rutas.ForzarResetPassword(mongoClient, dbName, cols["usuarios"], cols["refresh_tokens"], cols["password_resets"], mailer))
+	usuarioGroup.POST("/:id/revocar-tokens", rutas.RevocarTokensUsuario(mongoClient, dbName, cols["usuarios"], cols["refresh_tokens"]))
+
+	// Rutas de API keys para integraciones, solo para administradores con segundo factor
+	apiKeyGroup := e.Group(prefijo+"api-keys", validarJWT, eliminacion, requiereMFA)
+	apiKeyGroup.GET("", rutas.ListarApiKeys(mongoClient, dbName, cols["api_keys"]))
+	apiKeyGroup.POST("", rutas.CrearApiKey(mongoClient, dbName, cols["api_keys"]))
+	apiKeyGroup.DELETE("/:id", rutas.RevocarApiKey(mongoClient, dbName, cols["api_keys"]))
 
 	// Ruta 'Seguridad' registro y login, elementos protegidos
 	seguridadGroup := e.Group(prefijo + "seguridad")
-	seguridadGroup.POST("/registro", rutas.RegistroUsuario(mongoClient, dbName, cols["usuarios"]))
-	seguridadGroup.POST("/login", rutas.LoginUsuario(mongoClient, dbName, cols["usuarios"]))
+	seguridadGroup.POST("/registro", rutas.RegistroUsuario(mongoClient, dbName, cols["usuarios"], cols["verificaciones_correo"], mailer))
+	seguridadGroup.POST("/login", rutas.LoginUsuario(mongoClient, dbName, cols["usuarios"], cols["refresh_tokens"], cols["intentos_login"], limitesLogin, tokens, proveedoresOIDC))
+	seguridadGroup.POST("/login/2fa", rutas.LoginMFA(mongoClient, dbName, cols["usuarios"], cols["refresh_tokens"], cols["tokens_revocados"], cols["intentos_login"], limitesLogin, tokens))
+	seguridadGroup.GET("/oidc/:proveedor", rutas.IniciarOIDC(mongoClient, dbName, cols["oidc_estados"], proveedoresOIDC))
+	seguridadGroup.GET("/oidc/:proveedor/callback", rutas.CallbackOIDC(mongoClient, dbName, cols["usuarios"], cols["refresh_tokens"], cols["oidc_estados"], proveedoresOIDC, tokens))
+	seguridadGroup.POST("/refresh", rutas.RefrescarToken(mongoClient, dbName, cols["usuarios"], cols["refresh_tokens"], tokens))
+	seguridadGroup.GET("/verificar", rutas.VerificarCorreo(mongoClient, dbName, cols["usuarios"], cols["verificaciones_correo"]))
+	seguridadGroup.POST("/verificar/reenviar", rutas.ReenviarVerificacion(mongoClient, dbName, cols["usuarios"], cols["verificaciones_correo"], mailer))
+	seguridadGroup.POST("/password/olvido", rutas.OlvidoPassword(mongoClient, dbName, cols["usuarios"], cols["password_resets"], mailer))
+	seguridadGroup.POST("/password/reset", rutas.ResetPassword(mongoClient, dbName, cols["usuarios"], cols["password_resets"], cols["refresh_tokens"]))
+	seguridadGroup.GET("/bloqueos", rutas.ListarBloqueosLogin(mongoClient, dbName, cols["intentos_login"]), validarJWT, eliminacion, requiereMFA)
+	seguridadGroup.POST("/desbloquear", rutas.DesbloquearLogin(mongoClient, dbName, cols["intentos_login"]), validarJWT, eliminacion, requiereMFA)
+	seguridadGroup.POST("/2fa/inscribir", rutas.InscribirMFA(mongoClient, dbName, cols["usuarios"]), validarJWT)
+	seguridadGroup.POST("/2fa/confirmar", rutas.ConfirmarMFA(mongoClient, dbName, cols["usuarios"]), validarJWT)
+	seguridadGroup.POST("/2fa/codigos-recuperacion", rutas.RegenerarCodigosRecuperacion(mongoClient, dbName, cols["usuarios"]), validarJWT)
+	seguridadGroup.GET("/perfil", rutas.ObtenerPerfil(mongoClient, dbName, cols["usuarios"]), validarJWT)
+	seguridadGroup.PUT("/perfil", rutas.EditarPerfil(mongoClient, dbName, cols["usuarios"]), validarJWT)
+	seguridadGroup.PUT("/password", rutas.CambiarPassword(mongoClient, dbName, cols["usuarios"], cols["refresh_tokens"]), validarJWT)
+	seguridadGroup.PUT("/correo", rutas.CambiarCorreo(mongoClient, dbName, cols["usuarios"], cols["verificaciones_correo"], mailer), validarJWT)
+	seguridadGroup.DELETE("/cuenta", rutas.EliminarCuenta(mongoClient, dbName, cols["usuarios"], cols["refresh_tokens"], cols["tokens_revocados"]), validarJWT)
+	seguridadGroup.POST("/logout", rutas.CerrarSesion(mongoClient, dbName, cols["refresh_tokens"], cols["tokens_revocados"]), validarJWT)
 
 	// CORS
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins: []string{"http://localhost:8086"},
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept},
+		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
 	}))
 
 	e.Logger.Fatal(e.Start(":" + os.Getenv("PORT")))
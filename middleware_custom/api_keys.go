@@ -0,0 +1,109 @@
+package middleware_custom
+
+import (
+	"clase_6_echo_mongo/database"
+	"clase_6_echo_mongo/modelos"
+	"clase_6_echo_mongo/utilidades"
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Cada cuánto se actualiza ultimo_uso, para no escribir en cada petición
+const intervaloUltimoUso = time.Minute
+
+// ApiKeyEnPeticion devuelve la API key enviada en "Authorization: ApiKey ..." o en "X-API-Key"
+func ApiKeyEnPeticion(c echo.Context) string {
+	if clave := strings.TrimSpace(c.Request().Header.Get("X-API-Key")); clave != "" {
+		return clave
+	}
+	esquema, clave, ok := strings.Cut(c.Request().Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(esquema, "apikey") {
+		return strings.TrimSpace(clave)
+	}
+	return ""
+}
+
+// ValidarApiKey verifica la API key de la petición: que exista, coincida su secreto, no esté revocada ni expirada
+func ValidarApiKey(mongoClient *database.MongoDBClient, dbName, apiKeysCollection string) echo.MiddlewareFunc {
+	apiKeys := database.NewRepository[modelos.ApiKey](mongoClient, dbName, apiKeysCollection)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			prefijo, secreto, ok := strings.Cut(ApiKeyEnPeticion(c), ".")
+			if !ok || prefijo == "" || secreto == "" {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "API key inválida"})
+			}
+
+			clave, err := apiKeys.FindOne(context.TODO(), bson.M{"prefijo": prefijo})
+			if err != nil {
+				if err == mongo.ErrNoDocuments {
+					return c.JSON(http.StatusUnauthorized, map[string]string{"error": "API key inválida"})
+				}
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al validar la API key: " + err.Error()})
+			}
+			if subtle.ConstantTimeCompare([]byte(clave.Hash), []byte(utilidades.HashToken(secreto))) != 1 {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "API key inválida"})
+			}
+			if clave.Revocada {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "API key revocada"})
+			}
+			ahora := time.Now()
+			if clave.Expira != nil && ahora.After(*clave.Expira) {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "API key expirada"})
+			}
+
+			if clave.UltimoUso == nil || ahora.Sub(*clave.UltimoUso) > intervaloUltimoUso {
+				_, err := apiKeys.Collection().UpdateOne(context.TODO(), bson.M{"_id": clave.ID}, bson.M{"$set": bson.M{"ultimo_uso": ahora}})
+				if err != nil {
+					return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al validar la API key: " + err.Error()})
+				}
+			}
+
+			c.Set("api_key", clave)
+			return next(c)
+		}
+	}
+}
+
+// ApiKeyDelContexto devuelve la API key validada por ValidarApiKey, si la petición usó una
+func ApiKeyDelContexto(c echo.Context) (*modelos.ApiKey, bool) {
+	clave, ok := c.Get("api_key").(*modelos.ApiKey)
+	return clave, ok
+}
+
+// ValidarJWTOApiKey autentica con API key si la petición trae una y, si no, con el token Bearer
+func ValidarJWTOApiKey(validarJWT, validarApiKey echo.MiddlewareFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		conJWT, conApiKey := validarJWT(next), validarApiKey(next)
+		return func(c echo.Context) error {
+			if ApiKeyEnPeticion(c) != "" {
+				return conApiKey(c)
+			}
+			return conJWT(c)
+		}
+	}
+}
+
+// Permitir autoriza la acción: a una API key si tiene el scope, a un usuario si su rol es uno de los indicados.
+// Debe usarse después de ValidarJWTOApiKey.
+func Permitir(scope string, roles ...string) echo.MiddlewareFunc {
+	porRol := RequireRole(roles...)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		conRol := porRol(next)
+		return func(c echo.Context) error {
+			if clave, ok := ApiKeyDelContexto(c); ok {
+				if !clave.TieneScope(scope) {
+					return c.JSON(http.StatusForbidden, map[string]string{"error": "La API key no tiene el scope '" + scope + "'"})
+				}
+				return next(c)
+			}
+			return conRol(c)
+		}
+	}
+}
@@ -1,45 +1,142 @@
 package middleware_custom
 
 import (
-	"fmt"
+	"clase_6_echo_mongo/database"
+	"clase_6_echo_mongo/jwt"
+	"clase_6_echo_mongo/modelos"
+	"context"
 	"net/http"
-	"os"
 	"strings"
 
-	"github.com/golang-jwt/jwt"
 	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func ValidarJWT(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		authHeader := c.Request().Header.Get("Authorization")
-		if authHeader == "" {
-			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Header 'Authorization' es requerido"})
-		}
+// ValidarJWT verifica el token Bearer con el servicio de tokens, que su jti no esté en la lista de tokens revocados
+// y que el usuario siga habilitado con la misma versión de tokens
+func ValidarJWT(mongoClient *database.MongoDBClient, dbName, usuariosCollection, revocadosCollection string, tokens *jwt.Servicio) echo.MiddlewareFunc {
+	usuarios := mongoClient.GetCollection(dbName, usuariosCollection)
+	revocados := mongoClient.GetCollection(dbName, revocadosCollection)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			authHeader := c.Request().Header.Get("Authorization")
+			if authHeader == "" {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Header 'Authorization' es requerido"})
+			}
 
-		splitBearer := strings.Split(authHeader, " ")
-		if len(splitBearer) != 2 || strings.ToLower(splitBearer[0]) != "bearer" {
-			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Formato de autorización inválido"})
-		}
+			splitBearer := strings.Split(authHeader, " ")
+			if len(splitBearer) != 2 || strings.ToLower(splitBearer[0]) != "bearer" {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Formato de autorización inválido"})
+			}
 
-		tokenString := strings.TrimSpace(splitBearer[1])
-		miClave := []byte(os.Getenv("SECRET_JWT"))
-		if len(miClave) == 0 {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Clave secreta no configurada"})
-		}
+			tokenString := strings.TrimSpace(splitBearer[1])
+			// El token del primer paso del login con 2FA tiene otra audiencia y no da acceso a nada
+			claims, err := tokens.ValidarAcceso(tokenString)
+			if err != nil {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Token inválido"})
+			}
+
+			// Los tokens revocados (logout) se rechazan aunque su firma y expiración sean válidas
+			if jti, _ := claims["jti"].(string); jti != "" {
+				cantidad, err := revocados.CountDocuments(context.TODO(), bson.M{"jti": jti}, options.Count().SetLimit(1))
+				if err != nil {
+					return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al validar el token: " + err.Error()})
+				}
+				if cantidad > 0 {
+					return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Token revocado"})
+				}
+			}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("Método de firma inesperado")
+			// Un administrador puede deshabilitar la cuenta o invalidar todos sus tokens subiendo token_version
+			id, _ := claims["id"].(string)
+			objID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Token inválido"})
+			}
+			usuario := new(modelos.UsuarioDto)
+			proyeccion := bson.M{"estado": 1, "token_version": 1, "eliminado_en": 1}
+			err = usuarios.FindOne(context.TODO(), bson.M{"_id": objID}, options.FindOne().SetProjection(proyeccion)).Decode(usuario)
+			if err != nil {
+				if err == mongo.ErrNoDocuments {
+					return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Token inválido"})
+				}
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al validar el token: " + err.Error()})
+			}
+			if usuario.EliminadoEn != nil || !usuario.EstaHabilitado() {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "La cuenta está deshabilitada"})
+			}
+			version, _ := claims["ver"].(float64) // Los tokens anteriores a la versión cuentan como 0
+			if int(version) != usuario.TokenVersion {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Token revocado"})
 			}
-			return miClave, nil
-		})
 
-		if err != nil || !token.Valid {
-			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Token inválido"})
+			c.Set("user", claims)
+			return next(c)
 		}
+	}
+}
+
+// Claims devuelve los claims del token validado por ValidarJWT
+func Claims(c echo.Context) (jwt.Claims, bool) {
+	claims, ok := c.Get("user").(jwt.Claims)
+	return claims, ok
+}
 
-		c.Set("user", token)
-		return next(c)
+// RolDelToken devuelve el rol del claim "rol". Los tokens emitidos antes de los roles se tratan como viewer.
+func RolDelToken(c echo.Context) string {
+	claims, ok := Claims(c)
+	if !ok {
+		return ""
+	}
+	rol, _ := claims["rol"].(string)
+	if rol == "" {
+		return modelos.RolViewer
+	}
+	return rol
+}
+
+// RequireRole permite continuar solo si el rol del token es uno de los indicados.
+// Debe usarse después de ValidarJWT.
+func RequireRole(roles ...string) echo.MiddlewareFunc {
+	permitidos := make(map[string]bool, len(roles))
+	for _, rol := range roles {
+		permitidos[rol] = true
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			rol := RolDelToken(c)
+			if rol == "" {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Token inválido"})
+			}
+			if !permitidos[rol] {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "El rol '" + rol + "' no tiene permiso para esta acción"})
+			}
+			return next(c)
+		}
+	}
+}
+
+// RequireMFA permite continuar solo si la sesión se inició con segundo factor.
+// Las API keys no tienen sesión: su alcance se limita con scopes, que solo crea un administrador con 2FA.
+// Debe usarse después de ValidarJWT o ValidarJWTOApiKey.
+func RequireMFA() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if _, ok := ApiKeyDelContexto(c); ok {
+				return next(c)
+			}
+			claims, ok := Claims(c)
+			if !ok {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Token inválido"})
+			}
+			if mfa, _ := claims["mfa"].(bool); !mfa {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "Esta acción requiere una sesión con segundo factor (2FA) activo"})
+			}
+			return next(c)
+		}
 	}
 }
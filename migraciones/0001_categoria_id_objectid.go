@@ -0,0 +1,30 @@
+package migraciones
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Los productos creados antes de que modelos.Producto usara ObjectID guardaban categoria_id como texto,
+// y no coinciden con los filtros ni con el $lookup de categorías
+func init() {
+	registrar(Migracion{
+		Version: 1,
+		Nombre:  "categoria_id de productos como ObjectID",
+		Subir: func(ctx context.Context, db *mongo.Database, colecciones map[string]string) error {
+			// Un texto que no es un ObjectID válido se deja como está
+			_, err := db.Collection(colecciones["productos"]).UpdateMany(ctx,
+				bson.M{"categoria_id": bson.M{"$type": "string"}},
+				mongo.Pipeline{{{Key: "$set", Value: bson.M{"categoria_id": bson.M{"$convert": bson.M{
+					"input":   "$categoria_id",
+					"to":      "objectId",
+					"onError": "$categoria_id",
+				}}}}}},
+			)
+			return err
+		},
+		// Sin Bajar: volver a texto reintroduciría el error que corrige
+	})
+}
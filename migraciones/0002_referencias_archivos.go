@@ -0,0 +1,37 @@
+package migraciones
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Las fotos que comparten contenido cuentan sus referencias en la colección archivos. Las guardadas antes
+// de los contadores no tienen documento, y sin él sus archivos nunca se borrarían al eliminarlas.
+func init() {
+	registrar(Migracion{
+		Version: 2,
+		Nombre:  "contadores de referencias de archivos",
+		Subir: func(ctx context.Context, db *mongo.Database, colecciones map[string]string) error {
+			// Repetirla recalcula los mismos contadores y respeta la marca de borrado en curso
+			cursor, err := db.Collection(colecciones["productos_fotos"]).Aggregate(ctx, mongo.Pipeline{
+				{{Key: "$group", Value: bson.M{"_id": "$nombre", "referencias": bson.M{"$sum": 1}}}},
+				{{Key: "$merge", Value: bson.M{
+					"into":           colecciones["archivos"],
+					"on":             "_id",
+					"whenMatched":    "merge",
+					"whenNotMatched": "insert",
+				}}},
+			})
+			if err != nil {
+				return err
+			}
+			return cursor.Close(ctx)
+		},
+		// Los contadores se derivan de productos_fotos: sin ellos la versión anterior cuenta las fotos
+		Bajar: func(ctx context.Context, db *mongo.Database, colecciones map[string]string) error {
+			return db.Collection(colecciones["archivos"]).Drop(ctx)
+		},
+	})
+}
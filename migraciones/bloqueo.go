@@ -0,0 +1,126 @@
+package migraciones
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	idBloqueo = "migraciones"
+	// El bloqueo vence solo si la réplica que lo tiene muere; mientras migra lo renueva
+	duracionBloqueo   = 2 * time.Minute
+	renovacionBloqueo = 30 * time.Second
+)
+
+// ErrBloqueado indica que otra réplica está corriendo las migraciones
+var ErrBloqueado = errors.New("otra instancia está aplicando migraciones")
+
+// bloqueo es el documento que ocupa una sola instancia a la vez
+type bloqueo struct {
+	ID     string    `bson:"_id"`
+	Dueno  string    `bson:"dueno"`
+	Desde  time.Time `bson:"desde"`
+	Expira time.Time `bson:"expira"`
+}
+
+// nuevoDueno identifica a este proceso en el bloqueo
+func nuevoDueno() string {
+	host, _ := os.Hostname()
+	azar := make([]byte, 4)
+	rand.Read(azar)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(azar))
+}
+
+// adquirir toma el bloqueo si está libre o vencido
+func (r *Runner) adquirir(ctx context.Context) error {
+	ahora := time.Now()
+	// Si el bloqueo está vigente el filtro no coincide y el upsert choca con el _id existente
+	_, err := r.bloqueos.UpdateOne(ctx,
+		bson.M{"_id": idBloqueo, "expira": bson.M{"$lt": ahora}},
+		bson.M{"$set": bson.M{"dueno": r.dueno, "desde": ahora, "expira": ahora.Add(duracionBloqueo)}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		var actual bloqueo
+		if err := r.bloqueos.FindOne(ctx, bson.M{"_id": idBloqueo}).Decode(&actual); err != nil {
+			return ErrBloqueado
+		}
+		return fmt.Errorf("%w: %s lo tiene hasta %s", ErrBloqueado, actual.Dueno, actual.Expira.Local().Format(time.RFC3339))
+	}
+	return err
+}
+
+// renovar extiende el bloqueo; falla si otra instancia lo tomó al vencer
+func (r *Runner) renovar(ctx context.Context) error {
+	resultado, err := r.bloqueos.UpdateOne(ctx,
+		bson.M{"_id": idBloqueo, "dueno": r.dueno},
+		bson.M{"$set": bson.M{"expira": time.Now().Add(duracionBloqueo)}},
+	)
+	if err != nil {
+		return err
+	}
+	if resultado.MatchedCount == 0 {
+		return errors.New("el bloqueo de migraciones ya no pertenece a esta instancia")
+	}
+	return nil
+}
+
+func (r *Runner) liberar(ctx context.Context) error {
+	_, err := r.bloqueos.DeleteOne(ctx, bson.M{"_id": idBloqueo, "dueno": r.dueno})
+	return err
+}
+
+// conBloqueo ejecuta fn con el bloqueo tomado y lo renueva en segundo plano.
+// Si se pierde el bloqueo se cancela el ctx de fn.
+func (r *Runner) conBloqueo(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := r.adquirir(ctx); err != nil {
+		return err
+	}
+	defer func() {
+		// Se libera aunque ctx se haya cancelado
+		liberarCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := r.liberar(liberarCtx); err != nil {
+			log.Printf("No se pudo liberar el bloqueo de migraciones: %v", err)
+		}
+	}()
+
+	ctxFn, cancelar := context.WithCancelCause(ctx)
+	defer cancelar(nil)
+	terminado := make(chan struct{})
+	defer close(terminado)
+
+	go func() {
+		ticker := time.NewTicker(renovacionBloqueo)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-terminado:
+				return
+			case <-ticker.C:
+				if err := r.renovar(ctxFn); err != nil {
+					cancelar(fmt.Errorf("no se pudo renovar el bloqueo: %w", err))
+					return
+				}
+			}
+		}
+	}()
+
+	if err := fn(ctxFn); err != nil {
+		if causa := context.Cause(ctxFn); causa != nil && !errors.Is(causa, context.Canceled) {
+			return fmt.Errorf("%w (%v)", err, causa)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// Package migraciones aplica en orden los cambios de forma de los documentos ya guardados.
+//
+// Cada migración vive en su propio archivo NNNN_descripcion.go y se registra en init(). El checksum de una
+// migración es el sha256 de ese archivo: si se edita una migración ya aplicada, el runner se niega a continuar.
+// Las migraciones no corren en transacción, por eso deben poder repetirse sin dañar los datos.
+package migraciones
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+//go:embed [0-9]*.go
+var fuentes embed.FS
+
+// Funcion recibe la base de datos y los nombres de colección de config.Collections
+type Funcion func(ctx context.Context, db *mongo.Database, colecciones map[string]string) error
+
+// Migracion es un cambio versionado de los datos. Bajar es nil si la migración no se puede revertir.
+type Migracion struct {
+	Version  int
+	Nombre   string
+	Subir    Funcion
+	Bajar    Funcion
+	Checksum string // sha256 del archivo que la declara
+}
+
+var registradas = map[int]Migracion{}
+
+// registrar agrega la migración declarada en el archivo que lo llama
+func registrar(migracion Migracion) {
+	_, archivo, _, ok := runtime.Caller(1)
+	if !ok {
+		panic("migraciones: no se pudo determinar el archivo de la migración " + migracion.Nombre)
+	}
+	fuente, err := fuentes.ReadFile(filepath.Base(archivo))
+	if err != nil {
+		panic(fmt.Sprintf("migraciones: %s no está embebido: %v", filepath.Base(archivo), err))
+	}
+	if migracion.Version <= 0 || migracion.Subir == nil {
+		panic(fmt.Sprintf("migraciones: %s necesita una versión positiva y una función Subir", filepath.Base(archivo)))
+	}
+	if otra, ok := registradas[migracion.Version]; ok {
+		panic(fmt.Sprintf("migraciones: la versión %d está repetida (%s y %s)", migracion.Version, otra.Nombre, migracion.Nombre))
+	}
+
+	suma := sha256.Sum256(fuente)
+	migracion.Checksum = hex.EncodeToString(suma[:])
+	registradas[migracion.Version] = migracion
+}
+
+// Todas devuelve las migraciones registradas ordenadas por versión
+func Todas() []Migracion {
+	lista := make([]Migracion, 0, len(registradas))
+	for _, migracion := range registradas {
+		lista = append(lista, migracion)
+	}
+	sort.Slice(lista, func(i, j int) bool { return lista[i].Version < lista[j].Version })
+	return lista
+}
@@ -0,0 +1,224 @@
+package migraciones
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestChecksumDelArchivo(t *testing.T) {
+	archivos := map[int]string{
+		1: "0001_categoria_id_objectid.go",
+		2: "0002_referencias_archivos.go",
+	}
+	for _, migracion := range Todas() {
+		archivo, ok := archivos[migracion.Version]
+		if !ok {
+			continue
+		}
+		fuente, err := os.ReadFile(archivo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		suma := sha256.Sum256(fuente)
+		if migracion.Checksum != hex.EncodeToString(suma[:]) {
+			t.Errorf("el checksum de la migración %d no es el sha256 de %s", migracion.Version, archivo)
+		}
+		delete(archivos, migracion.Version)
+	}
+	if len(archivos) > 0 {
+		t.Fatalf("migraciones no registradas: %v", archivos)
+	}
+}
+
+func TestVerificarIntegridad(t *testing.T) {
+	aplicada := func(checksum string) *Registro {
+		return &Registro{Checksum: checksum}
+	}
+	casos := []struct {
+		nombre  string
+		estados []Estado
+		error   string // "" si se puede continuar
+	}{
+		{nombre: "sin migraciones"},
+		{nombre: "aplicadas y pendientes", estados: []Estado{
+			{Version: 1, Checksum: "a", Registro: aplicada("a")},
+			{Version: 2, Checksum: "b"},
+		}},
+		{nombre: "modificada", estados: []Estado{
+			{Version: 1, Nombre: "uno", Checksum: "a", Registro: aplicada("a")},
+			{Version: 2, Nombre: "dos", Checksum: "b", Registro: aplicada("otro")},
+		}, error: "2 (dos) cambió"},
+		{nombre: "aplicada por un binario más nuevo", estados: []Estado{
+			{Version: 1, Checksum: "a", Registro: aplicada("a")},
+			{Version: 3, Nombre: "tres", Registro: aplicada("c")},
+		}, error: "3 (tres) está aplicada pero no existe"},
+	}
+	for _, caso := range casos {
+		t.Run(caso.nombre, func(t *testing.T) {
+			err := verificarIntegridad(caso.estados)
+			if caso.error == "" {
+				if err != nil {
+					t.Fatalf("verificarIntegridad: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), caso.error) {
+				t.Fatalf("error = %v, se esperaba uno con %q", err, caso.error)
+			}
+		})
+	}
+}
+
+// runnerDePrueba usa un cliente simulado: cada operación consume la siguiente respuesta agregada con AddMockResponses
+func runnerDePrueba(mt *mtest.T, migraciones ...Migracion) *Runner {
+	return &Runner{
+		db:          mt.DB,
+		colecciones: map[string]string{},
+		registros:   mt.Coll,
+		bloqueos:    mt.Coll,
+		migraciones: migraciones,
+		dueno:       "prueba",
+	}
+}
+
+func respuestaEscritura(n int32) bson.D {
+	return mtest.CreateSuccessResponse(bson.E{Key: "n", Value: n}, bson.E{Key: "nModified", Value: n})
+}
+
+func TestAdquirir(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("libre o vencido", func(mt *mtest.T) {
+		mt.AddMockResponses(respuestaEscritura(1))
+		if err := runnerDePrueba(mt).adquirir(context.Background()); err != nil {
+			mt.Fatalf("adquirir: %v", err)
+		}
+	})
+
+	mt.Run("tomado por otra instancia", func(mt *mtest.T) {
+		expira := time.Now().Add(time.Minute).Truncate(time.Millisecond)
+		mt.AddMockResponses(
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Code: 11000, Message: "E11000 duplicate key error"}),
+			mtest.CreateCursorResponse(0, mt.Coll.Database().Name()+"."+mt.Coll.Name(), mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: idBloqueo}, {Key: "dueno", Value: "otra-replica"}, {Key: "expira", Value: expira}}),
+		)
+		err := runnerDePrueba(mt).adquirir(context.Background())
+		if !errors.Is(err, ErrBloqueado) || !strings.Contains(err.Error(), "otra-replica") {
+			mt.Fatalf("error = %v, se esperaba ErrBloqueado con el dueño actual", err)
+		}
+	})
+
+	mt.Run("error de la base de datos", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 13, Name: "Unauthorized", Message: "sin permiso"}))
+		err := runnerDePrueba(mt).adquirir(context.Background())
+		if err == nil || errors.Is(err, ErrBloqueado) {
+			mt.Fatalf("error = %v, se esperaba el error de la base de datos", err)
+		}
+	})
+}
+
+func TestBajar(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	// registros simula la colección migraciones con las versiones indicadas aplicadas
+	registros := func(mt *mtest.T, migraciones ...Migracion) bson.D {
+		documentos := make([]bson.D, 0, len(migraciones))
+		for _, migracion := range migraciones {
+			documentos = append(documentos, bson.D{
+				{Key: "_id", Value: migracion.Version},
+				{Key: "nombre", Value: migracion.Nombre},
+				{Key: "checksum", Value: migracion.Checksum},
+			})
+		}
+		return mtest.CreateCursorResponse(0, mt.Coll.Database().Name()+"."+mt.Coll.Name(), mtest.FirstBatch, documentos...)
+	}
+	// falsa registra en revertidas cada vez que se baja
+	var revertidas []int
+	falsa := func(version int, reversible bool) Migracion {
+		migracion := Migracion{
+			Version:  version,
+			Nombre:   "falsa",
+			Checksum: "checksum",
+			Subir:    func(context.Context, *mongo.Database, map[string]string) error { return nil },
+		}
+		if reversible {
+			migracion.Bajar = func(context.Context, *mongo.Database, map[string]string) error {
+				revertidas = append(revertidas, version)
+				return nil
+			}
+		}
+		return migracion
+	}
+
+	mt.Run("revierte de la más reciente a la más antigua", func(mt *mtest.T) {
+		revertidas = nil
+		uno, dos, tres := falsa(1, true), falsa(2, true), falsa(3, true)
+		mt.AddMockResponses(
+			respuestaEscritura(1),         // adquirir
+			registros(mt, uno, dos, tres), // Estado
+			respuestaEscritura(1),         // registro de la 3
+			respuestaEscritura(1),         // registro de la 2
+			respuestaEscritura(1),         // liberar
+		)
+		bajadas, err := runnerDePrueba(mt, uno, dos, tres).Bajar(context.Background(), 2)
+		if err != nil {
+			mt.Fatalf("Bajar: %v", err)
+		}
+		if len(bajadas) != 2 || bajadas[0].Version != 3 || bajadas[1].Version != 2 {
+			mt.Fatalf("Bajar devolvió %v", bajadas)
+		}
+		if len(revertidas) != 2 || revertidas[0] != 3 || revertidas[1] != 2 {
+			mt.Fatalf("se ejecutó Bajar de %v, se esperaba [3 2]", revertidas)
+		}
+	})
+
+	mt.Run("omite las pendientes", func(mt *mtest.T) {
+		revertidas = nil
+		uno, dos := falsa(1, true), falsa(2, true)
+		mt.AddMockResponses(respuestaEscritura(1), registros(mt, uno), respuestaEscritura(1), respuestaEscritura(1))
+		bajadas, err := runnerDePrueba(mt, uno, dos).Bajar(context.Background(), 1)
+		if err != nil {
+			mt.Fatalf("Bajar: %v", err)
+		}
+		if len(bajadas) != 1 || bajadas[0].Version != 1 || len(revertidas) != 1 {
+			mt.Fatalf("Bajar devolvió %v y ejecutó %v, se esperaba solo la 1", bajadas, revertidas)
+		}
+	})
+
+	mt.Run("sin Bajar se detiene", func(mt *mtest.T) {
+		revertidas = nil
+		uno, dos := falsa(1, false), falsa(2, true)
+		mt.AddMockResponses(respuestaEscritura(1), registros(mt, uno, dos), respuestaEscritura(1), respuestaEscritura(1))
+		bajadas, err := runnerDePrueba(mt, uno, dos).Bajar(context.Background(), 2)
+		if err == nil || !strings.Contains(err.Error(), "no se puede revertir") {
+			mt.Fatalf("error = %v, se esperaba que la 1 no se pudiera revertir", err)
+		}
+		if len(bajadas) != 1 || bajadas[0].Version != 2 {
+			mt.Fatalf("Bajar devolvió %v, se esperaba solo la 2", bajadas)
+		}
+	})
+
+	mt.Run("checksum distinto", func(mt *mtest.T) {
+		revertidas = nil
+		uno := falsa(1, true)
+		aplicada := uno
+		aplicada.Checksum = "otro"
+		mt.AddMockResponses(respuestaEscritura(1), registros(mt, aplicada), respuestaEscritura(1))
+		if _, err := runnerDePrueba(mt, uno).Bajar(context.Background(), 1); err == nil || !strings.Contains(err.Error(), "cambió") {
+			mt.Fatalf("error = %v, se esperaba que la 1 figurara modificada", err)
+		}
+		if len(revertidas) > 0 {
+			mt.Fatalf("se revirtió %v con una migración modificada", revertidas)
+		}
+	})
+}
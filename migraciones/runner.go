@@ -0,0 +1,214 @@
+package migraciones
+
+import (
+	"clase_6_echo_mongo/database"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Registro es una migración aplicada, guardado en la colección migraciones
+type Registro struct {
+	Version    int       `bson:"_id"`
+	Nombre     string    `bson:"nombre"`
+	Checksum   string    `bson:"checksum"`
+	AplicadaEn time.Time `bson:"aplicada_en"`
+	DuracionMs int64     `bson:"duracion_ms"`
+}
+
+// Estado combina una migración del binario con su registro en la base de datos
+type Estado struct {
+	Version  int
+	Nombre   string
+	Registro *Registro // nil si está pendiente
+	Checksum string    // vacío si la versión aplicada no existe en este binario
+}
+
+// Aplicada indica si la migración figura como aplicada
+func (e Estado) Aplicada() bool {
+	return e.Registro != nil
+}
+
+// Modificada indica que el archivo de la migración cambió después de aplicarla
+func (e Estado) Modificada() bool {
+	return e.Registro != nil && e.Checksum != "" && e.Registro.Checksum != e.Checksum
+}
+
+// Desconocida indica una versión aplicada que este binario no tiene, ej. un binario más antiguo
+func (e Estado) Desconocida() bool {
+	return e.Registro != nil && e.Checksum == ""
+}
+
+// Runner aplica y revierte las migraciones registradas
+type Runner struct {
+	db          *mongo.Database
+	colecciones map[string]string
+	registros   *mongo.Collection
+	bloqueos    *mongo.Collection
+	migraciones []Migracion
+	dueno       string
+}
+
+// Nuevo prepara el runner con las migraciones registradas en el paquete
+func Nuevo(mongoClient *database.MongoDBClient, dbName string, colecciones map[string]string) *Runner {
+	db := mongoClient.Client.Database(dbName)
+	return &Runner{
+		db:          db,
+		colecciones: colecciones,
+		registros:   db.Collection(colecciones["migraciones"]),
+		bloqueos:    db.Collection(colecciones["migraciones_bloqueo"]),
+		migraciones: Todas(),
+		dueno:       nuevoDueno(),
+	}
+}
+
+// Estado devuelve todas las migraciones, conocidas o aplicadas, ordenadas por versión
+func (r *Runner) Estado(ctx context.Context) ([]Estado, error) {
+	cursor, err := r.registros.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var registros []Registro
+	if err := cursor.All(ctx, &registros); err != nil {
+		return nil, err
+	}
+	aplicadas := make(map[int]*Registro, len(registros))
+	for i := range registros {
+		aplicadas[registros[i].Version] = &registros[i]
+	}
+
+	estados := make([]Estado, 0, len(r.migraciones)+len(registros))
+	for _, migracion := range r.migraciones {
+		estados = append(estados, Estado{
+			Version:  migracion.Version,
+			Nombre:   migracion.Nombre,
+			Registro: aplicadas[migracion.Version],
+			Checksum: migracion.Checksum,
+		})
+		delete(aplicadas, migracion.Version)
+	}
+	for _, registro := range aplicadas {
+		estados = append(estados, Estado{Version: registro.Version, Nombre: registro.Nombre, Registro: registro})
+	}
+	sort.Slice(estados, func(i, j int) bool { return estados[i].Version < estados[j].Version })
+	return estados, nil
+}
+
+// Pendientes devuelve las migraciones que aún no se aplicaron
+func (r *Runner) Pendientes(ctx context.Context) ([]Migracion, error) {
+	estados, err := r.Estado(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pendientes := []Migracion{}
+	for _, estado := range estados {
+		if !estado.Aplicada() {
+			pendientes = append(pendientes, r.buscar(estado.Version))
+		}
+	}
+	return pendientes, nil
+}
+
+// Subir aplica en orden las migraciones pendientes hasta la versión indicada (todas si hasta es 0)
+// y devuelve las aplicadas. Se detiene en la primera que falla.
+func (r *Runner) Subir(ctx context.Context, hasta int) ([]Migracion, error) {
+	aplicadas := []Migracion{}
+	err := r.conBloqueo(ctx, func(ctx context.Context) error {
+		// El estado se lee con el bloqueo tomado: otra instancia pudo migrar mientras se esperaba
+		estados, err := r.Estado(ctx)
+		if err != nil {
+			return err
+		}
+		if err := verificarIntegridad(estados); err != nil {
+			return err
+		}
+
+		for _, estado := range estados {
+			if estado.Aplicada() || (hasta > 0 && estado.Version > hasta) {
+				continue
+			}
+			migracion := r.buscar(estado.Version)
+			inicio := time.Now()
+			if err := migracion.Subir(ctx, r.db, r.colecciones); err != nil {
+				return fmt.Errorf("la migración %d (%s) falló: %w", migracion.Version, migracion.Nombre, err)
+			}
+			_, err := r.registros.InsertOne(ctx, Registro{
+				Version:    migracion.Version,
+				Nombre:     migracion.Nombre,
+				Checksum:   migracion.Checksum,
+				AplicadaEn: time.Now(),
+				DuracionMs: time.Since(inicio).Milliseconds(),
+			})
+			if err != nil {
+				return fmt.Errorf("la migración %d se aplicó pero no se pudo registrar: %w", migracion.Version, err)
+			}
+			aplicadas = append(aplicadas, migracion)
+		}
+		return nil
+	})
+	return aplicadas, err
+}
+
+// Bajar revierte las últimas migraciones aplicadas, de la más reciente a la más antigua, y devuelve las revertidas
+func (r *Runner) Bajar(ctx context.Context, pasos int) ([]Migracion, error) {
+	revertidas := []Migracion{}
+	err := r.conBloqueo(ctx, func(ctx context.Context) error {
+		estados, err := r.Estado(ctx)
+		if err != nil {
+			return err
+		}
+		if err := verificarIntegridad(estados); err != nil {
+			return err
+		}
+
+		for i := len(estados) - 1; i >= 0 && len(revertidas) < pasos; i-- {
+			if !estados[i].Aplicada() {
+				continue
+			}
+			migracion := r.buscar(estados[i].Version)
+			if migracion.Bajar == nil {
+				return fmt.Errorf("la migración %d (%s) no se puede revertir", migracion.Version, migracion.Nombre)
+			}
+			if err := migracion.Bajar(ctx, r.db, r.colecciones); err != nil {
+				return fmt.Errorf("la reversión de la migración %d (%s) falló: %w", migracion.Version, migracion.Nombre, err)
+			}
+			if _, err := r.registros.DeleteOne(ctx, bson.M{"_id": migracion.Version}); err != nil {
+				return fmt.Errorf("la migración %d se revirtió pero no se pudo borrar su registro: %w", migracion.Version, err)
+			}
+			revertidas = append(revertidas, migracion)
+		}
+		return nil
+	})
+	return revertidas, err
+}
+
+func (r *Runner) buscar(version int) Migracion {
+	for _, migracion := range r.migraciones {
+		if migracion.Version == version {
+			return migracion
+		}
+	}
+	return Migracion{}
+}
+
+// verificarIntegridad rechaza continuar si una migración aplicada cambió o no existe en este binario
+func verificarIntegridad(estados []Estado) error {
+	problemas := []string{}
+	for _, estado := range estados {
+		switch {
+		case estado.Modificada():
+			problemas = append(problemas, fmt.Sprintf("%d (%s) cambió después de aplicarse", estado.Version, estado.Nombre))
+		case estado.Desconocida():
+			problemas = append(problemas, fmt.Sprintf("%d (%s) está aplicada pero no existe en este binario", estado.Version, estado.Nombre))
+		}
+	}
+	if len(problemas) > 0 {
+		return fmt.Errorf("migraciones inconsistentes: %s", strings.Join(problemas, "; "))
+	}
+	return nil
+}
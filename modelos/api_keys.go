@@ -0,0 +1,55 @@
+package modelos
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Recursos y acciones con que se arman los scopes de las API keys, ej. "productos:leer"
+var (
+	RecursosApiKey = []string{"categorias", "productos", "productos_fotos"}
+	AccionesApiKey = []string{"leer", "escribir", "eliminar"}
+)
+
+// ScopesValidos son todas las combinaciones recurso:accion aceptadas
+var ScopesValidos = func() map[string]bool {
+	scopes := map[string]bool{}
+	for _, recurso := range RecursosApiKey {
+		for _, accion := range AccionesApiKey {
+			scopes[recurso+":"+accion] = true
+		}
+	}
+	return scopes
+}()
+
+// ApiKey da acceso a una integración sin usuario humano. La clave que se entrega es
+// "<prefijo>.<secreto>"; el prefijo sirve para buscarla y del secreto solo se guarda el hash.
+type ApiKey struct {
+	ID        primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	Nombre    string             `json:"nombre" bson:"nombre"`
+	Prefijo   string             `json:"prefijo" bson:"prefijo"`
+	Hash      string             `json:"-" bson:"hash"`
+	Scopes    []string           `json:"scopes" bson:"scopes"`
+	CreadaPor primitive.ObjectID `json:"creada_por" bson:"creada_por"`
+	Creada    time.Time          `json:"creada" bson:"creada"`
+	Expira    *time.Time         `json:"expira,omitempty" bson:"expira,omitempty"` // nil: no expira
+	UltimoUso *time.Time         `json:"ultimo_uso,omitempty" bson:"ultimo_uso,omitempty"`
+	Revocada  bool               `json:"revocada" bson:"revocada"`
+}
+
+// TieneScope indica si la clave permite el scope indicado
+func (k *ApiKey) TieneScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type CrearApiKeyDto struct {
+	Nombre     string   `json:"nombre" validate:"required"`
+	Scopes     []string `json:"scopes" validate:"required,min=1,dive,scope"`
+	ExpiraDias int      `json:"expira_dias" validate:"omitempty,min=1,max=3650"` // 0: no expira
+}
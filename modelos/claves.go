@@ -0,0 +1,21 @@
+package modelos
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ClaveFirma es un par de claves para firmar tokens. Se crea una por cada periodo de rotación
+// y se sigue publicando en el JWKS hasta Expira, para validar los tokens ya emitidos.
+type ClaveFirma struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	Kid         string             `bson:"kid"`
+	Algoritmo   string             `bson:"algoritmo"` // RS256 o EdDSA
+	Periodo     int64              `bson:"periodo"`   // Número del periodo de rotación en que firma
+	Privada     []byte             `bson:"privada"`   // PKCS#8 DER
+	Publica     []byte             `bson:"publica"`   // PKIX DER
+	Creada      time.Time          `bson:"creada"`
+	ActivaHasta time.Time          `bson:"activa_hasta"` // Desde aquí ya no firma
+	Expira      time.Time          `bson:"expira"`       // Desde aquí ya no valida
+}
@@ -1,6 +1,46 @@
 package modelos
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FotoProducto representa una imagen de producto en la base de datos
+type FotoProducto struct {
+	ID         primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	Nombre     string             `json:"nombre" bson:"nombre"`
+	ProductoID primitive.ObjectID `json:"producto_id" bson:"producto_id"`
+	Variantes  map[string]string  `json:"variantes,omitempty" bson:"variantes,omitempty"` // Nombre de la variante => archivo
+	Timestamp  int64              `json:"timestamp,omitempty" bson:"timestamp"`
+}
+
+// ArchivoPendiente es un archivo cuya foto ya se eliminó de la base de datos pero que no se pudo
+// borrar del almacenamiento; un proceso en segundo plano lo reintenta
+type ArchivoPendiente struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty"`
+	Nombre         string             `bson:"nombre"`  // Archivo original, sirve para comprobar si volvió a usarse
+	Nombres        []string           `bson:"nombres"` // Original y variantes
+	Intentos       int                `bson:"intentos"`
+	UltimoError    string             `bson:"ultimo_error"`
+	ProximoIntento time.Time          `bson:"proximo_intento"`
+	Timestamp      int64              `bson:"timestamp"`
+}
+
+// ReferenciaArchivo cuenta las fotos que usan un archivo original. Borrando marca el documento mientras
+// se borran los archivos de la última foto: hasta que termina nadie puede volver a referenciarlo.
+type ReferenciaArchivo struct {
+	Nombre      string `bson:"_id"`
+	Referencias int    `bson:"referencias"`
+	Borrando    bool   `bson:"borrando,omitempty"`
+}
+
+// ImagenProducto es la respuesta pública de una foto, con la URL en lugar del nombre del archivo
+type ImagenProducto struct {
+	ID        primitive.ObjectID `json:"_id"`
+	Nombre    string             `json:"nombre"`
+	Variantes map[string]string  `json:"variantes"`
+}
 
 // UploadFotoProducto representa una imagen de producto en la base de datos
 // NO SE ESTÁ UTILIZANDO
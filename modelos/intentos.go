@@ -0,0 +1,22 @@
+package modelos
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// IntentoLogin acumula los intentos fallidos de login de una cuenta ("correo:...") o de una IP ("ip:...")
+type IntentoLogin struct {
+	ID             primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	Clave          string             `json:"clave" bson:"clave"`
+	Fallos         int                `json:"fallos" bson:"fallos"`
+	UltimoFallo    time.Time          `json:"ultimo_fallo" bson:"ultimo_fallo"`
+	BloqueadoHasta time.Time          `json:"bloqueado_hasta" bson:"bloqueado_hasta"`
+}
+
+// DesbloqueoDto indica la cuenta y/o la IP a desbloquear; al menos uno es requerido
+type DesbloqueoDto struct {
+	Correo string `json:"correo" validate:"required_without=IP,omitempty,email"`
+	IP     string `json:"ip" validate:"required_without=Correo,omitempty,ip"`
+}
@@ -0,0 +1,26 @@
+package modelos
+
+// MFAUsuario guarda el segundo factor TOTP de un usuario
+type MFAUsuario struct {
+	Activo              bool     `bson:"activo"`
+	Secreto             string   `bson:"secreto,omitempty"`              // Secreto confirmado
+	SecretoPendiente    string   `bson:"secreto_pendiente,omitempty"`    // Secreto inscrito a la espera de confirmación
+	UltimoPaso          int64    `bson:"ultimo_paso,omitempty"`          // Último periodo TOTP aceptado, evita reutilizar un código
+	CodigosRecuperacion []string `bson:"codigos_recuperacion,omitempty"` // Hash de los códigos de un solo uso
+}
+
+type CodigoMFADto struct {
+	Codigo string `json:"codigo" validate:"required"`
+}
+
+type LoginMFADto struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Codigo   string `json:"codigo" validate:"required"` // Código TOTP o código de recuperación
+}
+
+// LoginMFAPendienteDto es la respuesta del login cuando la cuenta tiene segundo factor
+type LoginMFAPendienteDto struct {
+	MFARequerido bool   `json:"mfa_requerido"`
+	MFAToken     string `json:"mfa_token"`
+	ExpiraEn     int64  `json:"expira_en"`
+}
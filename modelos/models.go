@@ -1,22 +1,83 @@
 package modelos
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"encoding/json"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 // Categoria representa una categoria en la base de datos
 type Categoria struct {
-	Nombre    string `json:"nombre" bson:"nombre"`
-	Slug      string `json:"slug,omitempty" bson:"slug"`
-	Timestamp int64  `json:"timestamp,omitempty" bson:"timestamp"`
+	ID     primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	Nombre string             `json:"nombre" bson:"nombre"`
+	Slug   string             `json:"slug,omitempty" bson:"slug"` // Si se envía se usa tal cual, si no se deriva del nombre
+	// Slugs que tuvo antes de ser renombrada, siguen resolviendo con una redirección
+	SlugsAnteriores []string            `json:"slugs_anteriores,omitempty" bson:"slugs_anteriores,omitempty"`
+	ParentID        *primitive.ObjectID `json:"parent_id,omitempty" bson:"parent_id,omitempty"` // nil en las categorías raíz
+	// Ancestros desde la raíz hasta el padre; permite buscar descendientes sin recorrer el árbol
+	Ancestros []primitive.ObjectID `json:"ancestros" bson:"ancestros"`
+	Timestamp int64                `json:"timestamp,omitempty" bson:"timestamp"`
+}
+
+// EdicionCategoria es el cuerpo de EditarCategoria. parent_id omitido deja la categoría donde está,
+// null (o "") la mueve a la raíz y un ID la mueve debajo de esa categoría.
+type EdicionCategoria struct {
+	Nombre   string          `json:"nombre"`
+	Slug     string          `json:"slug"`      // Vacío para derivarlo del nombre
+	ParentID json.RawMessage `json:"parent_id"` // Crudo para distinguir null de un campo omitido
+}
+
+// Padre interpreta parent_id: mover es false si se omitió, y padre es nil cuando va a la raíz
+func (e *EdicionCategoria) Padre() (mover bool, padre *primitive.ObjectID, err error) {
+	if len(e.ParentID) == 0 {
+		return false, nil, nil
+	}
+	if string(e.ParentID) == "null" {
+		return true, nil, nil
+	}
+	var id primitive.ObjectID
+	if err := json.Unmarshal(e.ParentID, &id); err != nil {
+		return false, nil, errors.New("parent_id debe ser un ID, null o vacío")
+	}
+	if id.IsZero() {
+		return true, nil, nil
+	}
+	return true, &id, nil
+}
+
+// NodoCategoria es una categoría con sus subcategorías, para GET /categorias/arbol
+type NodoCategoria struct {
+	Categoria `bson:",inline"`
+	Hijos     []*NodoCategoria `json:"hijos"`
+}
+
+// MigaCategoria es un paso de la ruta desde la raíz hasta una categoría
+type MigaCategoria struct {
+	ID     primitive.ObjectID `json:"_id" bson:"_id"`
+	Nombre string             `json:"nombre" bson:"nombre"`
+	Slug   string             `json:"slug" bson:"slug"`
 }
 
 // Producto representa un producto en la base de datos
 type Producto struct {
-	Nombre      string `json:"nombre" validate:"required,min=2,max=100" bson:"nombre"`
-	Precio      int    `json:"precio" validate:"required,gt=0" bson:"precio"`
-	Stock       int    `json:"stock" validate:"required,gte=0" bson:"stock"`
-	Descripcion string `json:"descripcion" validate:"required,min=10" bson:"descripcion"`
-	CategoriaID string `json:"categoria_id" validate:"required,len=24" bson:"categoria_id"`
-	Timestamp   int64  `json:"timestamp,omitempty" validate:"omitempty" bson:"timestamp"`
+	ID          primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	Nombre      string             `json:"nombre" validate:"required,min=2,max=100" bson:"nombre"`
+	Precio      int                `json:"precio" validate:"required,gt=0" bson:"precio"`
+	Stock       int                `json:"stock" validate:"required,gte=0" bson:"stock"`
+	Descripcion string             `json:"descripcion" validate:"required,min=10" bson:"descripcion"`
+	CategoriaID primitive.ObjectID `json:"categoria_id" validate:"required" bson:"categoria_id"`
+	Slug        string             `json:"slug" bson:"slug,omitempty"`                                    // Se deriva del nombre
+	Sku         string             `json:"sku,omitempty" validate:"omitempty,ean13" bson:"sku,omitempty"` // Código de barras EAN-13
+	Timestamp   int64              `json:"timestamp,omitempty" validate:"omitempty" bson:"timestamp"`
+	// Slugs que tuvo antes de ser renombrado, siguen resolviendo con una redirección
+	SlugsAnteriores []string `json:"slugs_anteriores,omitempty" bson:"slugs_anteriores,omitempty"`
+}
+
+// ProductoDetalle es un producto junto a su categoría obtenida con $lookup
+type ProductoDetalle struct {
+	Producto  `bson:",inline"`
+	Categoria []Categoria `json:"categoria" bson:"categoria"`
 }
 
 type UpdateProducto struct {
@@ -25,4 +86,5 @@ type UpdateProducto struct {
 	Stock       int                `json:"stock"`
 	Descripcion string             `json:"descripcion"`
 	CategoriaID primitive.ObjectID `json:"categoria_id" bson:"categoria_id"`
+	Sku         *string            `json:"sku" validate:"omitempty,ean13" bson:"sku"` // nil lo deja igual, "" lo quita del producto
 }
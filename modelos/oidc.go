@@ -0,0 +1,26 @@
+package modelos
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// EstadoOIDC guarda lo necesario para completar un login con un proveedor externo.
+// Se busca por el hash del state y se elimina al usarse.
+type EstadoOIDC struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	Hash        string             `bson:"hash"`
+	Proveedor   string             `bson:"proveedor"`
+	Verificador string             `bson:"verificador"` // code_verifier de PKCE
+	Nonce       string             `bson:"nonce"`
+	Expira      time.Time          `bson:"expira"`
+}
+
+// IdentidadExterna vincula un usuario con su cuenta en un proveedor OIDC
+type IdentidadExterna struct {
+	Proveedor string    `json:"proveedor" bson:"proveedor"`
+	Emisor    string    `json:"emisor" bson:"emisor"`
+	Sujeto    string    `json:"sujeto" bson:"sujeto"`
+	Vinculada time.Time `json:"vinculada" bson:"vinculada"`
+}
@@ -0,0 +1,38 @@
+package modelos
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PasswordReset es un token de recuperación de contraseña de un solo uso. Solo se guarda su hash.
+type PasswordReset struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	Hash      string             `bson:"hash"`
+	UsuarioID primitive.ObjectID `bson:"usuario_id"`
+	Expira    time.Time          `bson:"expira"`
+	Usado     bool               `bson:"usado"`
+	Timestamp int64              `bson:"timestamp"`
+}
+
+// VerificacionCorreo es un token de un solo uso para confirmar un correo. Solo se guarda su hash.
+type VerificacionCorreo struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	Hash      string             `bson:"hash"`
+	UsuarioID primitive.ObjectID `bson:"usuario_id"`
+	Correo    string             `bson:"correo"` // Correo que se está confirmando
+	Expira    time.Time          `bson:"expira"`
+	Usado     bool               `bson:"usado"`
+	Timestamp int64              `bson:"timestamp"`
+}
+
+// CorreoDto es el cuerpo de las peticiones que solo reciben un correo (recuperación, reenvío de verificación)
+type CorreoDto struct {
+	Correo string `json:"correo" validate:"required,email"`
+}
+
+type ResetPasswordDto struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,password"`
+}
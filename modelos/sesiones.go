@@ -0,0 +1,32 @@
+package modelos
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RefreshToken es un refresh token emitido. Solo se guarda el hash del valor entregado al cliente.
+// Todos los tokens que nacen de un mismo login comparten Familia: si uno ya usado se presenta
+// de nuevo se asume robo y se revoca la familia completa.
+type RefreshToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	Hash      string             `bson:"hash"`
+	UsuarioID primitive.ObjectID `bson:"usuario_id"`
+	Familia   string             `bson:"familia"`
+	Expira    time.Time          `bson:"expira"`
+	Usado     bool               `bson:"usado"`
+	Revocado  bool               `bson:"revocado"`
+	MFA       bool               `bson:"mfa"` // La familia nació de un login con segundo factor
+	Timestamp int64              `bson:"timestamp"`
+}
+
+// TokenRevocado es un token de acceso invalidado antes de expirar, identificado por su claim jti
+type TokenRevocado struct {
+	Jti    string    `bson:"jti"`
+	Expira time.Time `bson:"expira"` // Pasada esta fecha el token ya no es válido de todos modos
+}
+
+type RefreshDto struct {
+	RefreshToken string `json:"refresh_token"`
+}
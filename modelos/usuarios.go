@@ -1,11 +1,153 @@
 package modelos
 
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Roles de usuario. Cada ruta declara cuáles puede usar con middleware_custom.RequireRole
+const (
+	RolAdmin  = "admin"  // Administra todo, incluidas las eliminaciones
+	RolEditor = "editor" // Crea y edita el catálogo
+	RolViewer = "viewer" // Solo lectura, rol por defecto al registrarse
+)
+
+// Estados de una cuenta. Un usuario sin estado se considera activo.
+const (
+	EstadoActivo        = "activo"
+	EstadoDeshabilitado = "deshabilitado" // No puede iniciar sesión ni usar sus tokens
+)
+
+// RolesValidos sirve para validar un rol recibido desde fuera
+var RolesValidos = map[string]bool{
+	RolAdmin:  true,
+	RolEditor: true,
+	RolViewer: true,
+}
+
 type UsuarioDto struct {
-	Nombre    string `json:"nombre" validate:"required" bson:"nombre"`
-	Correo    string `json:"correo" validate:"required,email" bson:"correo"`
-	Telefono  string `json:"telefono" validate:"required,numeric" bson:"telefono"`
-	Password  string `json:"password" validate:"required,password" bson:"password"`
-	Timestamp int64  `json:"timestamp,omitempty" validate:"omitempty" bson:"timestamp"`
+	ID         primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	Nombre     string             `json:"nombre" validate:"required" bson:"nombre"`
+	Correo     string             `json:"correo" validate:"required,email" bson:"correo"`
+	Telefono   string             `json:"telefono" validate:"required,numeric" bson:"telefono"`
+	Password   string             `json:"password" validate:"required,password" bson:"password"`
+	Rol        string             `json:"-" bson:"rol,omitempty"`        // No se puede elegir al registrarse
+	Verificado *bool              `json:"-" bson:"verificado,omitempty"` // nil en cuentas anteriores a la verificación
+	MFA        *MFAUsuario        `json:"-" bson:"mfa,omitempty"`
+	// Correo nuevo a la espera de verificación; el actual se mantiene hasta confirmarlo
+	CorreoPendiente string     `json:"-" bson:"correo_pendiente,omitempty"`
+	EliminadoEn     *time.Time `json:"-" bson:"eliminado_en,omitempty"` // Eliminación lógica, se purga pasado el periodo de gracia
+	Estado          string     `json:"-" bson:"estado,omitempty"`
+	ResetRequerido  bool       `json:"-" bson:"reset_requerido,omitempty"` // Un administrador exigió cambiar la contraseña
+	// Se incrementa para invalidar de una vez todos los tokens emitidos al usuario (claim "ver")
+	TokenVersion int `json:"-" bson:"token_version,omitempty"`
+	// Cuentas de proveedores OIDC con que puede iniciar sesión; las creadas por SSO no tienen contraseña
+	Identidades []IdentidadExterna `json:"-" bson:"identidades,omitempty"`
+	Timestamp   int64              `json:"timestamp,omitempty" validate:"omitempty" bson:"timestamp"`
+}
+
+// RolEfectivo devuelve el rol del usuario; los usuarios anteriores a los roles se tratan como viewer
+func (u *UsuarioDto) RolEfectivo() string {
+	if u.Rol == "" {
+		return RolViewer
+	}
+	return u.Rol
+}
+
+// EstaVerificado indica si el correo fue confirmado. Las cuentas creadas antes de existir
+// la verificación no tienen el campo y se consideran verificadas.
+func (u *UsuarioDto) EstaVerificado() bool {
+	return u.Verificado == nil || *u.Verificado
+}
+
+// EstaHabilitado indica si la cuenta puede iniciar sesión
+func (u *UsuarioDto) EstaHabilitado() bool {
+	return u.Estado != EstadoDeshabilitado
+}
+
+// TieneMFA indica si el usuario confirmó la inscripción de su segundo factor
+func (u *UsuarioDto) TieneMFA() bool {
+	return u.MFA != nil && u.MFA.Activo
+}
+
+// PerfilDto son los datos que el usuario puede modificar de su perfil
+type PerfilDto struct {
+	Nombre   string `json:"nombre" validate:"required"`
+	Telefono string `json:"telefono" validate:"required,numeric"`
+}
+
+// PerfilRespuestaDto es el perfil que se entrega al usuario, sin datos sensibles
+type PerfilRespuestaDto struct {
+	Nombre          string `json:"nombre"`
+	Correo          string `json:"correo"`
+	CorreoPendiente string `json:"correo_pendiente,omitempty"`
+	Telefono        string `json:"telefono"`
+	Rol             string `json:"rol"`
+	Verificado      bool   `json:"verificado"`
+	MFA             bool   `json:"mfa"`
+	Timestamp       int64  `json:"timestamp"`
+}
+
+type CambioPasswordDto struct {
+	PasswordActual string `json:"password_actual" validate:"required"`
+	PasswordNueva  string `json:"password_nueva" validate:"required,password"`
+}
+
+type CambioCorreoDto struct {
+	Correo   string `json:"correo" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+}
+
+// ConfirmarPasswordDto confirma una acción sensible con la contraseña actual
+type ConfirmarPasswordDto struct {
+	Password string `json:"password" validate:"required"`
+}
+
+// UsuarioAdminDto es la vista de un usuario para los administradores
+type UsuarioAdminDto struct {
+	ID              primitive.ObjectID `json:"_id"`
+	Nombre          string             `json:"nombre"`
+	Correo          string             `json:"correo"`
+	CorreoPendiente string             `json:"correo_pendiente,omitempty"`
+	Telefono        string             `json:"telefono"`
+	Rol             string             `json:"rol"`
+	Estado          string             `json:"estado"`
+	Verificado      bool               `json:"verificado"`
+	MFA             bool               `json:"mfa"`
+	ResetRequerido  bool               `json:"reset_requerido"`
+	EliminadoEn     *time.Time         `json:"eliminado_en,omitempty"`
+	Timestamp       int64              `json:"timestamp"`
+}
+
+// VistaAdmin arma la vista del usuario para los administradores, sin datos sensibles
+func (u *UsuarioDto) VistaAdmin() UsuarioAdminDto {
+	estado := u.Estado
+	if estado == "" {
+		estado = EstadoActivo
+	}
+	return UsuarioAdminDto{
+		ID:              u.ID,
+		Nombre:          u.Nombre,
+		Correo:          u.Correo,
+		CorreoPendiente: u.CorreoPendiente,
+		Telefono:        u.Telefono,
+		Rol:             u.RolEfectivo(),
+		Estado:          estado,
+		Verificado:      u.EstaVerificado(),
+		MFA:             u.TieneMFA(),
+		ResetRequerido:  u.ResetRequerido,
+		EliminadoEn:     u.EliminadoEn,
+		Timestamp:       u.Timestamp,
+	}
+}
+
+type CambioRolDto struct {
+	Rol string `json:"rol" validate:"required,oneof=admin editor viewer"`
+}
+
+type CambioEstadoDto struct {
+	Estado string `json:"estado" validate:"required,oneof=activo deshabilitado"`
 }
 
 type LoginDto struct {
@@ -14,6 +156,9 @@ type LoginDto struct {
 }
 
 type LoginRespuestaDto struct {
-	Nombre string `json:"nombre"`
-	Token  string `json:"token"`
+	Nombre       string `json:"nombre"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiraEn     int64  `json:"expira_en"` // Segundos de vigencia del token de acceso
+	Verificado   bool   `json:"verificado"`
 }
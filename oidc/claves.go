@@ -0,0 +1,136 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// jwk es una clave pública del proveedor en formato JSON Web Key (RFC 7517)
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Mínimo entre dos descargas de claves provocadas por un kid desconocido
+const intervaloRecarga = time.Minute
+
+// clave devuelve la clave pública con que verificar un token del proveedor. Si el kid no está
+// en caché se vuelven a descargar las claves, el proveedor pudo haberlas rotado.
+func (p *Proveedor) clave(ctx context.Context, kid string, metodo jwt.SigningMethod) (interface{}, error) {
+	documento, err := p.Descubrir(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	publica, ok := p.claves[kid]
+	vencidas := time.Since(p.clavesCargadas) > duracionCache
+	if (!ok && time.Since(p.clavesCargadas) > intervaloRecarga) || vencidas {
+		var conjunto struct {
+			Keys []jwk `json:"keys"`
+		}
+		if err := p.obtenerJSON(ctx, documento.JwksURI, &conjunto); err != nil {
+			return nil, fmt.Errorf("error al obtener las claves de %s: %w", p.Nombre, err)
+		}
+
+		claves := make(map[string]interface{}, len(conjunto.Keys))
+		for _, k := range conjunto.Keys {
+			if k.Use != "" && k.Use != "sig" {
+				continue
+			}
+			if clave, err := k.publica(); err == nil {
+				claves[k.Kid] = clave
+			}
+		}
+		p.claves, p.clavesCargadas = claves, time.Now()
+		publica, ok = p.claves[kid]
+	}
+	if !ok {
+		return nil, fmt.Errorf("clave '%s' desconocida para %s", kid, p.Nombre)
+	}
+
+	// La clave debe ser del tipo que corresponde al algoritmo del token
+	switch publica.(type) {
+	case *rsa.PublicKey:
+		_, rsaOK := metodo.(*jwt.SigningMethodRSA)
+		_, pssOK := metodo.(*jwt.SigningMethodRSAPSS)
+		ok = rsaOK || pssOK
+	case *ecdsa.PublicKey:
+		_, ok = metodo.(*jwt.SigningMethodECDSA)
+	case ed25519.PublicKey:
+		_, ok = metodo.(*jwt.SigningMethodEd25519)
+	}
+	if !ok {
+		return nil, fmt.Errorf("la clave '%s' no admite el algoritmo %s", kid, metodo.Alg())
+	}
+	return publica, nil
+}
+
+// publica convierte la JWK en la clave de crypto correspondiente
+func (k jwk) publica() (interface{}, error) {
+	decodificar := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decodificar(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodificar(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curva elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curva = elliptic.P256()
+		case "P-384":
+			curva = elliptic.P384()
+		case "P-521":
+			curva = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("curva '%s' no soportada", k.Crv)
+		}
+		x, err := decodificar(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodificar(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curva, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("curva '%s' no soportada", k.Crv)
+		}
+		x, err := decodificar(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("clave Ed25519 de largo inválido")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("tipo de clave '%s' no soportado", k.Kty)
+	}
+}
@@ -0,0 +1,65 @@
+package oidc
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Proveedores son los proveedores configurados, por nombre
+type Proveedores map[string]*Proveedor
+
+// DesdeEntorno lee los proveedores listados en OIDC_PROVEEDORES (ej. "corporativo,google").
+// Cada uno se configura con OIDC_<NOMBRE>_EMISOR, _CLIENT_ID, _CLIENT_SECRET, _REDIRECT_URL,
+// _SCOPES (por defecto "email profile") y _DOMINIOS, separados por comas.
+func DesdeEntorno() (Proveedores, error) {
+	proveedores := Proveedores{}
+	for _, nombre := range lista(os.Getenv("OIDC_PROVEEDORES")) {
+		nombre = strings.ToLower(nombre)
+		variable := func(sufijo string) string {
+			return os.Getenv("OIDC_" + strings.ToUpper(nombre) + "_" + sufijo)
+		}
+
+		scopes := lista(variable("SCOPES"))
+		if len(scopes) == 0 {
+			scopes = []string{"email", "profile"}
+		}
+		dominios := lista(strings.ToLower(variable("DOMINIOS")))
+
+		proveedor, err := NuevoProveedor(Config{
+			Nombre:       nombre,
+			Emisor:       variable("EMISOR"),
+			ClientID:     variable("CLIENT_ID"),
+			ClientSecret: variable("CLIENT_SECRET"),
+			RedirectURL:  variable("REDIRECT_URL"),
+			Scopes:       scopes,
+			Dominios:     dominios,
+		}, nil)
+		if err != nil {
+			return nil, fmt.Errorf("proveedor OIDC '%s': %w", nombre, err)
+		}
+		proveedores[nombre] = proveedor
+	}
+	return proveedores, nil
+}
+
+// SSOPara devuelve el proveedor por el que debe iniciar sesión el correo, o nil si puede usar contraseña
+func (ps Proveedores) SSOPara(correo string) *Proveedor {
+	for _, proveedor := range ps {
+		if proveedor.RequiereSSO(correo) {
+			return proveedor
+		}
+	}
+	return nil
+}
+
+// lista separa por comas, descartando los elementos vacíos
+func lista(valor string) []string {
+	var elementos []string
+	for _, elemento := range strings.Split(valor, ",") {
+		if elemento = strings.TrimSpace(elemento); elemento != "" {
+			elementos = append(elementos, elemento)
+		}
+	}
+	return elementos
+}
@@ -0,0 +1,260 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// Config son los datos de un proveedor OpenID Connect registrado para esta API
+type Config struct {
+	Nombre       string // Identificador en la URL, ej. "corporativo"
+	Emisor       string // Issuer, de él se obtiene el documento de descubrimiento
+	ClientID     string
+	ClientSecret string   // Vacío para clientes públicos, que solo se autentican con PKCE
+	RedirectURL  string   // URL del callback registrada en el proveedor
+	Scopes       []string // Además de "openid"
+	Dominios     []string // Los correos de estos dominios solo pueden iniciar sesión por este proveedor
+}
+
+// Descubrimiento es el subconjunto usado del documento /.well-known/openid-configuration
+type Descubrimiento struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+// Identidad es el usuario externo que confirma el ID token
+type Identidad struct {
+	Emisor           string
+	Sujeto           string
+	Correo           string
+	CorreoVerificado bool
+	Nombre           string
+}
+
+// Tiempo que se reutilizan el descubrimiento y las claves antes de volver a pedirlos
+const duracionCache = time.Hour
+
+// Tolerancia a la diferencia de reloj con el proveedor al validar exp e iat
+const toleranciaReloj = time.Minute
+
+// Proveedor implementa el flujo authorization code con PKCE contra un proveedor OIDC
+type Proveedor struct {
+	Config
+	cliente *http.Client
+
+	mu             sync.Mutex
+	descubrimiento *Descubrimiento
+	descubierto    time.Time
+	claves         map[string]interface{}
+	clavesCargadas time.Time
+}
+
+// NuevoProveedor crea el proveedor; cliente nil usa uno con timeout de 10 segundos
+func NuevoProveedor(config Config, cliente *http.Client) (*Proveedor, error) {
+	if config.Nombre == "" || config.Emisor == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("el proveedor necesita nombre, emisor, client id y redirect url")
+	}
+	emisor, err := url.Parse(config.Emisor)
+	if err != nil || emisor.Host == "" {
+		return nil, fmt.Errorf("emisor '%s' inválido", config.Emisor)
+	}
+	// Fuera de localhost (ej. un servidor de pruebas) el emisor debe usar https
+	if emisor.Scheme != "https" && emisor.Hostname() != "localhost" && emisor.Hostname() != "127.0.0.1" {
+		return nil, fmt.Errorf("el emisor '%s' debe usar https", config.Emisor)
+	}
+	config.Emisor = strings.TrimSuffix(config.Emisor, "/")
+	if cliente == nil {
+		cliente = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Proveedor{Config: config, cliente: cliente}, nil
+}
+
+// obtenerJSON hace un GET y decodifica la respuesta
+func (p *Proveedor) obtenerJSON(ctx context.Context, direccion string, destino interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, direccion, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := p.cliente.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s respondió %d", direccion, res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(destino)
+}
+
+// Descubrir devuelve el documento de descubrimiento del emisor, en caché por una hora
+func (p *Proveedor) Descubrir(ctx context.Context) (*Descubrimiento, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.descubrimiento != nil && time.Since(p.descubierto) < duracionCache {
+		return p.descubrimiento, nil
+	}
+
+	documento := new(Descubrimiento)
+	if err := p.obtenerJSON(ctx, p.Emisor+"/.well-known/openid-configuration", documento); err != nil {
+		return nil, fmt.Errorf("error al obtener el descubrimiento de %s: %w", p.Nombre, err)
+	}
+	// El issuer declarado debe ser exactamente el configurado (OpenID Connect Discovery, sección 4.3)
+	if strings.TrimSuffix(documento.Issuer, "/") != p.Emisor {
+		return nil, fmt.Errorf("el descubrimiento de %s declara el issuer '%s'", p.Nombre, documento.Issuer)
+	}
+	if documento.AuthorizationEndpoint == "" || documento.TokenEndpoint == "" || documento.JwksURI == "" {
+		return nil, fmt.Errorf("el descubrimiento de %s está incompleto", p.Nombre)
+	}
+
+	p.descubrimiento, p.descubierto = documento, time.Now()
+	return documento, nil
+}
+
+// URLAutorizacion arma la URL a la que se redirige al usuario para autenticarse en el proveedor
+func (p *Proveedor) URLAutorizacion(ctx context.Context, estado, nonce, verificador string) (string, error) {
+	documento, err := p.Descubrir(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	parametros := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, p.Scopes...), " ")},
+		"state":                 {estado},
+		"nonce":                 {nonce},
+		"code_challenge":        {DesafioS256(verificador)},
+		"code_challenge_method": {"S256"},
+	}
+
+	separador := "?"
+	if strings.Contains(documento.AuthorizationEndpoint, "?") {
+		separador = "&"
+	}
+	return documento.AuthorizationEndpoint + separador + parametros.Encode(), nil
+}
+
+// Canjear cambia el código de autorización por los tokens y devuelve la identidad del ID token ya validado
+func (p *Proveedor) Canjear(ctx context.Context, codigo, verificador, nonce string) (*Identidad, error) {
+	documento, err := p.Descubrir(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	formulario := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {codigo},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {verificador},
+	}
+	if p.ClientSecret == "" {
+		formulario.Set("client_id", p.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, documento.TokenEndpoint, strings.NewReader(formulario.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	res, err := p.cliente.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error al canjear el código con %s: %w", p.Nombre, err)
+	}
+	defer res.Body.Close()
+
+	var respuesta struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&respuesta); err != nil {
+		return nil, fmt.Errorf("respuesta inválida del token endpoint de %s: %w", p.Nombre, err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s rechazó el código: %s %s", p.Nombre, respuesta.Error, respuesta.ErrorDescription)
+	}
+	if respuesta.IDToken == "" {
+		return nil, fmt.Errorf("%s no entregó un id_token", p.Nombre)
+	}
+
+	return p.ValidarIDToken(ctx, respuesta.IDToken, nonce)
+}
+
+// ValidarIDToken verifica firma, emisor, audiencia, vigencia y nonce del ID token
+func (p *Proveedor) ValidarIDToken(ctx context.Context, idToken, nonce string) (*Identidad, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.clave(ctx, kid, token.Method)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithIssuer(p.Emisor),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(toleranciaReloj),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("id_token inválido: %w", err)
+	}
+
+	// Con varias audiencias el token debe haber sido emitido para este cliente (azp)
+	if audiencias, _ := claims.GetAudience(); len(audiencias) > 1 {
+		if azp, _ := claims["azp"].(string); azp != p.ClientID {
+			return nil, errors.New("id_token inválido: azp no corresponde a este cliente")
+		}
+	}
+	if recibido, _ := claims["nonce"].(string); recibido == "" || recibido != nonce {
+		return nil, errors.New("id_token inválido: nonce no coincide")
+	}
+
+	identidad := &Identidad{Emisor: p.Emisor}
+	identidad.Sujeto, _ = claims["sub"].(string)
+	identidad.Correo, _ = claims["email"].(string)
+	identidad.Nombre, _ = claims["name"].(string)
+	// Algunos proveedores envían email_verified como texto
+	switch verificado := claims["email_verified"].(type) {
+	case bool:
+		identidad.CorreoVerificado = verificado
+	case string:
+		identidad.CorreoVerificado = verificado == "true"
+	}
+	if identidad.Sujeto == "" {
+		return nil, errors.New("id_token inválido: falta sub")
+	}
+	identidad.Correo = strings.ToLower(strings.TrimSpace(identidad.Correo))
+	return identidad, nil
+}
+
+// RequiereSSO indica si el correo pertenece a un dominio que debe iniciar sesión por este proveedor
+func (p *Proveedor) RequiereSSO(correo string) bool {
+	_, dominio, ok := strings.Cut(strings.ToLower(correo), "@")
+	if !ok {
+		return false
+	}
+	for _, permitido := range p.Dominios {
+		if dominio == permitido {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,247 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// proveedorFalso es un servidor OIDC mínimo: descubrimiento, JWKS y token endpoint con PKCE
+type proveedorFalso struct {
+	t        *testing.T
+	servidor *httptest.Server
+	clave    *rsa.PrivateKey
+	kid      string
+
+	mu       sync.Mutex
+	codigos  map[string]codigoFalso // code => datos de la autorización
+	claims   jwt.MapClaims          // Claims extra o reemplazos para el próximo id_token
+	canjeado int
+}
+
+type codigoFalso struct {
+	desafio string
+	nonce   string
+}
+
+func nuevoProveedorFalso(t *testing.T) *proveedorFalso {
+	t.Helper()
+	clave, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	falso := &proveedorFalso{t: t, clave: clave, kid: "clave-1", codigos: map[string]codigoFalso{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Descubrimiento{
+			Issuer:                falso.servidor.URL,
+			AuthorizationEndpoint: falso.servidor.URL + "/autorizar",
+			TokenEndpoint:         falso.servidor.URL + "/token",
+			JwksURI:               falso.servidor.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": falso.kid,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(clave.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(clave.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", falso.token)
+	falso.servidor = httptest.NewServer(mux)
+	t.Cleanup(falso.servidor.Close)
+	return falso
+}
+
+// autorizar simula que el usuario se autenticó: registra el código para el desafío de la URL
+func (f *proveedorFalso) autorizar(direccion string) string {
+	f.t.Helper()
+	destino, err := url.Parse(direccion)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	consulta := destino.Query()
+	if consulta.Get("code_challenge_method") != "S256" || consulta.Get("state") == "" {
+		f.t.Fatalf("URL de autorización sin PKCE o sin state: %s", direccion)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	codigo := "codigo-" + consulta.Get("state")
+	f.codigos[codigo] = codigoFalso{desafio: consulta.Get("code_challenge"), nonce: consulta.Get("nonce")}
+	return codigo
+}
+
+func (f *proveedorFalso) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	codigo, ok := f.codigos[r.PostForm.Get("code")]
+	if !ok || DesafioS256(r.PostForm.Get("code_verifier")) != codigo.desafio {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	delete(f.codigos, r.PostForm.Get("code"))
+	f.canjeado++
+
+	claims := jwt.MapClaims{
+		"iss":            f.servidor.URL,
+		"aud":            "cliente",
+		"sub":            "usuario-123",
+		"email":          "Ana@Ejemplo.com",
+		"email_verified": true,
+		"name":           "Ana",
+		"nonce":          codigo.nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(5 * time.Minute).Unix(),
+	}
+	for clave, valor := range f.claims {
+		claims[clave] = valor
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = f.kid
+	firmado, err := token.SignedString(f.clave)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": firmado, "token_type": "Bearer"})
+}
+
+func (f *proveedorFalso) proveedor(t *testing.T) *Proveedor {
+	t.Helper()
+	proveedor, err := NuevoProveedor(Config{
+		Nombre:      "falso",
+		Emisor:      f.servidor.URL,
+		ClientID:    "cliente",
+		RedirectURL: "http://localhost:8086/api/v1/seguridad/oidc/falso/callback",
+		Scopes:      []string{"email"},
+		Dominios:    []string{"ejemplo.com"},
+	}, f.servidor.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proveedor
+}
+
+// iniciar recorre el inicio del flujo y devuelve el código junto al nonce y verificador que guardaría el servidor
+func iniciar(t *testing.T, falso *proveedorFalso, proveedor *Proveedor) (codigo, nonce, verificador string) {
+	t.Helper()
+	estado, _ := ValorAleatorio()
+	nonce, _ = ValorAleatorio()
+	verificador, _ = ValorAleatorio()
+	direccion, err := proveedor.URLAutorizacion(context.Background(), estado, nonce, verificador)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return falso.autorizar(direccion), nonce, verificador
+}
+
+func TestCanjear(t *testing.T) {
+	falso := nuevoProveedorFalso(t)
+	proveedor := falso.proveedor(t)
+
+	codigo, nonce, verificador := iniciar(t, falso, proveedor)
+	identidad, err := proveedor.Canjear(context.Background(), codigo, verificador, nonce)
+	if err != nil {
+		t.Fatalf("Canjear: %v", err)
+	}
+	esperada := Identidad{Emisor: falso.servidor.URL, Sujeto: "usuario-123", Correo: "ana@ejemplo.com", CorreoVerificado: true, Nombre: "Ana"}
+	if *identidad != esperada {
+		t.Fatalf("identidad = %+v, se esperaba %+v", *identidad, esperada)
+	}
+}
+
+func TestCanjearRechazos(t *testing.T) {
+	casos := []struct {
+		nombre    string
+		claims    jwt.MapClaims
+		verificar func(verificador string) string
+		nonce     func(nonce string) string
+		error     string
+	}{
+		{nombre: "verificador PKCE distinto", verificar: func(string) string { return "otro" }, error: "rechazó el código"},
+		{nombre: "nonce distinto", nonce: func(string) string { return "otro" }, error: "nonce"},
+		{nombre: "otra audiencia", claims: jwt.MapClaims{"aud": "otro-cliente"}, error: "id_token inválido"},
+		{nombre: "otro emisor", claims: jwt.MapClaims{"iss": "https://atacante.example"}, error: "id_token inválido"},
+		{nombre: "expirado", claims: jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}, error: "id_token inválido"},
+		{nombre: "sin sub", claims: jwt.MapClaims{"sub": ""}, error: "sub"},
+	}
+	for _, caso := range casos {
+		t.Run(caso.nombre, func(t *testing.T) {
+			falso := nuevoProveedorFalso(t)
+			falso.claims = caso.claims
+			proveedor := falso.proveedor(t)
+
+			codigo, nonce, verificador := iniciar(t, falso, proveedor)
+			if caso.verificar != nil {
+				verificador = caso.verificar(verificador)
+			}
+			if caso.nonce != nil {
+				nonce = caso.nonce(nonce)
+			}
+			_, err := proveedor.Canjear(context.Background(), codigo, verificador, nonce)
+			if err == nil || !strings.Contains(err.Error(), caso.error) {
+				t.Fatalf("error = %v, se esperaba uno con %q", err, caso.error)
+			}
+		})
+	}
+}
+
+func TestCanjearClaveDesconocida(t *testing.T) {
+	falso := nuevoProveedorFalso(t)
+	proveedor := falso.proveedor(t)
+
+	// Un id_token firmado con otra clave no debe validar aunque use el kid publicado
+	otra, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codigo, nonce, verificador := iniciar(t, falso, proveedor)
+	falso.clave = otra
+	if _, err := proveedor.Canjear(context.Background(), codigo, verificador, nonce); err == nil {
+		t.Fatal("se aceptó un id_token firmado con una clave no publicada")
+	}
+}
+
+func TestDescubrirIssuerDistinto(t *testing.T) {
+	falso := nuevoProveedorFalso(t)
+	proveedor, err := NuevoProveedor(Config{
+		Nombre:      "falso",
+		Emisor:      strings.Replace(falso.servidor.URL, "127.0.0.1", "localhost", 1),
+		ClientID:    "cliente",
+		RedirectURL: "http://localhost/callback",
+	}, falso.servidor.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := proveedor.Descubrir(context.Background()); err == nil {
+		t.Fatal("se aceptó un descubrimiento que declara otro issuer")
+	}
+}
+
+func TestSSOPara(t *testing.T) {
+	falso := nuevoProveedorFalso(t)
+	proveedores := Proveedores{"falso": falso.proveedor(t)}
+	if proveedores.SSOPara("ana@EJEMPLO.com") != proveedores["falso"] {
+		t.Fatal("el dominio configurado no exige SSO")
+	}
+	if proveedores.SSOPara("ana@otro.com") != nil {
+		t.Fatal("un dominio no configurado exige SSO")
+	}
+}
@@ -0,0 +1,22 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// ValorAleatorio genera un valor de 32 bytes en base64url, se usa para state, nonce y el verificador PKCE
+func ValorAleatorio() (string, error) {
+	valor := make([]byte, 32)
+	if _, err := rand.Read(valor); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(valor), nil
+}
+
+// DesafioS256 calcula el code_challenge del verificador PKCE (RFC 7636)
+func DesafioS256(verificador string) string {
+	suma := sha256.Sum256([]byte(verificador))
+	return base64.RawURLEncoding.EncodeToString(suma[:])
+}
@@ -0,0 +1,127 @@
+package rutas
+
+import (
+	"clase_6_echo_mongo/database"
+	"clase_6_echo_mongo/middleware_custom"
+	"clase_6_echo_mongo/modelos"
+	"clase_6_echo_mongo/utilidades"
+	"clase_6_echo_mongo/validaciones"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	echo "github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// generarApiKey devuelve el prefijo público y el secreto de una API key nueva
+func generarApiKey() (string, string, error) {
+	prefijo := make([]byte, 6)
+	if _, err := rand.Read(prefijo); err != nil {
+		return "", "", err
+	}
+	secreto, err := utilidades.GenerarTokenAleatorio(32)
+	if err != nil {
+		return "", "", err
+	}
+	return "ak_" + hex.EncodeToString(prefijo), secreto, nil
+}
+
+// CrearApiKey genera una API key; la clave completa solo se muestra en esta respuesta
+func CrearApiKey(mongoClient *database.MongoDBClient, dbName, apiKeysCollection string) echo.HandlerFunc {
+	apiKeys := database.NewRepository[modelos.ApiKey](mongoClient, dbName, apiKeysCollection)
+	return func(c echo.Context) error {
+		dto := new(modelos.CrearApiKeyDto)
+
+		// Bindear el JSON
+		if err := c.Bind(dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Error al procesar el JSON: " + err.Error()})
+		}
+
+		// Validación de campos
+		if err := validaciones.ValidarCrearApiKey(*dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		prefijo, secreto, err := generarApiKey()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al generar la API key: " + err.Error()})
+		}
+
+		claims, _ := middleware_custom.Claims(c)
+		idUsuario, _ := claims["id"].(string)
+		creadaPor, _ := primitive.ObjectIDFromHex(idUsuario)
+
+		clave := &modelos.ApiKey{
+			Nombre:    dto.Nombre,
+			Prefijo:   prefijo,
+			Hash:      utilidades.HashToken(secreto),
+			Scopes:    dto.Scopes,
+			CreadaPor: creadaPor,
+			Creada:    time.Now(),
+		}
+		if dto.ExpiraDias > 0 {
+			expira := clave.Creada.AddDate(0, 0, dto.ExpiraDias)
+			clave.Expira = &expira
+		}
+
+		clave.ID, err = apiKeys.Insert(context.TODO(), clave)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al guardar en la base de datos: " + err.Error()})
+		}
+
+		return c.JSON(http.StatusCreated, map[string]interface{}{
+			"mensaje": "API key creada, guárdala ahora: no se volverá a mostrar",
+			"estado":  "ok",
+			"api_key": prefijo + "." + secreto,
+			"datos":   clave,
+		})
+	}
+}
+
+func ListarApiKeys(mongoClient *database.MongoDBClient, dbName, apiKeysCollection string) echo.HandlerFunc {
+	apiKeys := database.NewRepository[modelos.ApiKey](mongoClient, dbName, apiKeysCollection)
+	return func(c echo.Context) error {
+		filter := bson.M{}
+		if c.QueryParam("revocadas") != "true" {
+			filter["revocada"] = false
+		}
+
+		claves, err := apiKeys.Find(context.TODO(), filter, options.Find().SetSort(bson.D{{Key: "creada", Value: -1}}))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al listar API keys: " + err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"mensaje": "API keys listadas correctamente",
+			"datos":   claves,
+		})
+	}
+}
+
+func RevocarApiKey(mongoClient *database.MongoDBClient, dbName, apiKeysCollection string) echo.HandlerFunc {
+	apiKeys := database.NewRepository[modelos.ApiKey](mongoClient, dbName, apiKeysCollection)
+	return func(c echo.Context) error {
+		id := c.Param("id")
+		if id == "" || !primitive.IsValidObjectID(id) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "ID inválido o requerido"})
+		}
+
+		if _, err := apiKeys.Update(context.TODO(), id, bson.M{"revocada": true}); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return c.JSON(http.StatusNotFound, map[string]string{"error": "API key no encontrada"})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al revocar la API key: " + err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{
+			"mensaje": "API key revocada correctamente",
+			"estado":  "ok",
+		})
+	}
+}
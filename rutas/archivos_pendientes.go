@@ -0,0 +1,125 @@
+package rutas
+
+import (
+	"clase_6_echo_mongo/almacenamiento"
+	"clase_6_echo_mongo/database"
+	"clase_6_echo_mongo/modelos"
+	"clase_6_echo_mongo/utilidades"
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Espera antes de reintentar el borrado de un archivo; se duplica con cada fallo hasta el máximo
+const (
+	esperaArchivoPendiente       = time.Minute
+	esperaMaximaArchivoPendiente = 6 * time.Hour
+)
+
+// eliminarFotosDeProductos borra los documentos de fotos de los productos y los devuelve para liberar
+// sus archivos una vez confirmada la transacción
+func eliminarFotosDeProductos(ctx mongo.SessionContext, fotos *database.Repository[modelos.FotoProducto], productoIDs []primitive.ObjectID) ([]modelos.FotoProducto, error) {
+	if len(productoIDs) == 0 {
+		return nil, nil
+	}
+	filtro := bson.M{"producto_id": bson.M{"$in": productoIDs}}
+
+	eliminadas, err := fotos.Find(ctx, filtro)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fotos.Collection().DeleteMany(ctx, filtro); err != nil {
+		return nil, err
+	}
+	return eliminadas, nil
+}
+
+// liberarArchivosDeFotos suelta la referencia de cada foto eliminada y borra del almacenamiento los archivos
+// que ya no usa ninguna otra foto. Los que no se pueden borrar quedan en archivos_pendientes para reintentarse.
+// Devuelve cuántos archivos se borraron.
+func liberarArchivosDeFotos(ctx context.Context, archivos *database.Repository[modelos.ReferenciaArchivo], pendientes *database.Repository[modelos.ArchivoPendiente], storage almacenamiento.Storage, eliminadas []modelos.FotoProducto) int {
+	liberados := 0
+	for i := range eliminadas {
+		// Cada foto tiene su propia referencia, aunque comparta el archivo con otras
+		foto := &eliminadas[i]
+		liberado, _, err := liberarArchivos(ctx, archivos, storage, foto)
+		if err != nil {
+			encolarArchivoPendiente(ctx, pendientes, foto, err)
+			continue
+		}
+		if liberado {
+			liberados++
+		}
+	}
+	return liberados
+}
+
+// encolarArchivoPendiente registra el archivo para que ReintentarArchivosPendientes lo borre más tarde
+func encolarArchivoPendiente(ctx context.Context, pendientes *database.Repository[modelos.ArchivoPendiente], foto *modelos.FotoProducto, causa error) {
+	archivo := utilidades.ArchivoSubido{Nombre: foto.Nombre, Variantes: foto.Variantes}
+	_, err := pendientes.Insert(ctx, &modelos.ArchivoPendiente{
+		Nombre:         foto.Nombre,
+		Nombres:        archivo.Nombres(),
+		Intentos:       1,
+		UltimoError:    causa.Error(),
+		ProximoIntento: time.Now().Add(esperaArchivoPendiente),
+		Timestamp:      time.Now().Unix(),
+	})
+	if err != nil {
+		log.Printf("Error al encolar el borrado de %s: %v (causa: %v)", foto.Nombre, err, causa)
+	}
+}
+
+// ReintentarArchivosPendientes borra los archivos pendientes cuyo reintento ya corresponde.
+// Si entretanto se volvió a subir el mismo contenido el archivo se conserva.
+func ReintentarArchivosPendientes(ctx context.Context, mongoClient *database.MongoDBClient, dbName, pendientesCollection, archivosCollection string, storage almacenamiento.Storage) (int, error) {
+	pendientes := database.NewRepository[modelos.ArchivoPendiente](mongoClient, dbName, pendientesCollection)
+	archivos := database.NewRepository[modelos.ReferenciaArchivo](mongoClient, dbName, archivosCollection)
+
+	vencidos, err := pendientes.Find(ctx, bson.M{"proximo_intento": bson.M{"$lte": time.Now()}},
+		options.Find().SetSort(bson.D{{Key: "proximo_intento", Value: 1}}).SetLimit(100))
+	if err != nil {
+		return 0, err
+	}
+
+	borrados := 0
+	for _, pendiente := range vencidos {
+		// Solo se borra si sigue sin referencias y nadie más lo está borrando
+		reclamado, err := utilidades.ReclamarBorrado(ctx, archivos, pendiente.Nombre)
+		if err != nil {
+			return borrados, err
+		}
+		if reclamado {
+			_, errBorrado := utilidades.EliminarArchivo(ctx, storage, pendiente.Nombres...)
+			if errBorrado != nil && errBorrado != almacenamiento.ErrNoExiste {
+				if err := utilidades.TerminarBorrado(ctx, archivos, pendiente.Nombre, false); err != nil {
+					return borrados, err
+				}
+				espera := esperaArchivoPendiente << min(pendiente.Intentos, 16)
+				_, err := pendientes.Update(ctx, pendiente.ID.Hex(), bson.M{
+					"intentos":        pendiente.Intentos + 1,
+					"ultimo_error":    errBorrado.Error(),
+					"proximo_intento": time.Now().Add(min(espera, esperaMaximaArchivoPendiente)),
+				})
+				if err != nil {
+					return borrados, err
+				}
+				continue
+			}
+			if err := utilidades.TerminarBorrado(ctx, archivos, pendiente.Nombre, true); err != nil {
+				return borrados, err
+			}
+			borrados++
+		}
+
+		if _, err := pendientes.Delete(ctx, pendiente.ID.Hex()); err != nil && err != mongo.ErrNoDocuments {
+			return borrados, err
+		}
+	}
+	return borrados, nil
+}
@@ -1,33 +1,30 @@
 package rutas
 
 import (
+	"clase_6_echo_mongo/almacenamiento"
 	"clase_6_echo_mongo/database"
 	"clase_6_echo_mongo/modelos"
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
-	"github.com/gosimple/slug"
 	echo "github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func ListarCategorias(mongoClient *database.MongoDBClient, dbName, collectionName string) echo.HandlerFunc {
+	categorias := database.NewRepository[modelos.Categoria](mongoClient, dbName, collectionName)
 	return func(c echo.Context) error {
 		filter := bson.M{} // Filtro base, ej. de query params
 
-		pipeline := mongo.Pipeline{
-			{{Key: "$match", Value: filter}},
-			{{Key: "$sort", Value: bson.M{
-				"_id": -1,
-			}}},
-		}
-
 		// Listar documentos de la colección
-		documentos, err := mongoClient.ListDocumentos(context.TODO(), dbName, collectionName, pipeline)
+		documentos, err := categorias.Find(context.TODO(), filter, options.Find().SetSort(bson.M{"_id": -1}))
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al listar categorias: " + err.Error()})
 		}
@@ -40,27 +37,15 @@ func ListarCategorias(mongoClient *database.MongoDBClient, dbName, collectionNam
 }
 
 func ListarCategoriaPorId(mongoClient *database.MongoDBClient, dbName, collectionName string) echo.HandlerFunc {
+	categorias := database.NewRepository[modelos.Categoria](mongoClient, dbName, collectionName)
 	return func(c echo.Context) error {
 		id := c.Param("id") // Obtener ID de la URL (:id)
 		if id == "" || !primitive.IsValidObjectID(id) {
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "ID inválido o requerido"})
 		}
 
-		objID, err := primitive.ObjectIDFromHex(id) // Convertir el string a ObjectIds
-		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "ID no es válido: " + err.Error()})
-		}
-
-		filter := bson.M{
-			"_id": objID,
-		} // Filtro base, ej. de query params
-
-		pipeline := mongo.Pipeline{
-			{{Key: "$match", Value: filter}},
-		}
-
-		// Listar documento de la colección
-		documento, err := mongoClient.ListDocumentoPorId(context.TODO(), dbName, collectionName, pipeline)
+		// Buscar documento de la colección
+		documento, err := categorias.FindByID(context.TODO(), id)
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
 				return c.JSON(http.StatusNotFound, map[string]string{"error": "Elemento no encontrado: " + err.Error()})
@@ -68,14 +53,22 @@ func ListarCategoriaPorId(mongoClient *database.MongoDBClient, dbName, collectio
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al buscar categoria: " + err.Error()})
 		}
 
+		// Ruta desde la raíz, ej. Electrónica > Audio > Audífonos
+		migas, err := migasCategoria(context.TODO(), categorias, documento)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al buscar categoria: " + err.Error()})
+		}
+
 		return c.JSON(http.StatusOK, map[string]interface{}{
-			"mensaje": "Categoria encontrada",
-			"datos":   documento,
+			"mensaje":     "Categoria encontrada",
+			"datos":       documento,
+			"breadcrumbs": migas,
 		})
 	}
 }
 
 func CrearCategoria(mongoClient *database.MongoDBClient, dbName, collectionName string) echo.HandlerFunc {
+	categorias := database.NewRepository[modelos.Categoria](mongoClient, dbName, collectionName)
 	return func(c echo.Context) error {
 		categoria := new(modelos.Categoria)
 
@@ -89,16 +82,48 @@ func CrearCategoria(mongoClient *database.MongoDBClient, dbName, collectionName
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Nombre es un campo obligatorio"})
 		}
 
-		// Agregar slug
-		categoria.Slug = slug.Make(categoria.Nombre)
+		// El _id lo asigna MongoDB
+		categoria.ID = primitive.NilObjectID
+
+		// Ubicación en el árbol, sin parent_id queda en la raíz
+		padre, err := buscarPadre(context.TODO(), categorias, categoria.ParentID)
+		if err != nil {
+			if err == errPadreInvalido {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+		if padre == nil {
+			categoria.ParentID = nil
+		}
+		categoria.Ancestros = ancestrosBajo(padre)
 
 		// Agregar timestamp
 		categoria.Timestamp = time.Now().Unix()
+		categoria.SlugsAnteriores = nil
 
-		// Insertar en MongoDB usando BSON
-		err := mongoClient.InsertDocumento(context.TODO(), dbName, collectionName, categoria)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al guardar en la base de datos: " + err.Error()})
+		// Agregar slug único. Si otra petición lo toma antes de insertar, el índice lo rechaza y se busca otro.
+		pedido := categoria.Slug
+		for intento := 1; ; intento++ {
+			categoria.Slug, err = slugCategoria(context.TODO(), categorias, categoria.Nombre, pedido, primitive.NilObjectID)
+			if err != nil {
+				if err == errSlugEnUso {
+					return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+				}
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+			}
+
+			// Insertar en MongoDB
+			categoria.ID, err = categorias.Insert(context.TODO(), categoria)
+			if err == nil {
+				break
+			}
+			if !mongo.IsDuplicateKeyError(err) {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al guardar en la base de datos: " + err.Error()})
+			}
+			if pedido != "" || intento == intentosSlug {
+				return c.JSON(http.StatusConflict, map[string]string{"error": errSlugEnUso.Error()})
+			}
 		}
 
 		return c.JSON(http.StatusCreated, map[string]interface{}{
@@ -109,13 +134,15 @@ func CrearCategoria(mongoClient *database.MongoDBClient, dbName, collectionName
 }
 
 func EditarCategoria(mongoClient *database.MongoDBClient, dbName, collectionName string) echo.HandlerFunc {
+	categorias := database.NewRepository[modelos.Categoria](mongoClient, dbName, collectionName)
 	return func(c echo.Context) error {
 		id := c.Param("id")
 		if id == "" || !primitive.IsValidObjectID(id) {
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "ID inválido o requerido"})
 		}
+		objID, _ := primitive.ObjectIDFromHex(id)
 
-		categoria := new(modelos.Categoria)
+		categoria := new(modelos.EdicionCategoria)
 
 		// Bindear el JSON
 		if err := c.Bind(categoria); err != nil {
@@ -126,19 +153,95 @@ func EditarCategoria(mongoClient *database.MongoDBClient, dbName, collectionName
 		if categoria.Nombre == "" {
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Nombre es un campo obligatorio"})
 		}
+		moverPadre, parentID, err := categoria.Padre()
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
 
 		// Preparar campos para $set (solo los no vacíos)
 		updateFields := bson.M{}
 		if categoria.Nombre != "" {
 			updateFields["nombre"] = strings.TrimSpace(categoria.Nombre)
-			updateFields["slug"] = slug.Make(strings.TrimSpace(categoria.Nombre))
 		}
 
-		// Actualizar en MongoDB
-		result, err := mongoClient.UpdateDocumento(context.TODO(), dbName, collectionName, id, updateFields)
+		var result *mongo.UpdateResult
+		editar := func(ctx context.Context) error {
+			actual, err := categorias.FindOne(ctx, bson.M{"_id": objID})
+			if err != nil {
+				return err
+			}
+
+			// Al cambiar el slug el anterior queda en el historial para redirigir
+			nuevoSlug := actual.Slug
+			if categoria.Slug != "" || !slugDerivadoDe(actual.Slug, strings.TrimSpace(categoria.Nombre)) {
+				nuevoSlug, err = slugCategoria(ctx, categorias, strings.TrimSpace(categoria.Nombre), categoria.Slug, objID)
+				if err != nil {
+					return err
+				}
+			}
+			if nuevoSlug != actual.Slug {
+				updateFields["slug"] = nuevoSlug
+				updateFields["slugs_anteriores"] = slugsAnterioresTrasCambio(actual.Slug, actual.SlugsAnteriores, nuevoSlug)
+			}
+
+			if moverPadre {
+				padre, err := buscarPadre(ctx, categorias, parentID)
+				if err != nil {
+					return err
+				}
+				// El nuevo padre no puede ser la categoría ni estar debajo de ella
+				if padre != nil && (padre.ID == objID || contieneID(padre.Ancestros, objID)) {
+					return errCicloCategoria
+				}
+
+				ancestros := ancestrosBajo(padre)
+				updateFields["ancestros"] = ancestros
+				if padre != nil {
+					updateFields["parent_id"] = padre.ID
+				} else {
+					updateFields["parent_id"] = nil
+				}
+
+				// En los descendientes se reemplaza lo que estaba antes de esta categoría por los nuevos ancestros
+				_, err = categorias.Collection().UpdateMany(ctx, bson.M{"ancestros": objID}, mongo.Pipeline{
+					{{Key: "$set", Value: bson.M{"ancestros": bson.M{"$concatArrays": bson.A{
+						ancestros,
+						bson.M{"$slice": bson.A{
+							"$ancestros",
+							bson.M{"$indexOfArray": bson.A{"$ancestros", objID}},
+							bson.M{"$size": "$ancestros"},
+						}},
+					}}}}},
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			// Actualizar en MongoDB
+			result, err = categorias.Update(ctx, id, updateFields)
+			return err
+		}
+
+		// Al moverla, la categoría y sus descendientes cambian de ancestros juntos en una transacción
+		if moverPadre {
+			err = mongoClient.Transaccion(context.TODO(), func(ctx mongo.SessionContext) error { return editar(ctx) })
+		} else {
+			err = editar(context.TODO())
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			err = errSlugEnUso
+		}
 		if err != nil {
-			if err == mongo.ErrNoDocuments {
+			switch err {
+			case errSlugEnUso:
+				return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+			case mongo.ErrNoDocuments:
 				return c.JSON(http.StatusNotFound, map[string]string{"error": "Elemento no encontrado: " + err.Error()})
+			case errPadreInvalido:
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+			case errCicloCategoria:
+				return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
 			}
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al actualizar categoria: " + err.Error()})
 		}
@@ -150,26 +253,163 @@ func EditarCategoria(mongoClient *database.MongoDBClient, dbName, collectionName
 	}
 }
 
-func EliminarCategoria(mongoClient *database.MongoDBClient, dbName, collectionName string) echo.HandlerFunc {
+// contieneID indica si el ID está en la lista
+func contieneID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, actual := range ids {
+		if actual == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Modos de EliminarCategoria para los productos que aún la referencian (?modo=)
+const (
+	modoRechazar  = "rechazar"  // 409 si hay productos, es el modo por defecto
+	modoReasignar = "reasignar" // Los productos pasan a la categoría ?reasignar_a=<id>
+	modoCascada   = "cascada"   // Los productos se eliminan junto a la categoría
+)
+
+// categoriaEnUso indica que la categoría tiene productos y el modo es rechazar
+type categoriaEnUso struct {
+	productos int64
+}
+
+func (e *categoriaEnUso) Error() string {
+	return fmt.Sprintf("La categoría tiene %d productos asociados, reasígnalos o elimínalos en cascada", e.productos)
+}
+
+// errDestinoInvalido indica que la categoría de ?reasignar_a no existe o es la misma que se elimina
+var errDestinoInvalido = errors.New("La categoría de destino no existe o es la misma que se elimina")
+
+func EliminarCategoria(mongoClient *database.MongoDBClient, dbName, collectionName, productosCollection, fotosCollection, pendientesCollection, archivosCollection string, storage almacenamiento.Storage) echo.HandlerFunc {
+	categorias := database.NewRepository[modelos.Categoria](mongoClient, dbName, collectionName)
+	productos := database.NewRepository[modelos.Producto](mongoClient, dbName, productosCollection)
+	fotos := database.NewRepository[modelos.FotoProducto](mongoClient, dbName, fotosCollection)
+	pendientes := database.NewRepository[modelos.ArchivoPendiente](mongoClient, dbName, pendientesCollection)
+	referencias := database.NewRepository[modelos.ReferenciaArchivo](mongoClient, dbName, archivosCollection)
 	return func(c echo.Context) error {
 		id := c.Param("id")
 		if id == "" || !primitive.IsValidObjectID(id) {
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "ID requerido o inválido"})
 		}
+		objID, _ := primitive.ObjectIDFromHex(id)
 
-		// Eliminar documento de la colección en MongoDB
-		resultado, err := mongoClient.DeleteDocumento(context.TODO(), dbName, collectionName, id)
+		// Con ?reasignar_a el modo puede omitirse
+		modo, reasignarA := c.QueryParam("modo"), c.QueryParam("reasignar_a")
+		if modo == "" && reasignarA != "" {
+			modo = modoReasignar
+		}
+		if modo == "" {
+			modo = modoRechazar
+		}
+
+		var destino primitive.ObjectID
+		switch modo {
+		case modoRechazar, modoCascada:
+		case modoReasignar:
+			var err error
+			if destino, err = primitive.ObjectIDFromHex(reasignarA); err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "El parámetro 'reasignar_a' debe ser un ID válido"})
+			}
+		default:
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "El parámetro 'modo' debe ser rechazar, reasignar o cascada"})
+		}
+
+		// Productos y categoría cambian juntos o no cambia nada
+		var afectados int64
+		var fotosEliminadas []modelos.FotoProducto
+		err := mongoClient.Transaccion(context.TODO(), func(ctx mongo.SessionContext) error {
+			categoria, err := categorias.FindOne(ctx, bson.M{"_id": objID})
+			if err != nil {
+				return err
+			}
+
+			filtroProductos := bson.M{"categoria_id": objID}
+			cantidad, err := productos.Count(ctx, filtroProductos)
+			if err != nil {
+				return err
+			}
+
+			if cantidad > 0 {
+				switch modo {
+				case modoRechazar:
+					return &categoriaEnUso{productos: cantidad}
+				case modoReasignar:
+					if destino == objID {
+						return errDestinoInvalido
+					}
+					if _, err := categorias.FindOne(ctx, bson.M{"_id": destino}); err != nil {
+						if err == mongo.ErrNoDocuments {
+							return errDestinoInvalido
+						}
+						return err
+					}
+					resultado, err := productos.Collection().UpdateMany(ctx, filtroProductos, bson.M{"$set": bson.M{"categoria_id": destino}})
+					if err != nil {
+						return err
+					}
+					afectados = resultado.ModifiedCount
+				case modoCascada:
+					eliminados, err := productos.Find(ctx, filtroProductos, options.Find().SetProjection(bson.M{"_id": 1}))
+					if err != nil {
+						return err
+					}
+					ids := make([]primitive.ObjectID, 0, len(eliminados))
+					for _, producto := range eliminados {
+						ids = append(ids, producto.ID)
+					}
+					if fotosEliminadas, err = eliminarFotosDeProductos(ctx, fotos, ids); err != nil {
+						return err
+					}
+					resultado, err := productos.Collection().DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+					if err != nil {
+						return err
+					}
+					afectados = resultado.DeletedCount
+				}
+			}
+
+			// Las subcategorías suben un nivel: las hijas pasan al padre de la eliminada
+			hijas := bson.M{"$unset": bson.M{"parent_id": ""}}
+			if categoria.ParentID != nil {
+				hijas = bson.M{"$set": bson.M{"parent_id": *categoria.ParentID}}
+			}
+			if _, err := categorias.Collection().UpdateMany(ctx, bson.M{"parent_id": objID}, hijas); err != nil {
+				return err
+			}
+			if _, err := categorias.Collection().UpdateMany(ctx, bson.M{"ancestros": objID}, bson.M{"$pull": bson.M{"ancestros": objID}}); err != nil {
+				return err
+			}
+
+			_, err = categorias.Delete(ctx, id)
+			return err
+		})
 		if err != nil {
-			if err == mongo.ErrNoDocuments {
+			var enUso *categoriaEnUso
+			switch {
+			case errors.As(err, &enUso):
+				return c.JSON(http.StatusConflict, map[string]interface{}{
+					"error":     err.Error(),
+					"productos": enUso.productos,
+				})
+			case errors.Is(err, errDestinoInvalido):
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+			case errors.Is(err, mongo.ErrNoDocuments):
 				return c.JSON(http.StatusNotFound, map[string]string{"error": "Elemento no encontrado: " + err.Error()})
 			}
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al eliminar categoria: " + err.Error()})
 		}
 
+		// Los archivos de las fotos se borran una vez confirmada la transacción
+		liberarArchivosDeFotos(context.TODO(), referencias, pendientes, storage, fotosEliminadas)
+
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"mensaje":   "Categoria eliminada correctamente",
-			"eliminado": resultado.DeletedCount > 0, // Confirma que se eliminó
+			"eliminado": true,
 			"id":        id,
+			"modo":      modo,
+			"productos": afectados, // Reasignados o eliminados según el modo
 		})
 	}
 }
@@ -0,0 +1,124 @@
+package rutas
+
+import (
+	"clase_6_echo_mongo/database"
+	"clase_6_echo_mongo/modelos"
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+
+	echo "github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// errPadreInvalido indica que el parent_id no existe
+var errPadreInvalido = errors.New("La categoría padre no existe")
+
+// errCicloCategoria indica que el nuevo padre es la misma categoría o una de sus descendientes
+var errCicloCategoria = errors.New("Una categoría no puede moverse dentro de sí misma ni de sus subcategorías")
+
+// ancestrosBajo devuelve los ancestros de una categoría hija de padre; nil como padre es la raíz
+func ancestrosBajo(padre *modelos.Categoria) []primitive.ObjectID {
+	if padre == nil {
+		return []primitive.ObjectID{}
+	}
+	return append(append([]primitive.ObjectID{}, padre.Ancestros...), padre.ID)
+}
+
+// buscarPadre carga la categoría padre; un ID nulo o cero corresponde a la raíz
+func buscarPadre(ctx context.Context, categorias *database.Repository[modelos.Categoria], parentID *primitive.ObjectID) (*modelos.Categoria, error) {
+	if parentID == nil || parentID.IsZero() {
+		return nil, nil
+	}
+	padre, err := categorias.FindOne(ctx, bson.M{"_id": *parentID})
+	if err == mongo.ErrNoDocuments {
+		return nil, errPadreInvalido
+	}
+	return padre, err
+}
+
+// idsConDescendientes devuelve la categoría junto a todas sus subcategorías, a cualquier profundidad
+func idsConDescendientes(ctx context.Context, categorias *database.Repository[modelos.Categoria], id primitive.ObjectID) ([]primitive.ObjectID, error) {
+	descendientes, err := categorias.Find(ctx, bson.M{"ancestros": id}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, 0, len(descendientes)+1)
+	ids = append(ids, id)
+	for _, descendiente := range descendientes {
+		ids = append(ids, descendiente.ID)
+	}
+	return ids, nil
+}
+
+// migasCategoria devuelve la ruta desde la raíz hasta la categoría, incluida ella misma
+func migasCategoria(ctx context.Context, categorias *database.Repository[modelos.Categoria], categoria *modelos.Categoria) ([]modelos.MigaCategoria, error) {
+	migas := make([]modelos.MigaCategoria, 0, len(categoria.Ancestros)+1)
+	if len(categoria.Ancestros) > 0 {
+		ancestros, err := categorias.Find(ctx, bson.M{"_id": bson.M{"$in": categoria.Ancestros}})
+		if err != nil {
+			return nil, err
+		}
+		porID := make(map[primitive.ObjectID]modelos.Categoria, len(ancestros))
+		for _, ancestro := range ancestros {
+			porID[ancestro.ID] = ancestro
+		}
+		// Find no respeta el orden de $in, se usa el del arreglo de ancestros
+		for _, id := range categoria.Ancestros {
+			if ancestro, ok := porID[id]; ok {
+				migas = append(migas, modelos.MigaCategoria{ID: ancestro.ID, Nombre: ancestro.Nombre, Slug: ancestro.Slug})
+			}
+		}
+	}
+	return append(migas, modelos.MigaCategoria{ID: categoria.ID, Nombre: categoria.Nombre, Slug: categoria.Slug}), nil
+}
+
+// construirArbol anida las categorías bajo su padre. Las que apuntan a un padre inexistente quedan en la raíz.
+func construirArbol(lista []modelos.Categoria) []*modelos.NodoCategoria {
+	nodos := make(map[primitive.ObjectID]*modelos.NodoCategoria, len(lista))
+	for _, categoria := range lista {
+		nodos[categoria.ID] = &modelos.NodoCategoria{Categoria: categoria, Hijos: []*modelos.NodoCategoria{}}
+	}
+
+	raices := []*modelos.NodoCategoria{}
+	for _, categoria := range lista {
+		nodo := nodos[categoria.ID]
+		if categoria.ParentID != nil {
+			if padre, ok := nodos[*categoria.ParentID]; ok {
+				padre.Hijos = append(padre.Hijos, nodo)
+				continue
+			}
+		}
+		raices = append(raices, nodo)
+	}
+
+	var ordenar func(nodos []*modelos.NodoCategoria)
+	ordenar = func(nodos []*modelos.NodoCategoria) {
+		sort.Slice(nodos, func(i, j int) bool { return nodos[i].Nombre < nodos[j].Nombre })
+		for _, nodo := range nodos {
+			ordenar(nodo.Hijos)
+		}
+	}
+	ordenar(raices)
+	return raices
+}
+
+// ArbolCategorias devuelve todas las categorías anidadas, ordenadas por nombre en cada nivel
+func ArbolCategorias(mongoClient *database.MongoDBClient, dbName, collectionName string) echo.HandlerFunc {
+	categorias := database.NewRepository[modelos.Categoria](mongoClient, dbName, collectionName)
+	return func(c echo.Context) error {
+		documentos, err := categorias.Find(context.TODO(), bson.M{})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al listar categorias: " + err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"mensaje": "Árbol de categorías generado correctamente",
+			"datos":   construirArbol(documentos),
+		})
+	}
+}
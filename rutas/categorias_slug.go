@@ -0,0 +1,194 @@
+package rutas
+
+import (
+	"clase_6_echo_mongo/database"
+	"clase_6_echo_mongo/modelos"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gosimple/slug"
+	echo "github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Intentos de insertar con el siguiente sufijo cuando otra petición tomó el slug entre la consulta y la escritura
+const intentosSlug = 3
+
+// errSlugEnUso indica que el slug pedido explícitamente ya pertenece a otra categoría
+var errSlugEnUso = errors.New("El slug ya está en uso por otra categoría")
+
+// slugEnUso indica si el slug es el actual o uno anterior de una categoría distinta a excluir
+func slugEnUso(ctx context.Context, categorias *database.Repository[modelos.Categoria], candidato string, excluir primitive.ObjectID) (bool, error) {
+	filtro := bson.M{"$or": []bson.M{{"slug": candidato}, {"slugs_anteriores": candidato}}}
+	if !excluir.IsZero() {
+		filtro["_id"] = bson.M{"$ne": excluir}
+	}
+	cantidad, err := categorias.Count(ctx, filtro)
+	return cantidad > 0, err
+}
+
+// slugCategoria resuelve el slug de una categoría. Uno pedido explícitamente debe estar libre (errSlugEnUso);
+// uno derivado del nombre se desambigua con un sufijo: audio, audio-2, audio-3...
+func slugCategoria(ctx context.Context, categorias *database.Repository[modelos.Categoria], nombre, pedido string, excluir primitive.ObjectID) (string, error) {
+	if pedido != "" {
+		candidato := slug.Make(pedido)
+		enUso, err := slugEnUso(ctx, categorias, candidato, excluir)
+		if err != nil {
+			return "", err
+		}
+		if enUso {
+			return "", errSlugEnUso
+		}
+		return candidato, nil
+	}
+
+	return primerSlugLibre(slug.Make(nombre), func(candidato string) (bool, error) {
+		return slugEnUso(ctx, categorias, candidato, excluir)
+	})
+}
+
+// primerSlugLibre prueba base, base-2, base-3... hasta encontrar uno que no esté en uso
+func primerSlugLibre(base string, enUso func(candidato string) (bool, error)) (string, error) {
+	for i := 1; ; i++ {
+		candidato := base
+		if i > 1 {
+			candidato = fmt.Sprintf("%s-%d", base, i)
+		}
+		ocupado, err := enUso(candidato)
+		if err != nil {
+			return "", err
+		}
+		if !ocupado {
+			return candidato, nil
+		}
+	}
+}
+
+// slugDerivadoDe indica si el slug actual ya corresponde al nombre, con o sin sufijo de desambiguación.
+// Así editar una categoría sin renombrarla no le cambia el slug.
+func slugDerivadoDe(actual, nombre string) bool {
+	base := slug.Make(nombre)
+	if actual == base {
+		return true
+	}
+	sufijo, ok := strings.CutPrefix(actual, base+"-")
+	if !ok || sufijo == "" {
+		return false
+	}
+	_, err := strconv.Atoi(sufijo)
+	return err == nil
+}
+
+// slugsAnterioresTrasCambio agrega el slug que se deja al historial y quita el nuevo si estaba en él.
+// Lo usan categorías y productos.
+func slugsAnterioresTrasCambio(actual string, historial []string, nuevo string) []string {
+	anteriores := []string{}
+	for _, anterior := range historial {
+		if anterior != nuevo && anterior != actual {
+			anteriores = append(anteriores, anterior)
+		}
+	}
+	if actual != "" && actual != nuevo {
+		anteriores = append(anteriores, actual)
+	}
+	return anteriores
+}
+
+// AsegurarSlugsCategorias aplica el índice único de slug de config.Indices. Antes desambigua los slugs repetidos de categorías
+// anteriores al índice: conserva el slug la más antigua y las demás reciben un sufijo.
+func AsegurarSlugsCategorias(ctx context.Context, mongoClient *database.MongoDBClient, dbName, collectionName string) error {
+	categorias := database.NewRepository[modelos.Categoria](mongoClient, dbName, collectionName)
+
+	repetidos, err := database.Aggregate[struct {
+		IDs []primitive.ObjectID `bson:"ids"`
+	}](ctx, categorias.Collection(), mongo.Pipeline{
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		{{Key: "$group", Value: bson.M{"_id": "$slug", "ids": bson.M{"$push": "$_id"}, "total": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"total": bson.M{"$gt": 1}}}},
+	})
+	if err != nil {
+		return err
+	}
+	for _, grupo := range repetidos {
+		for _, id := range grupo.IDs[1:] {
+			categoria, err := categorias.FindOne(ctx, bson.M{"_id": id})
+			if err != nil {
+				return err
+			}
+			nuevo, err := slugCategoria(ctx, categorias, categoria.Nombre, "", id)
+			if err != nil {
+				return err
+			}
+			if _, err := categorias.Update(ctx, id.Hex(), bson.M{"slug": nuevo}); err != nil {
+				return err
+			}
+			log.Printf("Slug repetido '%s' de la categoría %s cambiado a '%s'", categoria.Slug, id.Hex(), nuevo)
+		}
+	}
+
+	// Sin repetidos ya se puede crear el índice único que Connect no pudo crear
+	return asegurarIndicesUnicos(ctx, mongoClient, dbName, collectionName, "slug_unico")
+}
+
+// asegurarIndicesUnicos vuelve a aplicar los índices declarados de la colección. Connect ya reportó las demás
+// diferencias; las de los índices únicos indicados son un error porque sin ellos no se garantiza la unicidad.
+func asegurarIndicesUnicos(ctx context.Context, mongoClient *database.MongoDBClient, dbName, collectionName string, unicos ...string) error {
+	diferencias, err := mongoClient.AplicarIndices(ctx, dbName, collectionName)
+	if err != nil {
+		return err
+	}
+	for _, diferencia := range diferencias {
+		for _, unico := range unicos {
+			if diferencia.Indice == unico {
+				return fmt.Errorf("índice único fuera de la especificación: %s", diferencia)
+			}
+		}
+	}
+	return nil
+}
+
+// ListarCategoriaPorSlug busca la categoría por su slug. Un slug anterior redirige al actual.
+func ListarCategoriaPorSlug(mongoClient *database.MongoDBClient, dbName, collectionName string) echo.HandlerFunc {
+	categorias := database.NewRepository[modelos.Categoria](mongoClient, dbName, collectionName)
+	return func(c echo.Context) error {
+		buscado := strings.ToLower(strings.TrimSpace(c.Param("slug")))
+		if buscado == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Slug requerido"})
+		}
+
+		documento, err := categorias.FindOne(context.TODO(), bson.M{"slug": buscado})
+		if err == mongo.ErrNoDocuments {
+			// La categoría pudo haber sido renombrada
+			renombrada, err := categorias.FindOne(context.TODO(), bson.M{"slugs_anteriores": buscado})
+			if err != nil {
+				if err == mongo.ErrNoDocuments {
+					return c.JSON(http.StatusNotFound, map[string]string{"error": "Elemento no encontrado: " + err.Error()})
+				}
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al buscar categoria: " + err.Error()})
+			}
+			return c.Redirect(http.StatusMovedPermanently, path.Join(path.Dir(c.Request().URL.Path), renombrada.Slug))
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al buscar categoria: " + err.Error()})
+		}
+
+		migas, err := migasCategoria(context.TODO(), categorias, documento)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al buscar categoria: " + err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"mensaje":     "Categoria encontrada",
+			"datos":       documento,
+			"breadcrumbs": migas,
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package rutas
+
+import (
+	"clase_6_echo_mongo/database"
+	"clase_6_echo_mongo/modelos"
+	"clase_6_echo_mongo/utilidades"
+	"clase_6_echo_mongo/validaciones"
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	echo "github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Claves con las que se guardan los contadores de cada cuenta y de cada IP
+func claveCuenta(correo string) string {
+	return "correo:" + strings.ToLower(strings.TrimSpace(correo))
+}
+
+func claveIP(ip string) string {
+	return "ip:" + ip
+}
+
+// bloqueoRestante devuelve cuánto falta para que se levante el bloqueo más largo entre las claves indicadas
+func bloqueoRestante(ctx context.Context, intentos *database.Repository[modelos.IntentoLogin], claves ...string) (time.Duration, error) {
+	ahora := time.Now()
+	bloqueados, err := intentos.Find(ctx, bson.M{"clave": bson.M{"$in": claves}, "bloqueado_hasta": bson.M{"$gt": ahora}})
+	if err != nil {
+		return 0, err
+	}
+
+	var restante time.Duration
+	for _, intento := range bloqueados {
+		if r := intento.BloqueadoHasta.Sub(ahora); r > restante {
+			restante = r
+		}
+	}
+	return restante, nil
+}
+
+// registrarFallo suma un fallo a la clave y la bloquea si alcanzó el máximo.
+// El incremento se hace en una sola operación para que varias réplicas no pierdan fallos;
+// si pasó la ventana desde el último fallo o bloqueo el contador parte de nuevo.
+func registrarFallo(ctx context.Context, intentos *database.Repository[modelos.IntentoLogin], limites utilidades.LimitesLogin, clave string, maximo int) error {
+	ahora := time.Now()
+	actualizacion := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"clave": clave,
+			"fallos": bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{bson.M{"$max": bson.A{"$ultimo_fallo", "$bloqueado_hasta"}}, ahora.Add(-limites.Ventana)}},
+				bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$fallos", 0}}, 1}},
+				1,
+			}},
+			"ultimo_fallo": ahora,
+		}}},
+	}
+	opciones := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	intento := new(modelos.IntentoLogin)
+	if err := intentos.Collection().FindOneAndUpdate(ctx, bson.M{"clave": clave}, actualizacion, opciones).Decode(intento); err != nil {
+		return err
+	}
+
+	bloqueo := limites.DuracionBloqueo(intento.Fallos, maximo)
+	if bloqueo == 0 {
+		return nil
+	}
+	_, err := intentos.Collection().UpdateOne(ctx, bson.M{"clave": clave}, bson.M{"$max": bson.M{"bloqueado_hasta": ahora.Add(bloqueo)}})
+	return err
+}
+
+// limpiarFallos borra el contador de la clave, ej. después de un login correcto
+func limpiarFallos(ctx context.Context, intentos *database.Repository[modelos.IntentoLogin], clave string) error {
+	_, err := intentos.Collection().DeleteOne(ctx, bson.M{"clave": clave})
+	return err
+}
+
+// responderBloqueo arma la respuesta 429 con la cabecera Retry-After
+func responderBloqueo(c echo.Context, restante time.Duration) error {
+	segundos := int(math.Ceil(restante.Seconds()))
+	c.Response().Header().Set("Retry-After", strconv.Itoa(segundos))
+	return c.JSON(http.StatusTooManyRequests, map[string]interface{}{
+		"error":     "Demasiados intentos fallidos, intenta nuevamente más tarde",
+		"reintento": segundos,
+	})
+}
+
+func ListarBloqueosLogin(mongoClient *database.MongoDBClient, dbName, intentosCollection string) echo.HandlerFunc {
+	intentos := database.NewRepository[modelos.IntentoLogin](mongoClient, dbName, intentosCollection)
+	return func(c echo.Context) error {
+		bloqueados, err := intentos.Find(context.TODO(), bson.M{"bloqueado_hasta": bson.M{"$gt": time.Now()}},
+			options.Find().SetSort(bson.D{{Key: "bloqueado_hasta", Value: -1}}))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al listar bloqueos: " + err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"mensaje":  "Bloqueos vigentes",
+			"bloqueos": bloqueados,
+			"cantidad": len(bloqueados),
+		})
+	}
+}
+
+// DesbloquearLogin permite a un administrador levantar el bloqueo de una cuenta y/o una IP
+func DesbloquearLogin(mongoClient *database.MongoDBClient, dbName, intentosCollection string) echo.HandlerFunc {
+	intentos := database.NewRepository[modelos.IntentoLogin](mongoClient, dbName, intentosCollection)
+	return func(c echo.Context) error {
+		dto := new(modelos.DesbloqueoDto)
+
+		// Bindear el JSON
+		if err := c.Bind(dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Error al procesar el JSON: " + err.Error()})
+		}
+
+		// Validación de campos
+		if err := validaciones.ValidarDesbloqueo(*dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		var claves []string
+		if dto.Correo != "" {
+			claves = append(claves, claveCuenta(dto.Correo))
+		}
+		if dto.IP != "" {
+			claves = append(claves, claveIP(dto.IP))
+		}
+
+		resultado, err := intentos.Collection().DeleteMany(context.TODO(), bson.M{"clave": bson.M{"$in": claves}})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al desbloquear: " + err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"mensaje":       "Desbloqueo aplicado correctamente",
+			"estado":        "ok",
+			"desbloqueados": resultado.DeletedCount,
+		})
+	}
+}
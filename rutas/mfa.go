@@ -0,0 +1,377 @@
+package rutas
+
+import (
+	"bytes"
+	"clase_6_echo_mongo/database"
+	"clase_6_echo_mongo/jwt"
+	"clase_6_echo_mongo/middleware_custom"
+	"clase_6_echo_mongo/modelos"
+	"clase_6_echo_mongo/utilidades"
+	"clase_6_echo_mongo/validaciones"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"image/png"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	echo "github.com/labstack/echo/v4"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Parámetros TOTP compatibles con Google Authenticator y similares
+var opcionesTOTP = totp.ValidateOpts{
+	Period:    30,
+	Skew:      1,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// Cantidad de códigos de recuperación que se entregan al activar el segundo factor
+const cantidadCodigosRecuperacion = 10
+
+// usuarioDelToken busca el usuario identificado por el claim "id" del token validado.
+// Las cuentas eliminadas no se encuentran.
+func usuarioDelToken(c echo.Context, usuarios *database.Repository[modelos.UsuarioDto]) (*modelos.UsuarioDto, error) {
+	claims, _ := middleware_custom.Claims(c)
+	id, _ := claims["id"].(string)
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	return usuarios.FindOne(context.TODO(), bson.M{"_id": objID, "eliminado_en": bson.M{"$exists": false}})
+}
+
+// pasoTOTP devuelve el periodo en que el código es válido, tolerando un periodo de desfase de reloj
+func pasoTOTP(secreto, codigo string, ahora time.Time) (int64, bool) {
+	codigo = strings.TrimSpace(codigo)
+	for desfase := -int(opcionesTOTP.Skew); desfase <= int(opcionesTOTP.Skew); desfase++ {
+		momento := ahora.Add(time.Duration(desfase) * time.Duration(opcionesTOTP.Period) * time.Second)
+		esperado, err := totp.GenerateCodeCustom(secreto, momento, opcionesTOTP)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(esperado), []byte(codigo)) == 1 {
+			return momento.Unix() / int64(opcionesTOTP.Period), true
+		}
+	}
+	return 0, false
+}
+
+// consumirTOTP acepta el código solo si pertenece a un periodo posterior al último usado,
+// así un código interceptado no se puede repetir dentro de su ventana de validez
+func consumirTOTP(ctx context.Context, usuarios *database.Repository[modelos.UsuarioDto], usuario *modelos.UsuarioDto, codigo string) (bool, error) {
+	paso, ok := pasoTOTP(usuario.MFA.Secreto, codigo, time.Now())
+	if !ok {
+		return false, nil
+	}
+	resultado, err := usuarios.Collection().UpdateOne(ctx,
+		bson.M{"_id": usuario.ID, "mfa.activo": true, "mfa.ultimo_paso": bson.M{"$not": bson.M{"$gte": paso}}},
+		bson.M{"$set": bson.M{"mfa.ultimo_paso": paso}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return resultado.MatchedCount == 1, nil
+}
+
+// normalizarCodigoRecuperacion permite ingresar el código con o sin guion y en mayúsculas
+func normalizarCodigoRecuperacion(codigo string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(codigo)), "-", "")
+}
+
+// consumirCodigoRecuperacion elimina el código de la lista del usuario; solo funciona una vez
+func consumirCodigoRecuperacion(ctx context.Context, usuarios *database.Repository[modelos.UsuarioDto], usuario *modelos.UsuarioDto, codigo string) (bool, error) {
+	hash := utilidades.HashToken(normalizarCodigoRecuperacion(codigo))
+	resultado, err := usuarios.Collection().UpdateOne(ctx,
+		bson.M{"_id": usuario.ID, "mfa.activo": true, "mfa.codigos_recuperacion": hash},
+		bson.M{"$pull": bson.M{"mfa.codigos_recuperacion": hash}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return resultado.ModifiedCount == 1, nil
+}
+
+// generarCodigosRecuperacion devuelve los códigos para el usuario y sus hash para guardar
+func generarCodigosRecuperacion() ([]string, []string, error) {
+	codigos := make([]string, 0, cantidadCodigosRecuperacion)
+	hashes := make([]string, 0, cantidadCodigosRecuperacion)
+	for i := 0; i < cantidadCodigosRecuperacion; i++ {
+		aleatorio := make([]byte, 5)
+		if _, err := rand.Read(aleatorio); err != nil {
+			return nil, nil, err
+		}
+		codigo := hex.EncodeToString(aleatorio)
+		codigos = append(codigos, codigo[:5]+"-"+codigo[5:])
+		hashes = append(hashes, utilidades.HashToken(codigo))
+	}
+	return codigos, hashes, nil
+}
+
+// InscribirMFA genera un secreto TOTP pendiente de confirmación y lo entrega como URI otpauth:// y código QR
+func InscribirMFA(mongoClient *database.MongoDBClient, dbName, usuariosCollection string) echo.HandlerFunc {
+	usuarios := database.NewRepository[modelos.UsuarioDto](mongoClient, dbName, usuariosCollection)
+	return func(c echo.Context) error {
+		usuario, err := usuarioDelToken(c, usuarios)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Token inválido"})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+		if usuario.TieneMFA() {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "El segundo factor ya está activo"})
+		}
+
+		emisor := os.Getenv("MFA_EMISOR")
+		if emisor == "" {
+			emisor = "clase_6_echo_mongo"
+		}
+		clave, err := totp.Generate(totp.GenerateOpts{
+			Issuer:      emisor,
+			AccountName: usuario.Correo,
+			Period:      opcionesTOTP.Period,
+			Digits:      opcionesTOTP.Digits,
+			Algorithm:   opcionesTOTP.Algorithm,
+		})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al generar el secreto: " + err.Error()})
+		}
+
+		imagen, err := clave.Image(256, 256)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al generar el código QR: " + err.Error()})
+		}
+		var qr bytes.Buffer
+		if err := png.Encode(&qr, imagen); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al generar el código QR: " + err.Error()})
+		}
+
+		if _, err := usuarios.Update(context.TODO(), usuario.ID.Hex(), bson.M{"mfa.secreto_pendiente": clave.Secret()}); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al guardar el secreto: " + err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{
+			"mensaje":     "Escanea el código QR y confirma con un código de tu aplicación",
+			"estado":      "ok",
+			"secreto":     clave.Secret(),
+			"otpauth_uri": clave.URL(),
+			"qr_png":      "data:image/png;base64," + base64.StdEncoding.EncodeToString(qr.Bytes()),
+		})
+	}
+}
+
+// ConfirmarMFA activa el secreto pendiente si el código es correcto y entrega los códigos de recuperación
+func ConfirmarMFA(mongoClient *database.MongoDBClient, dbName, usuariosCollection string) echo.HandlerFunc {
+	usuarios := database.NewRepository[modelos.UsuarioDto](mongoClient, dbName, usuariosCollection)
+	return func(c echo.Context) error {
+		dto := new(modelos.CodigoMFADto)
+
+		// Bindear el JSON
+		if err := c.Bind(dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Error al procesar el JSON: " + err.Error()})
+		}
+
+		// Validación de campos
+		if err := validaciones.ValidarCodigoMFA(*dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		usuario, err := usuarioDelToken(c, usuarios)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Token inválido"})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+		if usuario.TieneMFA() {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "El segundo factor ya está activo"})
+		}
+		if usuario.MFA == nil || usuario.MFA.SecretoPendiente == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "No hay una inscripción de segundo factor pendiente"})
+		}
+
+		paso, ok := pasoTOTP(usuario.MFA.SecretoPendiente, dto.Codigo, time.Now())
+		if !ok {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "El código ingresado es inválido"})
+		}
+
+		codigos, hashes, err := generarCodigosRecuperacion()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al generar los códigos de recuperación: " + err.Error()})
+		}
+
+		// Se exige el mismo secreto pendiente por si hubo una nueva inscripción entre medio
+		resultado, err := usuarios.Collection().UpdateOne(context.TODO(),
+			bson.M{"_id": usuario.ID, "mfa.secreto_pendiente": usuario.MFA.SecretoPendiente},
+			bson.M{"$set": bson.M{"mfa": modelos.MFAUsuario{
+				Activo:              true,
+				Secreto:             usuario.MFA.SecretoPendiente,
+				UltimoPaso:          paso,
+				CodigosRecuperacion: hashes,
+			}}},
+		)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al activar el segundo factor: " + err.Error()})
+		}
+		if resultado.MatchedCount == 0 {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "La inscripción cambió, vuelve a iniciarla"})
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"mensaje":              "Segundo factor activado, guarda los códigos de recuperación en un lugar seguro",
+			"estado":               "ok",
+			"codigos_recuperacion": codigos,
+		})
+	}
+}
+
+// RegenerarCodigosRecuperacion reemplaza los códigos de recuperación, previa confirmación con un código TOTP
+func RegenerarCodigosRecuperacion(mongoClient *database.MongoDBClient, dbName, usuariosCollection string) echo.HandlerFunc {
+	usuarios := database.NewRepository[modelos.UsuarioDto](mongoClient, dbName, usuariosCollection)
+	return func(c echo.Context) error {
+		dto := new(modelos.CodigoMFADto)
+
+		// Bindear el JSON
+		if err := c.Bind(dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Error al procesar el JSON: " + err.Error()})
+		}
+
+		// Validación de campos
+		if err := validaciones.ValidarCodigoMFA(*dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		usuario, err := usuarioDelToken(c, usuarios)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Token inválido"})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+		if !usuario.TieneMFA() {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "El segundo factor no está activo"})
+		}
+
+		valido, err := consumirTOTP(context.TODO(), usuarios, usuario, dto.Codigo)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+		if !valido {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "El código ingresado es inválido"})
+		}
+
+		codigos, hashes, err := generarCodigosRecuperacion()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al generar los códigos de recuperación: " + err.Error()})
+		}
+		if _, err := usuarios.Update(context.TODO(), usuario.ID.Hex(), bson.M{"mfa.codigos_recuperacion": hashes}); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al guardar los códigos de recuperación: " + err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"mensaje":              "Códigos de recuperación regenerados, los anteriores dejan de funcionar",
+			"estado":               "ok",
+			"codigos_recuperacion": codigos,
+		})
+	}
+}
+
+// LoginMFA es el segundo paso del login: canjea el token pendiente y un código TOTP
+// (o de recuperación) por la sesión definitiva
+func LoginMFA(mongoClient *database.MongoDBClient, dbName, usuariosCollection, refreshCollection, revocadosCollection, intentosCollection string, limites utilidades.LimitesLogin, tokens *jwt.Servicio) echo.HandlerFunc {
+	usuarios := database.NewRepository[modelos.UsuarioDto](mongoClient, dbName, usuariosCollection)
+	refreshTokens := database.NewRepository[modelos.RefreshToken](mongoClient, dbName, refreshCollection)
+	revocados := database.NewRepository[modelos.TokenRevocado](mongoClient, dbName, revocadosCollection)
+	intentos := database.NewRepository[modelos.IntentoLogin](mongoClient, dbName, intentosCollection)
+	return func(c echo.Context) error {
+		dto := new(modelos.LoginMFADto)
+
+		// Bindear el JSON
+		if err := c.Bind(dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Error al procesar el JSON: " + err.Error()})
+		}
+
+		// Validación de campos
+		if err := validaciones.ValidarLoginMFA(*dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		claims, err := tokens.ValidarJWTPendienteMFA(dto.MFAToken)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "El token de segundo factor es inválido o expiró"})
+		}
+		id, _ := claims["id"].(string)
+		jti, _ := claims["jti"].(string)
+
+		// El token pendiente es de un solo uso
+		usado, err := revocados.Count(context.TODO(), bson.M{"jti": jti})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+		if usado > 0 {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "El token de segundo factor es inválido o expiró"})
+		}
+
+		// Los códigos de 6 dígitos se limitan igual que las contraseñas
+		clave := "mfa:" + id
+		restante, err := bloqueoRestante(context.TODO(), intentos, clave, claveIP(c.RealIP()))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+		if restante > 0 {
+			return responderBloqueo(c, restante)
+		}
+
+		usuario, err := usuarios.FindByID(context.TODO(), id)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "El token de segundo factor es inválido o expiró"})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+		if !usuario.TieneMFA() || !usuario.EstaHabilitado() {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "El token de segundo factor es inválido o expiró"})
+		}
+
+		valido, err := consumirTOTP(context.TODO(), usuarios, usuario, dto.Codigo)
+		if err == nil && !valido {
+			valido, err = consumirCodigoRecuperacion(context.TODO(), usuarios, usuario, dto.Codigo)
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+		if !valido {
+			if err := registrarFallo(context.TODO(), intentos, limites, clave, limites.MaxFallosCuenta); err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al registrar el intento: " + err.Error()})
+			}
+			if err := registrarFallo(context.TODO(), intentos, limites, claveIP(c.RealIP()), limites.MaxFallosIP); err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al registrar el intento: " + err.Error()})
+			}
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "El código ingresado es inválido"})
+		}
+
+		if err := limpiarFallos(context.TODO(), intentos, clave); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al registrar el intento: " + err.Error()})
+		}
+
+		if err := revocarJti(context.TODO(), revocados, claims, jwt.DuracionMFAPendiente); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al revocar el token: " + err.Error()})
+		}
+
+		retorno, err := emitirSesion(context.TODO(), tokens, refreshTokens, usuario, "", true)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al intentar generar el token: " + err.Error()})
+		}
+		return c.JSON(http.StatusOK, retorno)
+	}
+}
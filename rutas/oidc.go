@@ -0,0 +1,265 @@
+package rutas
+
+import (
+	"clase_6_echo_mongo/database"
+	"clase_6_echo_mongo/jwt"
+	"clase_6_echo_mongo/modelos"
+	"clase_6_echo_mongo/oidc"
+	"clase_6_echo_mongo/utilidades"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	echo "github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Tiempo que tiene el usuario para autenticarse en el proveedor y volver al callback
+const duracionEstadoOIDC = 10 * time.Minute
+
+// cookieEstadoOIDC guarda el state en el navegador que inició el login. Sin ella un atacante podría
+// hacer que la víctima complete el login del atacante (login CSRF).
+const cookieEstadoOIDC = "oidc_estado"
+
+var (
+	// errCorreoNoVerificado indica que la identidad externa no trae un correo verificado con que vincularla
+	errCorreoNoVerificado = errors.New("El proveedor no confirmó tu correo, no se puede vincular la cuenta")
+	// errCuentaSinVerificar evita que quien registró el correo de otro con una contraseña se quede con
+	// la cuenta cuando el verdadero dueño entra por SSO
+	errCuentaSinVerificar = errors.New("Ya existe una cuenta con este correo sin verificar, verifícala antes de vincularla")
+)
+
+// cookieEstado arma la cookie del state, limitada a la ruta del callback. Con duracion negativa la elimina.
+func cookieEstado(proveedor *oidc.Proveedor, valor string, duracion time.Duration) *http.Cookie {
+	ruta := "/"
+	if callback, err := url.Parse(proveedor.RedirectURL); err == nil && callback.Path != "" {
+		ruta = callback.Path
+	}
+	return &http.Cookie{
+		Name:     cookieEstadoOIDC,
+		Value:    valor,
+		Path:     ruta,
+		MaxAge:   int(duracion.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(proveedor.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode, // El proveedor vuelve con una navegación GET desde otro sitio
+	}
+}
+
+// respuestaSSOObligatorio rechaza un correo de un dominio que debe entrar por otro proveedor
+func respuestaSSOObligatorio(c echo.Context, proveedores oidc.Proveedores, actual *oidc.Proveedor, correo string) (bool, error) {
+	sso := proveedores.SSOPara(correo)
+	if sso == nil || sso == actual {
+		return false, nil
+	}
+	return true, c.JSON(http.StatusForbidden, map[string]string{
+		"error":     "Las cuentas de este dominio inician sesión con SSO",
+		"proveedor": sso.Nombre,
+	})
+}
+
+// IniciarOIDC redirige al proveedor para iniciar sesión con authorization code + PKCE
+func IniciarOIDC(mongoClient *database.MongoDBClient, dbName, estadosCollection string, proveedores oidc.Proveedores) echo.HandlerFunc {
+	estados := database.NewRepository[modelos.EstadoOIDC](mongoClient, dbName, estadosCollection)
+	return func(c echo.Context) error {
+		proveedor, ok := proveedores[c.Param("proveedor")]
+		if !ok {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Proveedor de identidad no configurado"})
+		}
+
+		var valores [3]string
+		for i := range valores {
+			valor, err := oidc.ValorAleatorio()
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al iniciar el login: " + err.Error()})
+			}
+			valores[i] = valor
+		}
+		estado, nonce, verificador := valores[0], valores[1], valores[2]
+
+		direccion, err := proveedor.URLAutorizacion(context.TODO(), estado, nonce, verificador)
+		if err != nil {
+			return c.JSON(http.StatusBadGateway, map[string]string{"error": "Error al contactar al proveedor de identidad: " + err.Error()})
+		}
+
+		// Los estados sin usar se eliminan en el siguiente inicio
+		if _, err := estados.Collection().DeleteMany(context.TODO(), bson.M{"expira": bson.M{"$lt": time.Now()}}); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+		_, err = estados.Insert(context.TODO(), &modelos.EstadoOIDC{
+			Hash:        utilidades.HashToken(estado),
+			Proveedor:   proveedor.Nombre,
+			Verificador: verificador,
+			Nonce:       nonce,
+			Expira:      time.Now().Add(duracionEstadoOIDC),
+		})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al guardar en la base de datos: " + err.Error()})
+		}
+
+		c.SetCookie(cookieEstado(proveedor, estado, duracionEstadoOIDC))
+		return c.Redirect(http.StatusFound, direccion)
+	}
+}
+
+// CallbackOIDC recibe al usuario de vuelta del proveedor, canjea el código y entrega la sesión.
+// La identidad externa se vincula al usuario con el mismo correo o se crea uno nuevo.
+func CallbackOIDC(mongoClient *database.MongoDBClient, dbName, usuariosCollection, refreshCollection, estadosCollection string, proveedores oidc.Proveedores, tokens *jwt.Servicio) echo.HandlerFunc {
+	usuarios := database.NewRepository[modelos.UsuarioDto](mongoClient, dbName, usuariosCollection)
+	refreshTokens := database.NewRepository[modelos.RefreshToken](mongoClient, dbName, refreshCollection)
+	estados := database.NewRepository[modelos.EstadoOIDC](mongoClient, dbName, estadosCollection)
+	return func(c echo.Context) error {
+		proveedor, ok := proveedores[c.Param("proveedor")]
+		if !ok {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Proveedor de identidad no configurado"})
+		}
+
+		// El proveedor informa aquí si el usuario canceló o hubo un error
+		if errorProveedor := c.QueryParam("error"); errorProveedor != "" {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "El proveedor de identidad rechazó el login: " + errorProveedor})
+		}
+		codigo, estado := c.QueryParam("code"), c.QueryParam("state")
+		if codigo == "" || estado == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Faltan los parámetros code y state"})
+		}
+
+		// El state debe ser el que se entregó a este navegador al iniciar el login
+		cookie, err := c.Cookie(cookieEstadoOIDC)
+		c.SetCookie(cookieEstado(proveedor, "", -1))
+		if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(estado)) != 1 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "El login no se inició en este navegador, vuelve a intentarlo"})
+		}
+
+		// El state es de un solo uso: se elimina al leerlo
+		var pendiente modelos.EstadoOIDC
+		err = estados.Collection().FindOneAndDelete(context.TODO(), bson.M{
+			"hash":      utilidades.HashToken(estado),
+			"proveedor": proveedor.Nombre,
+		}).Decode(&pendiente)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "El login es inválido o ya fue usado, vuelve a intentarlo"})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+		if time.Now().After(pendiente.Expira) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "El login expiró, vuelve a intentarlo"})
+		}
+
+		identidad, err := proveedor.Canjear(context.TODO(), codigo, pendiente.Verificador, pendiente.Nonce)
+		if err != nil {
+			log.Printf("Error en el login con %s: %v", proveedor.Nombre, err)
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "No se pudo validar la identidad con el proveedor"})
+		}
+
+		// Un dominio con SSO obligatorio no puede entrar ni vincularse por otro proveedor
+		if rechazado, err := respuestaSSOObligatorio(c, proveedores, proveedor, identidad.Correo); rechazado {
+			return err
+		}
+
+		usuario, err := usuarioDeIdentidad(context.TODO(), usuarios, proveedor, identidad)
+		if err != nil {
+			if err == errCorreoNoVerificado || err == errCuentaSinVerificar {
+				return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al vincular la cuenta: " + err.Error()})
+		}
+
+		// Una cuenta eliminada se restaura al iniciar sesión dentro del periodo de gracia
+		if usuario.EliminadoEn != nil {
+			if time.Since(*usuario.EliminadoEn) > periodoGraciaCuenta() {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "La cuenta fue eliminada"})
+			}
+			_, err := usuarios.Collection().UpdateOne(context.TODO(), bson.M{"_id": usuario.ID}, bson.M{"$unset": bson.M{"eliminado_en": ""}})
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al restaurar la cuenta: " + err.Error()})
+			}
+			usuario.EliminadoEn = nil
+		}
+		if !usuario.EstaHabilitado() {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "La cuenta está deshabilitada, contacta a un administrador"})
+		}
+		// La identidad pudo vincularse antes de que el dominio de la cuenta exigiera otro proveedor
+		if rechazado, err := respuestaSSOObligatorio(c, proveedores, proveedor, usuario.Correo); rechazado {
+			return err
+		}
+
+		return responderLogin(c, tokens, refreshTokens, usuario)
+	}
+}
+
+// usuarioDeIdentidad busca al usuario vinculado a la identidad externa. Si no existe se vincula
+// al usuario con el mismo correo, siempre que ambos lo hayan verificado, o se crea uno nuevo.
+func usuarioDeIdentidad(ctx context.Context, usuarios *database.Repository[modelos.UsuarioDto], proveedor *oidc.Proveedor, identidad *oidc.Identidad) (*modelos.UsuarioDto, error) {
+	usuario, err := usuarios.FindOne(ctx, bson.M{"identidades": bson.M{"$elemMatch": bson.M{
+		"emisor": identidad.Emisor,
+		"sujeto": identidad.Sujeto,
+	}}})
+	if err == nil {
+		return usuario, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	// Sin correo verificado no se puede saber a quién pertenece la identidad
+	if identidad.Correo == "" || !identidad.CorreoVerificado {
+		return nil, errCorreoNoVerificado
+	}
+
+	vinculo := modelos.IdentidadExterna{
+		Proveedor: proveedor.Nombre,
+		Emisor:    identidad.Emisor,
+		Sujeto:    identidad.Sujeto,
+		Vinculada: time.Now(),
+	}
+
+	usuario, err = usuarios.FindOne(ctx, bson.M{"correo": identidad.Correo})
+	if err == nil {
+		// Una cuenta sin verificar pudo registrarla cualquiera; su contraseña seguiría sirviendo después de vincularla
+		if !usuario.EstaVerificado() {
+			return nil, errCuentaSinVerificar
+		}
+		resultado, err := usuarios.Collection().UpdateOne(ctx,
+			bson.M{"_id": usuario.ID, "verificado": bson.M{"$ne": false}},
+			bson.M{"$push": bson.M{"identidades": vinculo}},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if resultado.MatchedCount == 0 {
+			return nil, errCuentaSinVerificar
+		}
+		usuario.Identidades = append(usuario.Identidades, vinculo)
+		return usuario, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	// Primer login: la cuenta se crea sin contraseña y con el rol de solo lectura
+	nombre := identidad.Nombre
+	if nombre == "" {
+		nombre = identidad.Correo
+	}
+	verificado := true
+	usuario = &modelos.UsuarioDto{
+		Nombre:      nombre,
+		Correo:      identidad.Correo,
+		Rol:         modelos.RolViewer,
+		Verificado:  &verificado,
+		Identidades: []modelos.IdentidadExterna{vinculo},
+		Timestamp:   time.Now().Unix(),
+	}
+	usuario.ID, err = usuarios.Insert(ctx, usuario)
+	if err != nil {
+		return nil, err
+	}
+	return usuario, nil
+}
@@ -0,0 +1,284 @@
+package rutas
+
+import (
+	"clase_6_echo_mongo/correo"
+	"clase_6_echo_mongo/database"
+	"clase_6_echo_mongo/jwt"
+	"clase_6_echo_mongo/middleware_custom"
+	"clase_6_echo_mongo/modelos"
+	"clase_6_echo_mongo/validaciones"
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	echo "github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// periodoGraciaCuenta es el tiempo en que una cuenta eliminada puede recuperarse iniciando sesión
+// (CUENTA_PERIODO_GRACIA, por defecto 30 días); pasado ese plazo se purga
+func periodoGraciaCuenta() time.Duration {
+	if duracion, err := time.ParseDuration(os.Getenv("CUENTA_PERIODO_GRACIA")); err == nil && duracion > 0 {
+		return duracion
+	}
+	return 30 * 24 * time.Hour
+}
+
+// passwordCorrecta compara la contraseña ingresada con el hash guardado
+func passwordCorrecta(usuario *modelos.UsuarioDto, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(usuario.Password), []byte(password)) == nil
+}
+
+// respuestaUsuarioDelToken traduce el error de usuarioDelToken a la respuesta HTTP
+func respuestaUsuarioDelToken(c echo.Context, err error) error {
+	if err == mongo.ErrNoDocuments {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Token inválido"})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+}
+
+func ObtenerPerfil(mongoClient *database.MongoDBClient, dbName, usuariosCollection string) echo.HandlerFunc {
+	usuarios := database.NewRepository[modelos.UsuarioDto](mongoClient, dbName, usuariosCollection)
+	return func(c echo.Context) error {
+		usuario, err := usuarioDelToken(c, usuarios)
+		if err != nil {
+			return respuestaUsuarioDelToken(c, err)
+		}
+
+		return c.JSON(http.StatusOK, modelos.PerfilRespuestaDto{
+			Nombre:          usuario.Nombre,
+			Correo:          usuario.Correo,
+			CorreoPendiente: usuario.CorreoPendiente,
+			Telefono:        usuario.Telefono,
+			Rol:             usuario.RolEfectivo(),
+			Verificado:      usuario.EstaVerificado(),
+			MFA:             usuario.TieneMFA(),
+			Timestamp:       usuario.Timestamp,
+		})
+	}
+}
+
+func EditarPerfil(mongoClient *database.MongoDBClient, dbName, usuariosCollection string) echo.HandlerFunc {
+	usuarios := database.NewRepository[modelos.UsuarioDto](mongoClient, dbName, usuariosCollection)
+	return func(c echo.Context) error {
+		dto := new(modelos.PerfilDto)
+
+		// Bindear el JSON
+		if err := c.Bind(dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Error al procesar el JSON: " + err.Error()})
+		}
+
+		// Validación de campos
+		if err := validaciones.ValidarPerfil(*dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		usuario, err := usuarioDelToken(c, usuarios)
+		if err != nil {
+			return respuestaUsuarioDelToken(c, err)
+		}
+
+		if _, err := usuarios.Update(context.TODO(), usuario.ID.Hex(), bson.M{"nombre": dto.Nombre, "telefono": dto.Telefono}); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al actualizar el perfil: " + err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{
+			"mensaje": "Perfil actualizado correctamente",
+			"estado":  "ok",
+		})
+	}
+}
+
+func CambiarPassword(mongoClient *database.MongoDBClient, dbName, usuariosCollection, refreshCollection string) echo.HandlerFunc {
+	usuarios := database.NewRepository[modelos.UsuarioDto](mongoClient, dbName, usuariosCollection)
+	refreshTokens := database.NewRepository[modelos.RefreshToken](mongoClient, dbName, refreshCollection)
+	return func(c echo.Context) error {
+		dto := new(modelos.CambioPasswordDto)
+
+		// Bindear el JSON
+		if err := c.Bind(dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Error al procesar el JSON: " + err.Error()})
+		}
+
+		// Validación de campos, la nueva contraseña sigue las mismas reglas que en el registro
+		if err := validaciones.ValidarCambioPassword(*dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		usuario, err := usuarioDelToken(c, usuarios)
+		if err != nil {
+			return respuestaUsuarioDelToken(c, err)
+		}
+		if !passwordCorrecta(usuario, dto.PasswordActual) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "La contraseña actual es incorrecta"})
+		}
+
+		password, err := hashPassword(dto.PasswordNueva)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al procesar la contraseña: " + err.Error()})
+		}
+		if _, err := usuarios.Update(context.TODO(), usuario.ID.Hex(), bson.M{"password": password}); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al actualizar la contraseña: " + err.Error()})
+		}
+
+		// Las sesiones abiertas con la contraseña anterior dejan de valer, incluidos sus tokens de acceso
+		if err := invalidarTokensUsuario(context.TODO(), usuarios, refreshTokens, usuario.ID); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al revocar las sesiones: " + err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{
+			"mensaje": "Contraseña actualizada correctamente, todas las sesiones, incluida esta, deberán iniciar sesión de nuevo",
+			"estado":  "ok",
+		})
+	}
+}
+
+// CambiarCorreo deja el correo nuevo pendiente y envía la verificación a esa dirección.
+// El correo actual sigue vigente hasta que se abra el enlace.
+func CambiarCorreo(mongoClient *database.MongoDBClient, dbName, usuariosCollection, verificacionesCollection string, mailer correo.Mailer) echo.HandlerFunc {
+	usuarios := database.NewRepository[modelos.UsuarioDto](mongoClient, dbName, usuariosCollection)
+	verificaciones := database.NewRepository[modelos.VerificacionCorreo](mongoClient, dbName, verificacionesCollection)
+	return func(c echo.Context) error {
+		dto := new(modelos.CambioCorreoDto)
+
+		// Bindear el JSON
+		if err := c.Bind(dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Error al procesar el JSON: " + err.Error()})
+		}
+
+		// Validación de campos
+		if err := validaciones.ValidarCambioCorreo(*dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		usuario, err := usuarioDelToken(c, usuarios)
+		if err != nil {
+			return respuestaUsuarioDelToken(c, err)
+		}
+		if !passwordCorrecta(usuario, dto.Password) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "La contraseña es incorrecta"})
+		}
+		if dto.Correo == usuario.Correo {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "El correo nuevo es igual al actual"})
+		}
+
+		existentes, err := usuarios.Count(context.TODO(), bson.M{"correo": dto.Correo})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+		if existentes > 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "El correo ya está registrado"})
+		}
+
+		if _, err := usuarios.Update(context.TODO(), usuario.ID.Hex(), bson.M{"correo_pendiente": dto.Correo}); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al actualizar el usuario: " + err.Error()})
+		}
+
+		if err := enviarVerificacion(context.TODO(), verificaciones, mailer, usuario, dto.Correo); err != nil {
+			log.Printf("Error al enviar la verificación a %s: %v", dto.Correo, err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "No se pudo enviar el correo de verificación"})
+		}
+
+		// Aviso al correo actual, por si el cambio no lo pidió el titular
+		aviso := correo.Mensaje{
+			Para:   usuario.Correo,
+			Asunto: "Solicitud de cambio de correo",
+			Cuerpo: "Hola " + usuario.Nombre + ",\n\n" +
+				"Se solicitó cambiar el correo de tu cuenta a " + dto.Correo + ".\n" +
+				"Si no fuiste tú, cambia tu contraseña de inmediato.",
+		}
+		if err := mailer.Enviar(context.TODO(), aviso); err != nil {
+			log.Printf("Error al avisar el cambio de correo a %s: %v", usuario.Correo, err)
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{
+			"mensaje": "Revisa el correo nuevo para confirmar el cambio",
+			"estado":  "ok",
+		})
+	}
+}
+
+// EliminarCuenta marca la cuenta como eliminada y cierra todas sus sesiones.
+// Iniciar sesión dentro del periodo de gracia la restaura; después se purga.
+func EliminarCuenta(mongoClient *database.MongoDBClient, dbName, usuariosCollection, refreshCollection, revocadosCollection string) echo.HandlerFunc {
+	usuarios := database.NewRepository[modelos.UsuarioDto](mongoClient, dbName, usuariosCollection)
+	refreshTokens := database.NewRepository[modelos.RefreshToken](mongoClient, dbName, refreshCollection)
+	revocados := database.NewRepository[modelos.TokenRevocado](mongoClient, dbName, revocadosCollection)
+	return func(c echo.Context) error {
+		dto := new(modelos.ConfirmarPasswordDto)
+
+		// Bindear el JSON
+		if err := c.Bind(dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Error al procesar el JSON: " + err.Error()})
+		}
+
+		// Validación de campos
+		if err := validaciones.ValidarConfirmarPassword(*dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		usuario, err := usuarioDelToken(c, usuarios)
+		if err != nil {
+			return respuestaUsuarioDelToken(c, err)
+		}
+		if !passwordCorrecta(usuario, dto.Password) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "La contraseña es incorrecta"})
+		}
+
+		ahora := time.Now()
+		if _, err := usuarios.Update(context.TODO(), usuario.ID.Hex(), bson.M{"eliminado_en": ahora}); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al eliminar la cuenta: " + err.Error()})
+		}
+
+		_, err = refreshTokens.Collection().UpdateMany(context.TODO(), bson.M{"usuario_id": usuario.ID}, bson.M{"$set": bson.M{"revocado": true}})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al revocar las sesiones: " + err.Error()})
+		}
+		claims, _ := middleware_custom.Claims(c)
+		if err := revocarJti(context.TODO(), revocados, claims, jwt.DuracionAcceso()); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al revocar el token: " + err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{
+			"mensaje":           "Cuenta eliminada, puedes restaurarla iniciando sesión antes de la fecha indicada",
+			"estado":            "ok",
+			"restaurable_hasta": ahora.Add(periodoGraciaCuenta()).Format(time.RFC3339),
+		})
+	}
+}
+
+// PurgarCuentasEliminadas borra definitivamente las cuentas cuyo periodo de gracia terminó,
+// junto con sus documentos en las colecciones relacionadas (campo usuario_id)
+func PurgarCuentasEliminadas(ctx context.Context, mongoClient *database.MongoDBClient, dbName, usuariosCollection string, relacionadas ...string) (int, error) {
+	usuarios := database.NewRepository[modelos.UsuarioDto](mongoClient, dbName, usuariosCollection)
+
+	vencida := bson.M{"eliminado_en": bson.M{"$lte": time.Now().Add(-periodoGraciaCuenta())}}
+	vencidas, err := usuarios.Find(ctx, vencida)
+	if err != nil {
+		return 0, err
+	}
+
+	purgadas := 0
+	for _, usuario := range vencidas {
+		// Primero se borra el usuario, volviendo a exigir que siga vencido: si se restauró mientras tanto
+		// conserva sus sesiones y tokens. Si luego falla, lo relacionado queda sin usuario y ya no autoriza nada.
+		resultado, err := usuarios.Collection().DeleteOne(ctx, bson.M{"_id": usuario.ID, "eliminado_en": vencida["eliminado_en"]})
+		if err != nil {
+			return purgadas, err
+		}
+		if resultado.DeletedCount == 0 {
+			continue
+		}
+		purgadas++
+		for _, coleccion := range relacionadas {
+			if _, err := mongoClient.GetCollection(dbName, coleccion).DeleteMany(ctx, bson.M{"usuario_id": usuario.ID}); err != nil {
+				return purgadas, err
+			}
+		}
+	}
+	return purgadas, nil
+}
@@ -1,63 +1,186 @@
 package rutas
 
 import (
+	"clase_6_echo_mongo/almacenamiento"
 	"clase_6_echo_mongo/database"
+	"clase_6_echo_mongo/middleware_custom"
 	"clase_6_echo_mongo/modelos"
+	"clase_6_echo_mongo/utilidades"
 	"clase_6_echo_mongo/validaciones"
 	"context"
+	"errors"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt"
 	echo "github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// Campos por los que se permite ordenar con ?sort=
+var ordenProductosPermitido = map[string]bool{
+	"nombre":    true,
+	"precio":    true,
+	"stock":     true,
+	"timestamp": true,
+}
+
+// errParametroProductos marca los errores de filtroProductos causados por los query params
+type errParametroProductos struct{ error }
+
+// filtroProductos traduce los query params de búsqueda a un filtro de MongoDB
+func filtroProductos(ctx context.Context, c echo.Context, categorias *database.Repository[modelos.Categoria]) (bson.M, error) {
+	filter := bson.M{}
+
+	// La categoría incluye sus subcategorías salvo con ?subcategorias=false
+	if categoriaID := c.QueryParam("categoria_id"); categoriaID != "" {
+		objID, err := primitive.ObjectIDFromHex(categoriaID)
+		if err != nil {
+			return nil, errParametroProductos{errors.New("el parámetro 'categoria_id' no es un ID válido")}
+		}
+		if c.QueryParam("subcategorias") == "false" {
+			filter["categoria_id"] = objID
+		} else {
+			ids, err := idsConDescendientes(ctx, categorias, objID)
+			if err != nil {
+				return nil, err
+			}
+			filter["categoria_id"] = bson.M{"$in": ids}
+		}
+	}
+
+	precio := bson.M{}
+	if valor := c.QueryParam("precio_min"); valor != "" {
+		precioMin, err := strconv.Atoi(valor)
+		if err != nil {
+			return nil, errParametroProductos{errors.New("el parámetro 'precio_min' debe ser numérico")}
+		}
+		precio["$gte"] = precioMin
+	}
+	if valor := c.QueryParam("precio_max"); valor != "" {
+		precioMax, err := strconv.Atoi(valor)
+		if err != nil {
+			return nil, errParametroProductos{errors.New("el parámetro 'precio_max' debe ser numérico")}
+		}
+		precio["$lte"] = precioMax
+	}
+	if len(precio) > 0 {
+		filter["precio"] = precio
+	}
+
+	if valor := c.QueryParam("stock_gt"); valor != "" {
+		stockGt, err := strconv.Atoi(valor)
+		if err != nil {
+			return nil, errParametroProductos{errors.New("el parámetro 'stock_gt' debe ser numérico")}
+		}
+		filter["stock"] = bson.M{"$gt": stockGt}
+	}
+
+	if nombre := strings.TrimSpace(c.QueryParam("nombre")); nombre != "" {
+		// Búsqueda por prefijo, sin distinguir mayúsculas
+		filter["nombre"] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(nombre), Options: "i"}
+	}
+
+	return filter, nil
+}
+
+// etapaLookupCategoria une cada producto con su categoría
+func etapaLookupCategoria(categoriasCollection string) bson.D {
+	return bson.D{{Key: "$lookup", Value: bson.M{
+		"from":         categoriasCollection,
+		"localField":   "categoria_id",
+		"foreignField": "_id",
+		"as":           "categoria", // Nombre de la relación
+	}}}
+}
+
 func ListarProductos(mongoClient *database.MongoDBClient, dbName, productosCollection, categoriasCollection string) echo.HandlerFunc {
+	productos := database.NewRepository[modelos.Producto](mongoClient, dbName, productosCollection)
+	categorias := database.NewRepository[modelos.Categoria](mongoClient, dbName, categoriasCollection)
 	return func(c echo.Context) error {
-		filter := bson.M{} // Filtro base, ej. de query params
+		filter, err := filtroProductos(context.TODO(), c, categorias)
+		if err != nil {
+			if errors.As(err, new(errParametroProductos)) {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+
+		paginacion, err := utilidades.LeerPaginacion(c.QueryParam("page"), c.QueryParam("limit"), c.QueryParam("after"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		orden, err := utilidades.LeerOrden(c.QueryParam("sort"), ordenProductosPermitido, []utilidades.CampoOrden{{Campo: "_id", Desc: true}})
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		// El total se calcula sin el cursor, sobre todos los productos que cumplen el filtro
+		total, err := productos.Count(context.TODO(), filter)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al contar productos: " + err.Error()})
+		}
+
+		match := filter
+		if paginacion.Cursor != nil {
+			filtroCursor, err := utilidades.FiltroCursor(orden, paginacion.Cursor)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+			}
+			match = bson.M{"$and": []bson.M{filter, filtroCursor}}
+		}
 
 		pipeline := mongo.Pipeline{
-			{{Key: "$match", Value: filter}},
-			{{Key: "$lookup", Value: bson.M{
-				"from":         categoriasCollection,
-				"localField":   "categoria_id",
-				"foreignField": "_id",
-				"as":           "categoria", // Nombre de la relación
-			}}},
-			{{Key: "$project", Value: bson.D{
-				{Key: "categoria_id", Value: 0},
-			}}},
-			{{Key: "$sort", Value: bson.M{
-				"_id": -1,
-			}}},
+			{{Key: "$match", Value: match}},
+			{{Key: "$sort", Value: utilidades.EtapaSort(orden)}},
+			{{Key: "$skip", Value: paginacion.Skip()}},
+			{{Key: "$limit", Value: paginacion.Limite + 1}}, // Uno extra para saber si hay más páginas
+			etapaLookupCategoria(categoriasCollection),
 			// {{Key: "$unwind", Value: "$categoria"}},
 		}
 
 		// Listar documentos de la colección
-		documentos, err := mongoClient.ListDocumentos(context.TODO(), dbName, productosCollection, pipeline)
+		documentos, err := database.Aggregate[modelos.ProductoDetalle](context.TODO(), productos.Collection(), pipeline)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al listar categorias: " + err.Error()})
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al listar productos: " + err.Error()})
+		}
+
+		var nextCursor interface{}
+		if int64(len(documentos)) > paginacion.Limite {
+			documentos = documentos[:paginacion.Limite]
+			cursor, err := utilidades.CodificarCursor(orden, documentos[len(documentos)-1].Producto)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al generar el cursor: " + err.Error()})
+			}
+			nextCursor = cursor
 		}
 
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"mensaje": "Productos listados correctamente",
 			"datos":   documentos,
+			"meta": map[string]interface{}{
+				"total":       total,
+				"page":        paginacion.Pagina,
+				"limit":       paginacion.Limite,
+				"next_cursor": nextCursor,
+			},
 		})
 	}
 }
 
 func ListarProductoPorId(mongoClient *database.MongoDBClient, dbName, productosCollection, categoriasCollection string) echo.HandlerFunc {
+	productos := database.NewRepository[modelos.ProductoDetalle](mongoClient, dbName, productosCollection)
 	return func(c echo.Context) error {
 		// Con esto obtenemos los valores entregados por el token validado, como el nombre de usuario y otras cosas
-		user := c.Get("user").(*jwt.Token)
-		claims := user.Claims.(jwt.MapClaims)
+		claims, _ := middleware_custom.Claims(c)
 
-		idUsuario := claims["id"].(string)
-		nombreUsuario := claims["nombre"].(string)
+		idUsuario, _ := claims["id"].(string)
+		nombreUsuario, _ := claims["nombre"].(string)
 		// fin de la obtención de valores, esto lo usaré en el return de producto al final de la función
 
 		id := c.Param("id") // Obtener ID de la URL (:id)
@@ -70,36 +193,18 @@ func ListarProductoPorId(mongoClient *database.MongoDBClient, dbName, productosC
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "ID no es válido: " + err.Error()})
 		}
 
-		filter := bson.M{
-			"_id": objID,
-		} // Filtro base, ej. de query params
-
-		pipeline := mongo.Pipeline{
-			{{Key: "$match", Value: filter}},
-			{{Key: "$lookup", Value: bson.M{
-				"from":         categoriasCollection,
-				"localField":   "categoria_id",
-				"foreignField": "_id",
-				"as":           "categoria", // Nombre de la relación
-			}}},
-			{{Key: "$project", Value: bson.D{
-				{Key: "categoria_id", Value: 0},
-			}}},
-			// {{Key: "$unwind", Value: "$categoria"}}, // Separa un array en diferentes bloques individuales
-		}
-
-		// Listar documentos de la colección
-		documentos, err := mongoClient.ListDocumentoPorId(context.TODO(), dbName, productosCollection, pipeline)
+		// Buscar el producto junto a su categoría
+		documento, err := buscarProductoDetalle(context.TODO(), productos, categoriasCollection, bson.M{"_id": objID})
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
 				return c.JSON(http.StatusNotFound, map[string]string{"error": "Elemento no encontrado: " + err.Error()})
 			}
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al listar categorias: " + err.Error()})
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al buscar producto: " + err.Error()})
 		}
 
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"mensaje":   "Producto encontrado",
-			"datos":     documentos,
+			"datos":     documento,
 			"usuario":   "Hola " + nombreUsuario,
 			"idUsuario": idUsuario,
 		})
@@ -107,6 +212,7 @@ func ListarProductoPorId(mongoClient *database.MongoDBClient, dbName, productosC
 }
 
 func CrearProducto(mongoClient *database.MongoDBClient, dbName, collectionName string) echo.HandlerFunc {
+	productos := database.NewRepository[modelos.Producto](mongoClient, dbName, collectionName)
 	return func(c echo.Context) error {
 		producto := new(modelos.Producto)
 
@@ -120,22 +226,40 @@ func CrearProducto(mongoClient *database.MongoDBClient, dbName, collectionName s
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 		}
 
-		// Crear Map
-		categoriaID, _ := primitive.ObjectIDFromHex(producto.CategoriaID)
+		// El _id lo asigna MongoDB
+		producto.ID = primitive.NilObjectID
 		producto.Timestamp = time.Now().Unix()
-		documentoProducto := bson.M{
-			"nombre":       producto.Nombre,
-			"precio":       producto.Precio,
-			"stock":        producto.Stock,
-			"descripcion":  producto.Descripcion,
-			"categoria_id": categoriaID,
-			"timestamp":    producto.Timestamp,
+
+		// El sku es único
+		if producto.Sku != "" {
+			enUso, err := skuEnUso(context.TODO(), productos, producto.Sku, primitive.NilObjectID)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+			}
+			if enUso {
+				return c.JSON(http.StatusConflict, map[string]string{"error": errSkuEnUso.Error()})
+			}
 		}
 
-		// Insertar en MongoDB usando BSON
-		err := mongoClient.InsertDocumento(context.TODO(), dbName, collectionName, documentoProducto)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al guardar en la base de datos: " + err.Error()})
+		// Agregar slug único. Si otra petición lo toma antes de insertar, el índice lo rechaza y se busca otro.
+		for intento := 1; ; intento++ {
+			var err error
+			producto.Slug, err = slugProducto(context.TODO(), productos, producto.Nombre, primitive.NilObjectID)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+			}
+
+			// Insertar en MongoDB
+			producto.ID, err = productos.Insert(context.TODO(), producto)
+			if err == nil {
+				break
+			}
+			if duplicadoEnIndice(err, indiceSkuProducto) {
+				return c.JSON(http.StatusConflict, map[string]string{"error": errSkuEnUso.Error()})
+			}
+			if !duplicadoEnIndice(err, indiceSlugProducto) || intento == intentosSlug {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al guardar en la base de datos: " + err.Error()})
+			}
 		}
 
 		return c.JSON(http.StatusCreated, map[string]interface{}{
@@ -146,6 +270,7 @@ func CrearProducto(mongoClient *database.MongoDBClient, dbName, collectionName s
 }
 
 func EditarProducto(mongoClient *database.MongoDBClient, dbName, collectionName string) echo.HandlerFunc {
+	productos := database.NewRepository[modelos.Producto](mongoClient, dbName, collectionName)
 	return func(c echo.Context) error {
 		id := c.Param("id")
 		if id == "" || !primitive.IsValidObjectID(id) {
@@ -160,10 +285,22 @@ func EditarProducto(mongoClient *database.MongoDBClient, dbName, collectionName
 		}
 
 		// Validación de al menos un campo
-		if producto.Nombre == "" && producto.Precio == 0 && producto.Stock == 0 && producto.Descripcion == "" && producto.CategoriaID.IsZero() {
+		if producto.Nombre == "" && producto.Precio == 0 && producto.Stock == 0 && producto.Descripcion == "" && producto.CategoriaID.IsZero() && producto.Sku == nil {
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Debe proporcionar al menos un campo para actualizar"})
 		}
 
+		// "sku": "" quita el código de barras en lugar de validarlo
+		quitarSku := producto.Sku != nil && strings.TrimSpace(*producto.Sku) == ""
+		if quitarSku {
+			producto.Sku = nil
+		}
+
+		// Validación de campos
+		if err := validaciones.ValidarUpdateProducto(*producto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		objID, _ := primitive.ObjectIDFromHex(id)
+
 		// Preparar campos para $set (solo los no vacíos)
 		updateFields := bson.M{}
 		if producto.Nombre != "" {
@@ -181,13 +318,59 @@ func EditarProducto(mongoClient *database.MongoDBClient, dbName, collectionName
 		if !producto.CategoriaID.IsZero() {
 			updateFields["categoria_id"] = producto.CategoriaID
 		}
+		if producto.Sku != nil {
+			enUso, err := skuEnUso(context.TODO(), productos, *producto.Sku, objID)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+			}
+			if enUso {
+				return c.JSON(http.StatusConflict, map[string]string{"error": errSkuEnUso.Error()})
+			}
+			updateFields["sku"] = *producto.Sku
+		}
+
+		// Al renombrar cambia el slug, salvo que ya corresponda al nombre nuevo; el anterior queda en el
+		// historial para redirigir
+		if nombre, ok := updateFields["nombre"].(string); ok {
+			actual, err := productos.FindByID(context.TODO(), id)
+			if err != nil {
+				if err == mongo.ErrNoDocuments {
+					return c.JSON(http.StatusNotFound, map[string]string{"error": "Elemento no encontrado: " + err.Error()})
+				}
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+			}
+			if !slugDerivadoDe(actual.Slug, nombre) {
+				nuevoSlug, err := slugProducto(context.TODO(), productos, nombre, objID)
+				if err != nil {
+					return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+				}
+				updateFields["slug"] = nuevoSlug
+				updateFields["slugs_anteriores"] = slugsAnterioresTrasCambio(actual.Slug, actual.SlugsAnteriores, nuevoSlug)
+			}
+		}
 
 		// Actualizar en MongoDB
-		result, err := mongoClient.UpdateDocumento(context.TODO(), dbName, collectionName, id, updateFields)
+		update := bson.M{}
+		if len(updateFields) > 0 {
+			update["$set"] = updateFields
+		}
+		if quitarSku {
+			update["$unset"] = bson.M{"sku": ""}
+		}
+		result, err := productos.Collection().UpdateOne(context.TODO(), bson.M{"_id": objID}, update)
+		if err == nil && result.MatchedCount == 0 {
+			err = mongo.ErrNoDocuments
+		}
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
 				return c.JSON(http.StatusNotFound, map[string]string{"error": "Elemento no encontrado: " + err.Error()})
 			}
+			if duplicadoEnIndice(err, indiceSkuProducto) {
+				return c.JSON(http.StatusConflict, map[string]string{"error": errSkuEnUso.Error()})
+			}
+			if duplicadoEnIndice(err, indiceSlugProducto) {
+				return c.JSON(http.StatusConflict, map[string]string{"error": "El slug generado ya está en uso, vuelve a intentarlo"})
+			}
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al actualizar categoria: " + err.Error()})
 		}
 
@@ -198,26 +381,45 @@ func EditarProducto(mongoClient *database.MongoDBClient, dbName, collectionName
 	}
 }
 
-func EliminarProducto(mongoClient *database.MongoDBClient, dbName, collectionName string) echo.HandlerFunc {
+// EliminarProducto elimina el producto junto a sus fotos. Los documentos se borran en una transacción
+// y los archivos después de confirmarla; los que fallen se reintentan en segundo plano.
+func EliminarProducto(mongoClient *database.MongoDBClient, dbName, collectionName, fotosCollection, pendientesCollection, archivosCollection string, storage almacenamiento.Storage) echo.HandlerFunc {
+	productos := database.NewRepository[modelos.Producto](mongoClient, dbName, collectionName)
+	fotos := database.NewRepository[modelos.FotoProducto](mongoClient, dbName, fotosCollection)
+	pendientes := database.NewRepository[modelos.ArchivoPendiente](mongoClient, dbName, pendientesCollection)
+	referencias := database.NewRepository[modelos.ReferenciaArchivo](mongoClient, dbName, archivosCollection)
 	return func(c echo.Context) error {
 		id := c.Param("id")
 		if id == "" || !primitive.IsValidObjectID(id) {
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "ID requerido o inválido"})
 		}
+		objID, _ := primitive.ObjectIDFromHex(id)
 
-		// Eliminar documento de la colección en MongoDB
-		resultado, err := mongoClient.DeleteDocumento(context.TODO(), dbName, collectionName, id)
+		// Eliminar el producto y sus fotos en MongoDB
+		var eliminadas []modelos.FotoProducto
+		err := mongoClient.Transaccion(context.TODO(), func(ctx mongo.SessionContext) error {
+			if _, err := productos.Delete(ctx, id); err != nil {
+				return err
+			}
+			var err error
+			eliminadas, err = eliminarFotosDeProductos(ctx, fotos, []primitive.ObjectID{objID})
+			return err
+		})
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
 				return c.JSON(http.StatusNotFound, map[string]string{"error": "Elemento no encontrado: " + err.Error()})
 			}
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al eliminar categoria: " + err.Error()})
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al eliminar producto: " + err.Error()})
 		}
 
+		archivos := liberarArchivosDeFotos(context.TODO(), referencias, pendientes, storage, eliminadas)
+
 		return c.JSON(http.StatusOK, map[string]interface{}{
-			"mensaje":   "Categoria eliminada correctamente",
-			"eliminado": resultado.DeletedCount > 0, // Confirma que se eliminó
-			"id":        id,
+			"mensaje":            "Producto eliminado correctamente",
+			"eliminado":          true,
+			"id":                 id,
+			"fotos_eliminadas":   len(eliminadas),
+			"archivos_liberados": archivos,
 		})
 	}
 }
@@ -1,9 +1,12 @@
 package rutas
 
 import (
+	"clase_6_echo_mongo/almacenamiento"
 	"clase_6_echo_mongo/database"
+	"clase_6_echo_mongo/modelos"
 	"clase_6_echo_mongo/utilidades"
 	"context"
+	"errors"
 	"net/http"
 	"time"
 
@@ -13,7 +16,9 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func UploadFotoProducto(mongoClient *database.MongoDBClient, dbName, collectionName string) echo.HandlerFunc {
+func UploadFotoProducto(mongoClient *database.MongoDBClient, dbName, collectionName, archivosCollection string, storage almacenamiento.Storage, limites utilidades.LimitesSubida, variantes utilidades.ConfigVariantes) echo.HandlerFunc {
+	fotos := database.NewRepository[modelos.FotoProducto](mongoClient, dbName, collectionName)
+	archivos := database.NewRepository[modelos.ReferenciaArchivo](mongoClient, dbName, archivosCollection)
 	return func(c echo.Context) error {
 		id := c.Param("id")
 		if id == "" || !primitive.IsValidObjectID(id) {
@@ -26,35 +31,139 @@ func UploadFotoProducto(mongoClient *database.MongoDBClient, dbName, collectionN
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "No se encontró el archivo"})
 		}
 
-		mensaje, err := utilidades.SubirArchivo(file)
-
+		archivo, err := utilidades.PrepararArchivo(limites, file)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, mensaje)
+			var errArchivo *utilidades.ErrorArchivo
+			if errors.As(err, &errArchivo) {
+				return c.JSON(errArchivo.Codigo, errArchivo.Respuesta())
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 		}
 
-		// Crear Map
 		productoID, _ := primitive.ObjectIDFromHex(id)
-		timestamp := time.Now().Unix()
-		documentoFotoProducto := bson.M{
-			"nombre":      mensaje["nombre"],
-			"producto_id": productoID,
-			"timestamp":   timestamp,
+
+		// Si el producto ya tiene esta misma imagen no se registra de nuevo
+		existente, err := fotos.FindOne(context.TODO(), bson.M{"producto_id": productoID, "nombre": archivo.Nombre})
+		if err == nil {
+			return c.JSON(http.StatusOK, map[string]string{
+				"mensaje": "La foto ya estaba registrada para este producto",
+				"estado":  "ok",
+				"id":      existente.ID.Hex(),
+			})
+		}
+		if err != mongo.ErrNoDocuments {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+
+		// La referencia se toma antes de todo lo demás: mientras exista, una eliminación concurrente de
+		// otra foto con el mismo contenido no borra los archivos
+		if err := utilidades.TomarReferencia(context.TODO(), archivos, archivo.Nombre); err != nil {
+			if err == utilidades.ErrArchivoBorrandose {
+				return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+		// Si algo falla la referencia se suelta, y los archivos se borran solo si era la última
+		liberar := func(subido *utilidades.ArchivoSubido) {
+			foto := &modelos.FotoProducto{Nombre: archivo.Nombre}
+			if subido != nil {
+				foto.Variantes = subido.Variantes
+			}
+			liberarArchivos(context.TODO(), archivos, storage, foto)
 		}
 
-		// Insertar en MongoDB usando BSON
-		err = mongoClient.InsertDocumento(context.TODO(), dbName, collectionName, documentoFotoProducto)
+		// Si otra foto ya usa el mismo contenido se reutilizan sus archivos
+		foto := &modelos.FotoProducto{
+			Nombre:     archivo.Nombre,
+			ProductoID: productoID,
+			Timestamp:  time.Now().Unix(),
+		}
+		// Solo sirve una foto con variantes: sin ellas su subida sigue en curso (el original se
+		// guarda antes que las variantes) o falló
+		compartida, err := fotos.FindOne(context.TODO(), bson.M{"nombre": archivo.Nombre, "variantes": bson.M{"$type": "object", "$ne": bson.M{}}})
+		if err != nil && err != mongo.ErrNoDocuments {
+			liberar(nil)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+		if compartida != nil {
+			foto.Variantes = compartida.Variantes
+		}
+
+		objID, err := fotos.Insert(context.TODO(), foto)
 		if err != nil {
+			liberar(nil)
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al guardar en la base de datos: " + err.Error()})
 		}
 
-		return c.JSON(http.StatusCreated, map[string]string{
-			"mensaje": "Foto cargada y registrada correctamente",
-			"estado":  "ok",
+		reutilizada := compartida != nil && archivosExisten(context.TODO(), storage, compartida)
+		if !reutilizada {
+			// El nombre deriva del contenido: si otra subida escribe los mismos archivos a la vez, el resultado es igual
+			subido, err := utilidades.SubirArchivo(context.TODO(), storage, variantes, archivo)
+			if err == nil {
+				_, err = fotos.Update(context.TODO(), objID.Hex(), bson.M{"variantes": subido.Variantes})
+			}
+			if err != nil {
+				fotos.Delete(context.TODO(), objID.Hex())
+				liberar(subido)
+				var errArchivo *utilidades.ErrorArchivo
+				if errors.As(err, &errArchivo) {
+					return c.JSON(errArchivo.Codigo, errArchivo.Respuesta())
+				}
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			}
+		}
+
+		return c.JSON(http.StatusCreated, map[string]interface{}{
+			"mensaje":     "Foto cargada y registrada correctamente",
+			"estado":      "ok",
+			"id":          objID.Hex(),
+			"reutilizada": reutilizada, // El contenido ya estaba guardado por otra foto
 		})
 	}
 }
 
-func ListarFotosPorIdProducto(mongoClient *database.MongoDBClient, dbName, collectionName string) echo.HandlerFunc {
+// archivosExisten confirma que el original y las variantes de la foto siguen en el almacenamiento
+func archivosExisten(ctx context.Context, storage almacenamiento.Storage, foto *modelos.FotoProducto) bool {
+	archivo := utilidades.ArchivoSubido{Nombre: foto.Nombre, Variantes: foto.Variantes}
+	for _, nombre := range archivo.Nombres() {
+		contenido, err := storage.Get(ctx, nombre)
+		if err != nil {
+			return false
+		}
+		contenido.Close()
+	}
+	return true
+}
+
+// liberarArchivos suelta la referencia de la foto al archivo y, si era la última, elimina del almacenamiento
+// el original y las variantes. Debe llamarse una sola vez por foto, después de eliminar su documento.
+func liberarArchivos(ctx context.Context, archivos *database.Repository[modelos.ReferenciaArchivo], storage almacenamiento.Storage, foto *modelos.FotoProducto) (bool, map[string]string, error) {
+	ultima, err := utilidades.SoltarReferencia(ctx, archivos, foto.Nombre)
+	if err != nil {
+		return false, map[string]string{"error": "error al consultar la base de datos: " + err.Error()}, err
+	}
+	if !ultima {
+		return false, nil, nil
+	}
+	return borrarArchivosReclamados(ctx, archivos, storage, foto.Nombre, foto.Variantes)
+}
+
+// borrarArchivosReclamados borra un archivo ya reclamado con SoltarReferencia o ReclamarBorrado
+func borrarArchivosReclamados(ctx context.Context, archivos *database.Repository[modelos.ReferenciaArchivo], storage almacenamiento.Storage, nombre string, variantes map[string]string) (bool, map[string]string, error) {
+	archivo := utilidades.ArchivoSubido{Nombre: nombre, Variantes: variantes}
+	mensaje, err := utilidades.EliminarArchivo(ctx, storage, archivo.Nombres()...)
+	if err != nil && err != almacenamiento.ErrNoExiste {
+		utilidades.TerminarBorrado(ctx, archivos, nombre, false)
+		return false, mensaje, err
+	}
+	if err := utilidades.TerminarBorrado(ctx, archivos, nombre, true); err != nil {
+		return true, map[string]string{"error": "error al consultar la base de datos: " + err.Error()}, err
+	}
+	return true, nil, nil
+}
+
+func ListarFotosPorIdProducto(mongoClient *database.MongoDBClient, dbName, collectionName string, storage almacenamiento.Storage) echo.HandlerFunc {
+	fotos := database.NewRepository[modelos.FotoProducto](mongoClient, dbName, collectionName)
 	return func(c echo.Context) error {
 		id := c.Param("id") // Obtener ID de la URL (:id)
 		if id == "" || !primitive.IsValidObjectID(id) {
@@ -70,34 +179,37 @@ func ListarFotosPorIdProducto(mongoClient *database.MongoDBClient, dbName, colle
 			"producto_id": objID,
 		} // Filtro base, ej. de query params
 
-		pipeline := mongo.Pipeline{
-			{{Key: "$match", Value: filter}},
-			{{Key: "$project", Value: bson.D{ // $project permite omitir campos al asignar el valor a 0
-				{Key: "timestamp", Value: 0},
-				{Key: "producto_id", Value: 0},
-			}}},
-			{{Key: "$addFields", Value: bson.M{ // $addFields me permite agregar texto a un campo al devolver su valor desde MongoDB
-				"nombre": bson.M{
-					"$concat": []interface{}{"http://localhost:8086/imagenes/", "$nombre"},
-				},
-			}}},
-		}
-
 		// Listar documentos de la colección
-		documentos, err := mongoClient.ListDocumentos(context.TODO(), dbName, collectionName, pipeline)
+		documentos, err := fotos.Find(context.TODO(), filter)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al listar categorias: " + err.Error()})
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al listar imágenes: " + err.Error()})
+		}
+
+		// Se entrega la URL pública en lugar del nombre del archivo
+		imagenes := make([]modelos.ImagenProducto, 0, len(documentos))
+		for _, foto := range documentos {
+			urlsVariantes := map[string]string{}
+			for variante, nombre := range foto.Variantes {
+				urlsVariantes[variante] = storage.URL(nombre)
+			}
+			imagenes = append(imagenes, modelos.ImagenProducto{
+				ID:        foto.ID,
+				Nombre:    storage.URL(foto.Nombre),
+				Variantes: urlsVariantes,
+			})
 		}
 
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"mensaje":     "Imágenes encontradas",
 			"producto_id": id,
-			"imagenes":    documentos,
+			"imagenes":    imagenes,
 		})
 	}
 }
 
-func EliminarFotoProducto(mongoClient *database.MongoDBClient, dbName, collectionName string) echo.HandlerFunc {
+func EliminarFotoProducto(mongoClient *database.MongoDBClient, dbName, collectionName, archivosCollection string, storage almacenamiento.Storage) echo.HandlerFunc {
+	fotos := database.NewRepository[modelos.FotoProducto](mongoClient, dbName, collectionName)
+	archivos := database.NewRepository[modelos.ReferenciaArchivo](mongoClient, dbName, archivosCollection)
 	return func(c echo.Context) error {
 		id := c.Param("id") // Obtener ID de la URL (:id)
 		if id == "" || !primitive.IsValidObjectID(id) {
@@ -109,29 +221,16 @@ func EliminarFotoProducto(mongoClient *database.MongoDBClient, dbName, collectio
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "ID no es válido: " + err.Error()})
 		}
 
-		filter := bson.M{
-			"_id": objID,
-		} // Filtro base, ej. de query params
-
-		documento, err := mongoClient.BuscarDocumentoExistente(context.TODO(), dbName, collectionName, filter)
+		foto, err := fotos.FindOne(context.TODO(), bson.M{"_id": objID})
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
-		}
-
-		if len(documento) == 0 {
-			return c.JSON(http.StatusNotFound, map[string]string{"error": "Elemento no encontrado"})
-		}
-
-		nombreEnDocumento := documento[0]["nombre"]
-		nombreArchivo, _ := nombreEnDocumento.(string)
-
-		mensaje, err := utilidades.EliminarArchivo(nombreArchivo)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, mensaje)
+			if err == mongo.ErrNoDocuments {
+				return c.JSON(http.StatusNotFound, map[string]string{"error": "Elemento no encontrado"})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
 		}
 
 		// Eliminar documento de la colección en MongoDB
-		resultado, err := mongoClient.DeleteDocumento(context.TODO(), dbName, collectionName, id)
+		resultado, err := fotos.Delete(context.TODO(), id)
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
 				return c.JSON(http.StatusNotFound, map[string]string{"error": "Elemento no encontrado: " + err.Error()})
@@ -139,10 +238,17 @@ func EliminarFotoProducto(mongoClient *database.MongoDBClient, dbName, collectio
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al eliminar documento: " + err.Error()})
 		}
 
+		// El archivo se borra solo cuando era la última referencia
+		archivoEliminado, mensaje, err := liberarArchivos(context.TODO(), archivos, storage, foto)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, mensaje)
+		}
+
 		return c.JSON(http.StatusOK, map[string]interface{}{
-			"mensaje":   "Imágen eliminada correctamente",
-			"eliminado": resultado.DeletedCount > 0, // Confirma que se eliminó
-			"id":        id,
+			"mensaje":           "Imágen eliminada correctamente",
+			"eliminado":         resultado.DeletedCount > 0, // Confirma que se eliminó
+			"archivo_eliminado": archivoEliminado,
+			"id":                id,
 		})
 	}
 }
@@ -0,0 +1,155 @@
+package rutas
+
+import (
+	"clase_6_echo_mongo/database"
+	"clase_6_echo_mongo/modelos"
+	"clase_6_echo_mongo/validaciones"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gosimple/slug"
+	echo "github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Nombres de los índices únicos de productos declarados en config.Indices, permiten saber qué campo
+// causó un error de clave duplicada
+const (
+	indiceSlugProducto = "slug_unico"
+	indiceSkuProducto  = "sku_unico"
+)
+
+// errSkuEnUso indica que el código de barras ya pertenece a otro producto
+var errSkuEnUso = errors.New("El sku ya está registrado en otro producto")
+
+// slugProducto deriva del nombre un slug que ningún otro producto use, ni como actual ni como anterior
+func slugProducto(ctx context.Context, productos *database.Repository[modelos.Producto], nombre string, excluir primitive.ObjectID) (string, error) {
+	return primerSlugLibre(slug.Make(nombre), func(candidato string) (bool, error) {
+		filtro := bson.M{"$or": []bson.M{{"slug": candidato}, {"slugs_anteriores": candidato}}}
+		if !excluir.IsZero() {
+			filtro["_id"] = bson.M{"$ne": excluir}
+		}
+		cantidad, err := productos.Count(ctx, filtro)
+		return cantidad > 0, err
+	})
+}
+
+// skuEnUso indica si otro producto distinto a excluir tiene el sku
+func skuEnUso(ctx context.Context, productos *database.Repository[modelos.Producto], sku string, excluir primitive.ObjectID) (bool, error) {
+	filtro := bson.M{"sku": sku}
+	if !excluir.IsZero() {
+		filtro["_id"] = bson.M{"$ne": excluir}
+	}
+	cantidad, err := productos.Count(ctx, filtro)
+	return cantidad > 0, err
+}
+
+// duplicadoEnIndice indica si err es una clave duplicada en el índice indicado
+func duplicadoEnIndice(err error, indice string) bool {
+	return mongo.IsDuplicateKeyError(err) && strings.Contains(err.Error(), indice)
+}
+
+// AsegurarIdentificadoresProductos asigna slug a los productos anteriores a él y luego aplica los índices
+// únicos de slug y sku de config.Indices. Ambos solo abarcan los productos que tienen el campo.
+func AsegurarIdentificadoresProductos(ctx context.Context, mongoClient *database.MongoDBClient, dbName, collectionName string) error {
+	productos := database.NewRepository[modelos.Producto](mongoClient, dbName, collectionName)
+
+	sinSlug, err := productos.Find(ctx, bson.M{"$or": []bson.M{{"slug": bson.M{"$exists": false}}, {"slug": ""}}})
+	if err != nil {
+		return err
+	}
+	for _, producto := range sinSlug {
+		nuevo, err := slugProducto(ctx, productos, producto.Nombre, producto.ID)
+		if err != nil {
+			return err
+		}
+		if _, err := productos.Update(ctx, producto.ID.Hex(), bson.M{"slug": nuevo}); err != nil {
+			return err
+		}
+	}
+	if len(sinSlug) > 0 {
+		log.Printf("Slugs asignados a %d productos", len(sinSlug))
+	}
+
+	return asegurarIndicesUnicos(ctx, mongoClient, dbName, collectionName, indiceSlugProducto, indiceSkuProducto)
+}
+
+// buscarProductoDetalle devuelve el producto que cumple el filtro junto a su categoría
+func buscarProductoDetalle(ctx context.Context, productos *database.Repository[modelos.ProductoDetalle], categoriasCollection string, filtro bson.M) (*modelos.ProductoDetalle, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filtro}},
+		{{Key: "$limit", Value: 1}},
+		etapaLookupCategoria(categoriasCollection),
+	}
+
+	documentos, err := productos.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	if len(documentos) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return &documentos[0], nil
+}
+
+// ListarProductoPorSlug busca el producto por su slug. Un slug anterior redirige al actual.
+func ListarProductoPorSlug(mongoClient *database.MongoDBClient, dbName, productosCollection, categoriasCollection string) echo.HandlerFunc {
+	productos := database.NewRepository[modelos.ProductoDetalle](mongoClient, dbName, productosCollection)
+	return func(c echo.Context) error {
+		buscado := strings.ToLower(strings.TrimSpace(c.Param("slug")))
+		if buscado == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Slug requerido"})
+		}
+
+		documento, err := buscarProductoDetalle(context.TODO(), productos, categoriasCollection, bson.M{"slug": buscado})
+		if err == mongo.ErrNoDocuments {
+			// El producto pudo haber sido renombrado
+			renombrado, err := productos.FindOne(context.TODO(), bson.M{"slugs_anteriores": buscado})
+			if err != nil {
+				if err == mongo.ErrNoDocuments {
+					return c.JSON(http.StatusNotFound, map[string]string{"error": "Elemento no encontrado: " + err.Error()})
+				}
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al buscar producto: " + err.Error()})
+			}
+			return c.Redirect(http.StatusMovedPermanently, path.Join(path.Dir(c.Request().URL.Path), renombrado.Slug))
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al buscar producto: " + err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"mensaje": "Producto encontrado",
+			"datos":   documento,
+		})
+	}
+}
+
+// ListarProductoPorSku busca por código de barras, es lo único que conocen los lectores de bodega
+func ListarProductoPorSku(mongoClient *database.MongoDBClient, dbName, productosCollection, categoriasCollection string) echo.HandlerFunc {
+	productos := database.NewRepository[modelos.ProductoDetalle](mongoClient, dbName, productosCollection)
+	return func(c echo.Context) error {
+		sku := strings.TrimSpace(c.Param("sku"))
+		if !validaciones.EAN13Valido(sku) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "El sku debe ser un código EAN-13 válido"})
+		}
+
+		documento, err := buscarProductoDetalle(context.TODO(), productos, categoriasCollection, bson.M{"sku": sku})
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return c.JSON(http.StatusNotFound, map[string]string{"error": "Elemento no encontrado: " + err.Error()})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al buscar producto: " + err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"mensaje": "Producto encontrado",
+			"datos":   documento,
+		})
+	}
+}
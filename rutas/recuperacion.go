@@ -0,0 +1,173 @@
+package rutas
+
+import (
+	"clase_6_echo_mongo/correo"
+	"clase_6_echo_mongo/database"
+	"clase_6_echo_mongo/modelos"
+	"clase_6_echo_mongo/utilidades"
+	"clase_6_echo_mongo/validaciones"
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	echo "github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Vigencia del enlace de recuperación de contraseña
+const duracionResetPassword = time.Hour
+
+// urlConToken agrega el token como parámetro a la URL configurada en la variable indicada
+func urlConToken(variable, porDefecto, token string) string {
+	base := os.Getenv(variable)
+	if base == "" {
+		base = porDefecto
+	}
+	separador := "?"
+	if strings.Contains(base, "?") {
+		separador = "&"
+	}
+	return base + separador + "token=" + url.QueryEscape(token)
+}
+
+// crearResetPassword invalida los tokens pendientes del usuario, genera uno nuevo y lo envía por correo
+func crearResetPassword(ctx context.Context, resets *database.Repository[modelos.PasswordReset], mailer correo.Mailer, usuario *modelos.UsuarioDto) error {
+	_, err := resets.Collection().UpdateMany(ctx, bson.M{"usuario_id": usuario.ID, "usado": false}, bson.M{"$set": bson.M{"usado": true}})
+	if err != nil {
+		return err
+	}
+
+	token, err := utilidades.GenerarTokenAleatorio(32)
+	if err != nil {
+		return err
+	}
+	_, err = resets.Insert(ctx, &modelos.PasswordReset{
+		Hash:      utilidades.HashToken(token),
+		UsuarioID: usuario.ID,
+		Expira:    time.Now().Add(duracionResetPassword),
+		Timestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return mailer.Enviar(ctx, correo.Mensaje{
+		Para:   usuario.Correo,
+		Asunto: "Recuperación de contraseña",
+		Cuerpo: "Hola " + usuario.Nombre + ",\n\n" +
+			"Para crear una nueva contraseña ingresa al siguiente enlace, válido por una hora:\n" +
+			urlConToken("URL_RESET_PASSWORD", "http://localhost:8086/reset-password", token) + "\n\n" +
+			"Si no solicitaste el cambio puedes ignorar este mensaje.",
+	})
+}
+
+func OlvidoPassword(mongoClient *database.MongoDBClient, dbName, usuariosCollection, resetsCollection string, mailer correo.Mailer) echo.HandlerFunc {
+	usuarios := database.NewRepository[modelos.UsuarioDto](mongoClient, dbName, usuariosCollection)
+	resets := database.NewRepository[modelos.PasswordReset](mongoClient, dbName, resetsCollection)
+	return func(c echo.Context) error {
+		dto := new(modelos.CorreoDto)
+
+		// Bindear el JSON
+		if err := c.Bind(dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Error al procesar el JSON: " + err.Error()})
+		}
+
+		// Validación de campos
+		if err := validaciones.ValidarCorreo(*dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		// La respuesta es la misma exista o no el correo, para no revelar qué cuentas existen
+		respuesta := map[string]string{
+			"mensaje": "Si el correo está registrado recibirás un enlace para recuperar tu contraseña",
+			"estado":  "ok",
+		}
+
+		usuario, err := usuarios.FindOne(context.TODO(), bson.M{"correo": dto.Correo, "eliminado_en": bson.M{"$exists": false}})
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return c.JSON(http.StatusOK, respuesta)
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+
+		// Mismo límite que el reenvío de verificación, pero sin 429: la respuesta no debe revelar si la cuenta existe
+		ultimos, err := resets.Find(context.TODO(), bson.M{"usuario_id": usuario.ID},
+			options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}).SetLimit(1))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+		if len(ultimos) > 0 && time.Since(time.Unix(ultimos[0].Timestamp, 0)) < esperaReenvio {
+			return c.JSON(http.StatusOK, respuesta)
+		}
+
+		if err := crearResetPassword(context.TODO(), resets, mailer, usuario); err != nil {
+			log.Printf("Error al enviar la recuperación de contraseña a %s: %v", usuario.Correo, err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "No se pudo enviar el correo de recuperación"})
+		}
+
+		return c.JSON(http.StatusOK, respuesta)
+	}
+}
+
+func ResetPassword(mongoClient *database.MongoDBClient, dbName, usuariosCollection, resetsCollection, refreshCollection string) echo.HandlerFunc {
+	usuarios := database.NewRepository[modelos.UsuarioDto](mongoClient, dbName, usuariosCollection)
+	resets := database.NewRepository[modelos.PasswordReset](mongoClient, dbName, resetsCollection)
+	refreshTokens := database.NewRepository[modelos.RefreshToken](mongoClient, dbName, refreshCollection)
+	return func(c echo.Context) error {
+		dto := new(modelos.ResetPasswordDto)
+
+		// Bindear el JSON
+		if err := c.Bind(dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Error al procesar el JSON: " + err.Error()})
+		}
+
+		// Validación de campos, la nueva contraseña sigue las mismas reglas que en el registro
+		if err := validaciones.ValidarResetPassword(*dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		// El token se consume de forma atómica para que no se pueda usar dos veces
+		filter := bson.M{
+			"hash":   utilidades.HashToken(dto.Token),
+			"usado":  false,
+			"expira": bson.M{"$gt": time.Now()},
+		}
+		reset := new(modelos.PasswordReset)
+		err := resets.Collection().FindOneAndUpdate(context.TODO(), filter, bson.M{"$set": bson.M{"usado": true}}).Decode(reset)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "El enlace de recuperación es inválido o expiró"})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+
+		password, err := hashPassword(dto.Password)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al procesar la contraseña: " + err.Error()})
+		}
+
+		if _, err := usuarios.Update(context.TODO(), reset.UsuarioID.Hex(), bson.M{"password": password, "reset_requerido": false}); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "El enlace de recuperación es inválido o expiró"})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al actualizar la contraseña: " + err.Error()})
+		}
+
+		// Las sesiones abiertas con la contraseña anterior dejan de valer, incluidos sus tokens de acceso
+		if err := invalidarTokensUsuario(context.TODO(), usuarios, refreshTokens, reset.UsuarioID); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al revocar las sesiones: " + err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{
+			"mensaje": "Contraseña actualizada correctamente",
+			"estado":  "ok",
+		})
+	}
+}
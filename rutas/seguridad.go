@@ -1,21 +1,35 @@
 package rutas
 
 import (
+	"clase_6_echo_mongo/correo"
 	"clase_6_echo_mongo/database"
 	"clase_6_echo_mongo/jwt"
 	"clase_6_echo_mongo/modelos"
+	"clase_6_echo_mongo/oidc"
+	"clase_6_echo_mongo/utilidades"
 	"clase_6_echo_mongo/validaciones"
 	"context"
+	"log"
 	"net/http"
 	"time"
 
 	echo "github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func LoginUsuario(mongoClient *database.MongoDBClient, dbName, collectionName string) echo.HandlerFunc {
+// hashPassword genera el hash Bcrypt con el que se guardan las contraseñas
+func hashPassword(password string) (string, error) {
+	costo := 8
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), costo)
+	return string(bytes), err
+}
+
+func LoginUsuario(mongoClient *database.MongoDBClient, dbName, collectionName, refreshCollection, intentosCollection string, limites utilidades.LimitesLogin, tokens *jwt.Servicio, proveedores oidc.Proveedores) echo.HandlerFunc {
+	usuarios := database.NewRepository[modelos.UsuarioDto](mongoClient, dbName, collectionName)
+	refreshTokens := database.NewRepository[modelos.RefreshToken](mongoClient, dbName, refreshCollection)
+	intentos := database.NewRepository[modelos.IntentoLogin](mongoClient, dbName, intentosCollection)
 	return func(c echo.Context) error {
 		usuarioLogin := new(modelos.LoginDto)
 
@@ -29,49 +43,119 @@ func LoginUsuario(mongoClient *database.MongoDBClient, dbName, collectionName st
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 		}
 
+		// Los dominios con SSO obligatorio no aceptan contraseña
+		if proveedor := proveedores.SSOPara(usuarioLogin.Correo); proveedor != nil {
+			return c.JSON(http.StatusForbidden, map[string]string{
+				"error":     "Las cuentas de este dominio inician sesión con SSO",
+				"proveedor": proveedor.Nombre,
+			})
+		}
+
+		// Cuentas e IPs bloqueadas no llegan a comparar la contraseña
+		cuenta, ip := claveCuenta(usuarioLogin.Correo), claveIP(c.RealIP())
+		restante, err := bloqueoRestante(context.TODO(), intentos, cuenta, ip)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+		if restante > 0 {
+			return responderBloqueo(c, restante)
+		}
+
+		// Un fallo cuenta para la cuenta (exista o no, para no revelarlo) y para la IP
+		credencialesInvalidas := func() error {
+			if err := registrarFallo(context.TODO(), intentos, limites, cuenta, limites.MaxFallosCuenta); err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al registrar el intento: " + err.Error()})
+			}
+			if err := registrarFallo(context.TODO(), intentos, limites, ip, limites.MaxFallosIP); err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al registrar el intento: " + err.Error()})
+			}
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Las credenciales ingresadas son inválidas"})
+		}
+
 		// INTEGRANDO VALIDACIÓN DE CORREO EN BASE DE DATOS
 		filter := bson.M{
 			"correo": usuarioLogin.Correo,
 		} // Filtro base, ej. de query params
 
-		documento, err := mongoClient.BuscarDocumentoExistente(context.TODO(), dbName, collectionName, filter)
+		usuario, err := usuarios.FindOne(context.TODO(), filter)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
-		}
-
-		// Validar si ya existe
-		if len(documento) < 1 {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Las credenciales ingresadas son inválidas"})
+			// Validar si existe
+			if err == mongo.ErrNoDocuments {
+				return credencialesInvalidas()
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
 		}
 
 		// Proceso de comparación de password
 		passwordBytes := []byte(usuarioLogin.Password)
-		passwordEnDocumento := documento[0]["password"]
-		passwordBD := []byte(passwordEnDocumento.(string))
+		passwordBD := []byte(usuario.Password)
 		errPassword := bcrypt.CompareHashAndPassword(passwordBD, passwordBytes)
 
 		if errPassword != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Las credenciales ingresadas son inválidas"})
+			return credencialesInvalidas()
 		} else {
-			correoEnDocumento := documento[0]["correo"].(string)
-			usuarioEnDocumento := documento[0]["nombre"].(string)
-			idEnDocumento := documento[0]["_id"].(primitive.ObjectID).Hex()
-			jwtKey, err := jwt.GenerarJWT(correoEnDocumento, usuarioEnDocumento, idEnDocumento)
-
-			if err != nil {
-				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Error al intentar generar el token" + err.Error()})
-			} else {
-				retorno := modelos.LoginRespuestaDto{
-					Nombre: usuarioEnDocumento,
-					Token:  "Bearer " + jwtKey,
+			// Una cuenta eliminada se restaura al iniciar sesión dentro del periodo de gracia
+			if usuario.EliminadoEn != nil {
+				if time.Since(*usuario.EliminadoEn) > periodoGraciaCuenta() {
+					return credencialesInvalidas()
+				}
+				_, err := usuarios.Collection().UpdateOne(context.TODO(), bson.M{"_id": usuario.ID}, bson.M{"$unset": bson.M{"eliminado_en": ""}})
+				if err != nil {
+					return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al restaurar la cuenta: " + err.Error()})
 				}
-				return c.JSON(http.StatusOK, retorno)
+				usuario.EliminadoEn = nil
+			}
+
+			if !usuario.EstaHabilitado() {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "La cuenta está deshabilitada, contacta a un administrador"})
 			}
+			if usuario.ResetRequerido {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "Debes restablecer tu contraseña, revisa tu correo o usa la recuperación de contraseña"})
+			}
+
+			// El contador de la IP no se limpia: iniciar sesión con una cuenta propia no debe habilitar más intentos
+			if err := limpiarFallos(context.TODO(), intentos, cuenta); err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al registrar el intento: " + err.Error()})
+			}
+
+			// Con verificación obligatoria no se entrega sesión hasta confirmar el correo
+			if verificacionObligatoria() && !usuario.EstaVerificado() {
+				return c.JSON(http.StatusForbidden, map[string]interface{}{
+					"error":      "Debes verificar tu correo antes de iniciar sesión",
+					"verificado": false,
+				})
+			}
+
+			return responderLogin(c, tokens, refreshTokens, usuario)
 		}
 	}
 }
 
-func RegistroUsuario(mongoClient *database.MongoDBClient, dbName, collectionName string) echo.HandlerFunc {
+// responderLogin entrega la sesión del usuario ya autenticado o, si tiene segundo factor,
+// el token pendiente que se canjea en /login/2fa
+func responderLogin(c echo.Context, tokens *jwt.Servicio, refreshTokens *database.Repository[modelos.RefreshToken], usuario *modelos.UsuarioDto) error {
+	if usuario.TieneMFA() {
+		mfaToken, err := tokens.GenerarJWTPendienteMFA(usuario.ID.Hex())
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al intentar generar el token: " + err.Error()})
+		}
+		return c.JSON(http.StatusOK, modelos.LoginMFAPendienteDto{
+			MFARequerido: true,
+			MFAToken:     mfaToken,
+			ExpiraEn:     int64(jwt.DuracionMFAPendiente.Seconds()),
+		})
+	}
+
+	retorno, err := emitirSesion(context.TODO(), tokens, refreshTokens, usuario, "", false)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Error al intentar generar el token" + err.Error()})
+	}
+	return c.JSON(http.StatusOK, retorno)
+}
+
+func RegistroUsuario(mongoClient *database.MongoDBClient, dbName, collectionName, verificacionesCollection string, mailer correo.Mailer) echo.HandlerFunc {
+	usuarios := database.NewRepository[modelos.UsuarioDto](mongoClient, dbName, collectionName)
+	verificaciones := database.NewRepository[modelos.VerificacionCorreo](mongoClient, dbName, verificacionesCollection)
 	return func(c echo.Context) error {
 		usuario := new(modelos.UsuarioDto)
 
@@ -90,42 +174,49 @@ func RegistroUsuario(mongoClient *database.MongoDBClient, dbName, collectionName
 			"correo": usuario.Correo,
 		} // Filtro base, ej. de query params
 
-		documento, err := mongoClient.BuscarDocumentoExistente(context.TODO(), dbName, collectionName, filter)
+		existentes, err := usuarios.Count(context.TODO(), filter)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
 		}
 
 		// Validar si ya existe
-		if len(documento) > 0 {
+		if existentes > 0 {
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "El correo ya está registrado"})
 		}
 
 		// generar Hash con Bcrypt para contraseña
 		// wololo.Pass2 contraseña ejemplo
-		costo := 8
-		bytes, _ := bcrypt.GenerateFromPassword([]byte(usuario.Password), costo)
-		password := string(bytes)
+		password, err := hashPassword(usuario.Password)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al procesar la contraseña: " + err.Error()})
+		}
 
 		// Creando documento para inserción
 		usuario.Timestamp = time.Now().Unix()
-		documentoUsuario := bson.M{
-			"nombre":    usuario.Nombre,
-			"correo":    usuario.Correo,
-			"telefono":  usuario.Telefono,
-			"timestamp": usuario.Timestamp,
-		}
 
-		// Asigno el valor encriptado de la contraseña al map bson.M que se insertará en la base de datos
-		documentoUsuario["password"] = password
+		// Todo usuario nuevo parte con el rol de solo lectura
+		usuario.Rol = modelos.RolViewer
+
+		// El correo queda pendiente hasta que se abra el enlace de verificación
+		verificado := false
+		usuario.Verificado = &verificado
+
+		// Asigno el valor encriptado de la contraseña al documento que se insertará en la base de datos
+		usuario.Password = password
 
-		// Insertar en MongoDB usando BSON
-		err = mongoClient.InsertDocumento(context.TODO(), dbName, collectionName, documentoUsuario)
+		// Insertar en MongoDB
+		usuario.ID, err = usuarios.Insert(context.TODO(), usuario)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al guardar en la base de datos: " + err.Error()})
 		}
 
+		// Si el envío falla el usuario queda creado y puede pedir el reenvío
+		if err := enviarVerificacion(context.TODO(), verificaciones, mailer, usuario, usuario.Correo); err != nil {
+			log.Printf("Error al enviar la verificación a %s: %v", usuario.Correo, err)
+		}
+
 		return c.JSON(http.StatusCreated, map[string]string{
-			"mensaje": "Usuario creado correctamente",
+			"mensaje": "Usuario creado correctamente, revisa tu correo para verificar la cuenta",
 			"estado":  "ok",
 		})
 	}
@@ -0,0 +1,193 @@
+package rutas
+
+import (
+	"clase_6_echo_mongo/database"
+	"clase_6_echo_mongo/jwt"
+	"clase_6_echo_mongo/middleware_custom"
+	"clase_6_echo_mongo/modelos"
+	"clase_6_echo_mongo/utilidades"
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	echo "github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// emitirSesion genera el token de acceso y un refresh token nuevo dentro de la familia indicada.
+// Con familia vacía se inicia una familia nueva (login). mfa indica que el login usó segundo factor
+// y se conserva en toda la familia.
+func emitirSesion(ctx context.Context, tokens *jwt.Servicio, refreshTokens *database.Repository[modelos.RefreshToken], usuario *modelos.UsuarioDto, familia string, mfa bool) (*modelos.LoginRespuestaDto, error) {
+	jwtKey, err := tokens.GenerarJWT(usuario.Correo, usuario.Nombre, usuario.ID.Hex(), usuario.RolEfectivo(), mfa, usuario.TokenVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := utilidades.GenerarTokenAleatorio(32)
+	if err != nil {
+		return nil, err
+	}
+	if familia == "" {
+		familia = primitive.NewObjectID().Hex()
+	}
+
+	_, err = refreshTokens.Insert(ctx, &modelos.RefreshToken{
+		Hash:      utilidades.HashToken(refreshToken),
+		UsuarioID: usuario.ID,
+		Familia:   familia,
+		MFA:       mfa,
+		Expira:    time.Now().Add(jwt.DuracionRefresh()),
+		Timestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &modelos.LoginRespuestaDto{
+		Nombre:       usuario.Nombre,
+		Token:        "Bearer " + jwtKey,
+		RefreshToken: refreshToken,
+		ExpiraEn:     int64(jwt.DuracionAcceso().Seconds()),
+		Verificado:   usuario.EstaVerificado(),
+	}, nil
+}
+
+// revocarFamilia invalida todos los refresh tokens nacidos del mismo login
+func revocarFamilia(ctx context.Context, refreshTokens *database.Repository[modelos.RefreshToken], familia string) error {
+	_, err := refreshTokens.Collection().UpdateMany(ctx, bson.M{"familia": familia}, bson.M{"$set": bson.M{"revocado": true}})
+	return err
+}
+
+// revocarJti agrega el token a la lista de revocados hasta su expiración.
+// Upsert para que revocar dos veces no duplique el registro.
+func revocarJti(ctx context.Context, revocados *database.Repository[modelos.TokenRevocado], claims jwt.Claims, porDefecto time.Duration) error {
+	jti, _ := claims["jti"].(string)
+	expira := time.Now().Add(porDefecto)
+	if exp, ok := claims["exp"].(float64); ok {
+		expira = time.Unix(int64(exp), 0)
+	}
+	_, err := revocados.Collection().UpdateOne(ctx,
+		bson.M{"jti": jti},
+		bson.M{"$setOnInsert": modelos.TokenRevocado{Jti: jti, Expira: expira}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func RefrescarToken(mongoClient *database.MongoDBClient, dbName, usuariosCollection, refreshCollection string, tokens *jwt.Servicio) echo.HandlerFunc {
+	usuarios := database.NewRepository[modelos.UsuarioDto](mongoClient, dbName, usuariosCollection)
+	refreshTokens := database.NewRepository[modelos.RefreshToken](mongoClient, dbName, refreshCollection)
+	return func(c echo.Context) error {
+		dto := new(modelos.RefreshDto)
+
+		// Bindear el JSON
+		if err := c.Bind(dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Error al procesar el JSON: " + err.Error()})
+		}
+		if strings.TrimSpace(dto.RefreshToken) == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "El campo 'refresh_token' es requerido"})
+		}
+		hash := utilidades.HashToken(dto.RefreshToken)
+
+		// Se marca como usado de forma atómica: dos peticiones con el mismo token no pueden rotarlo ambas
+		filter := bson.M{
+			"hash":     hash,
+			"usado":    false,
+			"revocado": false,
+			"expira":   bson.M{"$gt": time.Now()},
+		}
+		actual := new(modelos.RefreshToken)
+		err := refreshTokens.Collection().FindOneAndUpdate(context.TODO(), filter, bson.M{"$set": bson.M{"usado": true}}).Decode(actual)
+		if err != nil {
+			if err != mongo.ErrNoDocuments {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+			}
+
+			// Un token ya usado o revocado que vuelve a aparecer indica que fue robado
+			anterior, errBusqueda := refreshTokens.FindOne(context.TODO(), bson.M{"hash": hash})
+			if errBusqueda == nil && (anterior.Usado || anterior.Revocado) {
+				if err := revocarFamilia(context.TODO(), refreshTokens, anterior.Familia); err != nil {
+					return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al revocar la sesión: " + err.Error()})
+				}
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Refresh token reutilizado, la sesión fue revocada"})
+			}
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Refresh token inválido o expirado"})
+		}
+
+		usuario, err := usuarios.FindOne(context.TODO(), bson.M{"_id": actual.UsuarioID})
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Refresh token inválido o expirado"})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+		if usuario.EliminadoEn != nil || !usuario.EstaHabilitado() || usuario.ResetRequerido {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Refresh token inválido o expirado"})
+		}
+
+		retorno, err := emitirSesion(context.TODO(), tokens, refreshTokens, usuario, actual.Familia, actual.MFA)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al intentar generar el token: " + err.Error()})
+		}
+		return c.JSON(http.StatusOK, retorno)
+	}
+}
+
+// CerrarSesion revoca el token de acceso actual y, si se envía, la familia del refresh token
+func CerrarSesion(mongoClient *database.MongoDBClient, dbName, refreshCollection, revocadosCollection string) echo.HandlerFunc {
+	refreshTokens := database.NewRepository[modelos.RefreshToken](mongoClient, dbName, refreshCollection)
+	revocados := database.NewRepository[modelos.TokenRevocado](mongoClient, dbName, revocadosCollection)
+	return func(c echo.Context) error {
+		dto := new(modelos.RefreshDto)
+		if err := c.Bind(dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Error al procesar el JSON: " + err.Error()})
+		}
+
+		claims, _ := middleware_custom.Claims(c)
+		jti, _ := claims["jti"].(string)
+		if jti == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "El token no admite revocación, inicie sesión nuevamente"})
+		}
+
+		if err := revocarJti(context.TODO(), revocados, claims, jwt.DuracionAcceso()); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al revocar el token: " + err.Error()})
+		}
+
+		if dto.RefreshToken != "" {
+			refresh, err := refreshTokens.FindOne(context.TODO(), bson.M{"hash": utilidades.HashToken(dto.RefreshToken)})
+			if err == nil {
+				idUsuario, _ := claims["id"].(string)
+				if refresh.UsuarioID.Hex() == idUsuario {
+					if err := revocarFamilia(context.TODO(), refreshTokens, refresh.Familia); err != nil {
+						return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al revocar la sesión: " + err.Error()})
+					}
+				}
+			} else if err != mongo.ErrNoDocuments {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+			}
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{
+			"mensaje": "Sesión cerrada correctamente",
+			"estado":  "ok",
+		})
+	}
+}
+
+// JWKS publica las claves públicas vigentes para validar los tokens emitidos por este servicio
+func JWKS(tokens *jwt.Servicio) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claves, err := tokens.JWKS(c.Request().Context())
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al obtener las claves: " + err.Error()})
+		}
+
+		// Los clientes pueden guardarlas unos minutos; ante un kid desconocido deben volver a pedirlas
+		c.Response().Header().Set("Cache-Control", "public, max-age=300")
+		return c.JSON(http.StatusOK, map[string]interface{}{"keys": claves})
+	}
+}
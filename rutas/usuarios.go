@@ -0,0 +1,353 @@
+package rutas
+
+import (
+	"clase_6_echo_mongo/correo"
+	"clase_6_echo_mongo/database"
+	"clase_6_echo_mongo/middleware_custom"
+	"clase_6_echo_mongo/modelos"
+	"clase_6_echo_mongo/utilidades"
+	"clase_6_echo_mongo/validaciones"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	echo "github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// errIDInvalido indica que el :id de la ruta no es un ObjectID
+var errIDInvalido = errors.New("ID inválido o requerido")
+
+// Campos por los que se puede ordenar el listado de usuarios (?sort=)
+var ordenUsuariosPermitido = map[string]bool{
+	"nombre":    true,
+	"correo":    true,
+	"timestamp": true,
+}
+
+// filtroUsuarios traduce los query params de búsqueda a un filtro de MongoDB
+func filtroUsuarios(c echo.Context) (bson.M, error) {
+	filter := bson.M{}
+
+	if q := strings.TrimSpace(c.QueryParam("q")); q != "" {
+		// Búsqueda por prefijo en correo o nombre, sin distinguir mayúsculas
+		patron := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(q), Options: "i"}
+		filter["$or"] = bson.A{bson.M{"correo": patron}, bson.M{"nombre": patron}}
+	}
+
+	if rol := c.QueryParam("rol"); rol != "" {
+		if !modelos.RolesValidos[rol] {
+			return nil, errors.New("el parámetro 'rol' debe ser admin, editor o viewer")
+		}
+		if rol == modelos.RolViewer {
+			filter["rol"] = bson.M{"$in": bson.A{rol, nil}} // Los usuarios sin rol son viewer
+		} else {
+			filter["rol"] = rol
+		}
+	}
+
+	switch estado := c.QueryParam("estado"); estado {
+	case "":
+	case modelos.EstadoActivo:
+		filter["estado"] = bson.M{"$ne": modelos.EstadoDeshabilitado}
+	case modelos.EstadoDeshabilitado:
+		filter["estado"] = estado
+	default:
+		return nil, errors.New("el parámetro 'estado' debe ser activo o deshabilitado")
+	}
+
+	return filter, nil
+}
+
+// usuarioDelParametro busca el usuario indicado por :id
+func usuarioDelParametro(c echo.Context, usuarios *database.Repository[modelos.UsuarioDto]) (*modelos.UsuarioDto, error) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		return nil, errIDInvalido
+	}
+	return usuarios.FindByID(context.TODO(), id)
+}
+
+// respuestaUsuarioDelParametro traduce el error de usuarioDelParametro a la respuesta HTTP
+func respuestaUsuarioDelParametro(c echo.Context, err error) error {
+	switch err {
+	case errIDInvalido:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "ID inválido o requerido"})
+	case mongo.ErrNoDocuments:
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Usuario no encontrado"})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+}
+
+// esUsuarioDelToken indica si el usuario es quien hace la petición
+func esUsuarioDelToken(c echo.Context, usuario *modelos.UsuarioDto) bool {
+	claims, _ := middleware_custom.Claims(c)
+	id, _ := claims["id"].(string)
+	return id == usuario.ID.Hex()
+}
+
+// quedanOtrosAdmins indica si, sin contar al usuario indicado, queda algún administrador habilitado
+func quedanOtrosAdmins(ctx context.Context, usuarios *database.Repository[modelos.UsuarioDto], usuario *modelos.UsuarioDto) (bool, error) {
+	otros, err := usuarios.Count(ctx, bson.M{
+		"_id":          bson.M{"$ne": usuario.ID},
+		"rol":          modelos.RolAdmin,
+		"estado":       bson.M{"$ne": modelos.EstadoDeshabilitado},
+		"eliminado_en": bson.M{"$exists": false},
+	})
+	return otros > 0, err
+}
+
+// invalidarTokensUsuario sube la versión de tokens del usuario, con lo que ValidarJWT rechaza
+// todos sus tokens de acceso, y revoca sus refresh tokens
+func invalidarTokensUsuario(ctx context.Context, usuarios *database.Repository[modelos.UsuarioDto], refreshTokens *database.Repository[modelos.RefreshToken], id primitive.ObjectID) error {
+	if _, err := usuarios.Collection().UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$inc": bson.M{"token_version": 1}}); err != nil {
+		return err
+	}
+	_, err := refreshTokens.Collection().UpdateMany(ctx, bson.M{"usuario_id": id}, bson.M{"$set": bson.M{"revocado": true}})
+	return err
+}
+
+func ListarUsuarios(mongoClient *database.MongoDBClient, dbName, usuariosCollection string) echo.HandlerFunc {
+	usuarios := database.NewRepository[modelos.UsuarioDto](mongoClient, dbName, usuariosCollection)
+	return func(c echo.Context) error {
+		filter, err := filtroUsuarios(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		paginacion, err := utilidades.LeerPaginacion(c.QueryParam("page"), c.QueryParam("limit"), c.QueryParam("after"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		orden, err := utilidades.LeerOrden(c.QueryParam("sort"), ordenUsuariosPermitido, []utilidades.CampoOrden{{Campo: "_id", Desc: true}})
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		// El total se calcula sin el cursor, sobre todos los usuarios que cumplen el filtro
+		total, err := usuarios.Count(context.TODO(), filter)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al contar usuarios: " + err.Error()})
+		}
+
+		match := filter
+		if paginacion.Cursor != nil {
+			filtroCursor, err := utilidades.FiltroCursor(orden, paginacion.Cursor)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+			}
+			match = bson.M{"$and": []bson.M{filter, filtroCursor}}
+		}
+
+		opciones := options.Find().
+			SetSort(utilidades.EtapaSort(orden)).
+			SetSkip(paginacion.Skip()).
+			SetLimit(paginacion.Limite + 1) // Uno extra para saber si hay más páginas
+		documentos, err := usuarios.Find(context.TODO(), match, opciones)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al listar usuarios: " + err.Error()})
+		}
+
+		var nextCursor interface{}
+		if int64(len(documentos)) > paginacion.Limite {
+			documentos = documentos[:paginacion.Limite]
+			cursor, err := utilidades.CodificarCursor(orden, documentos[len(documentos)-1])
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al generar el cursor: " + err.Error()})
+			}
+			nextCursor = cursor
+		}
+
+		datos := make([]modelos.UsuarioAdminDto, 0, len(documentos))
+		for i := range documentos {
+			datos = append(datos, documentos[i].VistaAdmin())
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"mensaje": "Usuarios listados correctamente",
+			"datos":   datos,
+			"meta": map[string]interface{}{
+				"total":       total,
+				"page":        paginacion.Pagina,
+				"limit":       paginacion.Limite,
+				"next_cursor": nextCursor,
+			},
+		})
+	}
+}
+
+func ObtenerUsuario(mongoClient *database.MongoDBClient, dbName, usuariosCollection string) echo.HandlerFunc {
+	usuarios := database.NewRepository[modelos.UsuarioDto](mongoClient, dbName, usuariosCollection)
+	return func(c echo.Context) error {
+		usuario, err := usuarioDelParametro(c, usuarios)
+		if err != nil {
+			return respuestaUsuarioDelParametro(c, err)
+		}
+		return c.JSON(http.StatusOK, usuario.VistaAdmin())
+	}
+}
+
+// CambiarRolUsuario asigna el rol e invalida los tokens del usuario, que llevan el rol anterior
+func CambiarRolUsuario(mongoClient *database.MongoDBClient, dbName, usuariosCollection, refreshCollection string) echo.HandlerFunc {
+	usuarios := database.NewRepository[modelos.UsuarioDto](mongoClient, dbName, usuariosCollection)
+	refreshTokens := database.NewRepository[modelos.RefreshToken](mongoClient, dbName, refreshCollection)
+	return func(c echo.Context) error {
+		dto := new(modelos.CambioRolDto)
+
+		// Bindear el JSON
+		if err := c.Bind(dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Error al procesar el JSON: " + err.Error()})
+		}
+
+		// Validación de campos
+		if err := validaciones.ValidarCambioRol(*dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		usuario, err := usuarioDelParametro(c, usuarios)
+		if err != nil {
+			return respuestaUsuarioDelParametro(c, err)
+		}
+		if usuario.RolEfectivo() == dto.Rol {
+			return c.JSON(http.StatusOK, map[string]string{"mensaje": "El usuario ya tiene ese rol", "estado": "ok"})
+		}
+
+		// Siempre debe quedar al menos un administrador
+		if usuario.RolEfectivo() == modelos.RolAdmin {
+			quedan, err := quedanOtrosAdmins(context.TODO(), usuarios, usuario)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+			}
+			if !quedan {
+				return c.JSON(http.StatusConflict, map[string]string{"error": "No se puede quitar el rol al último administrador"})
+			}
+		}
+
+		if _, err := usuarios.Update(context.TODO(), usuario.ID.Hex(), bson.M{"rol": dto.Rol}); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al actualizar el usuario: " + err.Error()})
+		}
+		if err := invalidarTokensUsuario(context.TODO(), usuarios, refreshTokens, usuario.ID); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al invalidar los tokens: " + err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{
+			"mensaje": "Rol actualizado correctamente, el usuario debe iniciar sesión nuevamente",
+			"estado":  "ok",
+		})
+	}
+}
+
+// CambiarEstadoUsuario habilita o deshabilita la cuenta; al deshabilitarla se invalidan sus tokens
+func CambiarEstadoUsuario(mongoClient *database.MongoDBClient, dbName, usuariosCollection, refreshCollection string) echo.HandlerFunc {
+	usuarios := database.NewRepository[modelos.UsuarioDto](mongoClient, dbName, usuariosCollection)
+	refreshTokens := database.NewRepository[modelos.RefreshToken](mongoClient, dbName, refreshCollection)
+	return func(c echo.Context) error {
+		dto := new(modelos.CambioEstadoDto)
+
+		// Bindear el JSON
+		if err := c.Bind(dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Error al procesar el JSON: " + err.Error()})
+		}
+
+		// Validación de campos
+		if err := validaciones.ValidarCambioEstado(*dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		usuario, err := usuarioDelParametro(c, usuarios)
+		if err != nil {
+			return respuestaUsuarioDelParametro(c, err)
+		}
+
+		if dto.Estado == modelos.EstadoDeshabilitado {
+			if esUsuarioDelToken(c, usuario) {
+				return c.JSON(http.StatusConflict, map[string]string{"error": "No puedes deshabilitar tu propia cuenta"})
+			}
+			if usuario.RolEfectivo() == modelos.RolAdmin {
+				quedan, err := quedanOtrosAdmins(context.TODO(), usuarios, usuario)
+				if err != nil {
+					return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+				}
+				if !quedan {
+					return c.JSON(http.StatusConflict, map[string]string{"error": "No se puede deshabilitar al último administrador"})
+				}
+			}
+		}
+
+		if _, err := usuarios.Update(context.TODO(), usuario.ID.Hex(), bson.M{"estado": dto.Estado}); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al actualizar el usuario: " + err.Error()})
+		}
+		if dto.Estado == modelos.EstadoDeshabilitado {
+			if err := invalidarTokensUsuario(context.TODO(), usuarios, refreshTokens, usuario.ID); err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al invalidar los tokens: " + err.Error()})
+			}
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{
+			"mensaje": "Estado actualizado correctamente",
+			"estado":  "ok",
+		})
+	}
+}
+
+// ForzarResetPassword obliga al usuario a crear una contraseña nueva: cierra sus sesiones,
+// bloquea el login con la contraseña actual y le envía el enlace de recuperación
+func ForzarResetPassword(mongoClient *database.MongoDBClient, dbName, usuariosCollection, refreshCollection, resetsCollection string, mailer correo.Mailer) echo.HandlerFunc {
+	usuarios := database.NewRepository[modelos.UsuarioDto](mongoClient, dbName, usuariosCollection)
+	refreshTokens := database.NewRepository[modelos.RefreshToken](mongoClient, dbName, refreshCollection)
+	resets := database.NewRepository[modelos.PasswordReset](mongoClient, dbName, resetsCollection)
+	return func(c echo.Context) error {
+		usuario, err := usuarioDelParametro(c, usuarios)
+		if err != nil {
+			return respuestaUsuarioDelParametro(c, err)
+		}
+
+		if _, err := usuarios.Update(context.TODO(), usuario.ID.Hex(), bson.M{"reset_requerido": true}); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al actualizar el usuario: " + err.Error()})
+		}
+		if err := invalidarTokensUsuario(context.TODO(), usuarios, refreshTokens, usuario.ID); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al invalidar los tokens: " + err.Error()})
+		}
+
+		// Si el correo falla el usuario aún puede pedir la recuperación por su cuenta
+		correoEnviado := true
+		if err := crearResetPassword(context.TODO(), resets, mailer, usuario); err != nil {
+			log.Printf("Error al enviar la recuperación de contraseña a %s: %v", usuario.Correo, err)
+			correoEnviado = false
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"mensaje":        "El usuario deberá restablecer su contraseña",
+			"estado":         "ok",
+			"correo_enviado": correoEnviado,
+		})
+	}
+}
+
+// RevocarTokensUsuario cierra todas las sesiones del usuario
+func RevocarTokensUsuario(mongoClient *database.MongoDBClient, dbName, usuariosCollection, refreshCollection string) echo.HandlerFunc {
+	usuarios := database.NewRepository[modelos.UsuarioDto](mongoClient, dbName, usuariosCollection)
+	refreshTokens := database.NewRepository[modelos.RefreshToken](mongoClient, dbName, refreshCollection)
+	return func(c echo.Context) error {
+		usuario, err := usuarioDelParametro(c, usuarios)
+		if err != nil {
+			return respuestaUsuarioDelParametro(c, err)
+		}
+
+		if err := invalidarTokensUsuario(context.TODO(), usuarios, refreshTokens, usuario.ID); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al invalidar los tokens: " + err.Error()})
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{
+			"mensaje": "Se cerraron todas las sesiones del usuario",
+			"estado":  "ok",
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package rutas
+
+import (
+	"clase_6_echo_mongo/correo"
+	"clase_6_echo_mongo/database"
+	"clase_6_echo_mongo/modelos"
+	"clase_6_echo_mongo/utilidades"
+	"clase_6_echo_mongo/validaciones"
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	echo "github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Vigencia del enlace de verificación y tiempo mínimo entre reenvíos
+const (
+	duracionVerificacion = 24 * time.Hour
+	esperaReenvio        = time.Minute
+)
+
+// verificacionObligatoria indica si el login se rechaza mientras el correo no esté confirmado.
+// Con VERIFICACION_OBLIGATORIA distinto de "true" solo se informa en la respuesta del login.
+func verificacionObligatoria() bool {
+	obligatoria, _ := strconv.ParseBool(os.Getenv("VERIFICACION_OBLIGATORIA"))
+	return obligatoria
+}
+
+// enviarVerificacion invalida los tokens pendientes del usuario, genera uno nuevo y lo envía al correo indicado
+func enviarVerificacion(ctx context.Context, verificaciones *database.Repository[modelos.VerificacionCorreo], mailer correo.Mailer, usuario *modelos.UsuarioDto, destino string) error {
+	_, err := verificaciones.Collection().UpdateMany(ctx, bson.M{"usuario_id": usuario.ID, "usado": false}, bson.M{"$set": bson.M{"usado": true}})
+	if err != nil {
+		return err
+	}
+
+	token, err := utilidades.GenerarTokenAleatorio(32)
+	if err != nil {
+		return err
+	}
+	_, err = verificaciones.Insert(ctx, &modelos.VerificacionCorreo{
+		Hash:      utilidades.HashToken(token),
+		UsuarioID: usuario.ID,
+		Correo:    destino,
+		Expira:    time.Now().Add(duracionVerificacion),
+		Timestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return mailer.Enviar(ctx, correo.Mensaje{
+		Para:   destino,
+		Asunto: "Confirma tu correo",
+		Cuerpo: "Hola " + usuario.Nombre + ",\n\n" +
+			"Para confirmar tu correo ingresa al siguiente enlace, válido por 24 horas:\n" +
+			urlConToken("URL_VERIFICACION", "http://localhost:8086/api/v1/seguridad/verificar", token) + "\n\n" +
+			"Si no creaste una cuenta puedes ignorar este mensaje.",
+	})
+}
+
+func VerificarCorreo(mongoClient *database.MongoDBClient, dbName, usuariosCollection, verificacionesCollection string) echo.HandlerFunc {
+	usuarios := database.NewRepository[modelos.UsuarioDto](mongoClient, dbName, usuariosCollection)
+	verificaciones := database.NewRepository[modelos.VerificacionCorreo](mongoClient, dbName, verificacionesCollection)
+	return func(c echo.Context) error {
+		token := strings.TrimSpace(c.QueryParam("token"))
+		if token == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "El parámetro 'token' es requerido"})
+		}
+
+		// El token se consume de forma atómica para que no se pueda usar dos veces
+		filter := bson.M{
+			"hash":   utilidades.HashToken(token),
+			"usado":  false,
+			"expira": bson.M{"$gt": time.Now()},
+		}
+		verificacion := new(modelos.VerificacionCorreo)
+		err := verificaciones.Collection().FindOneAndUpdate(context.TODO(), filter, bson.M{"$set": bson.M{"usado": true}}).Decode(verificacion)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "El enlace de verificación es inválido o expiró"})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+
+		// Si el enlace es de un cambio de correo, la dirección no puede haber sido tomada mientras tanto
+		ocupado, err := usuarios.Count(context.TODO(), bson.M{"correo": verificacion.Correo, "_id": bson.M{"$ne": verificacion.UsuarioID}})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+		if ocupado > 0 {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "El correo ya está registrado por otra cuenta"})
+		}
+
+		// Solo se confirma si el enlace corresponde al correo actual o al pendiente de cambio
+		resultado, err := usuarios.Collection().UpdateOne(context.TODO(),
+			bson.M{"_id": verificacion.UsuarioID, "$or": bson.A{
+				bson.M{"correo": verificacion.Correo},
+				bson.M{"correo_pendiente": verificacion.Correo},
+			}},
+			bson.M{
+				"$set":   bson.M{"correo": verificacion.Correo, "verificado": true},
+				"$unset": bson.M{"correo_pendiente": ""},
+			},
+		)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error al actualizar el usuario: " + err.Error()})
+		}
+		if resultado.MatchedCount == 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "El enlace de verificación es inválido o expiró"})
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{
+			"mensaje": "Correo verificado correctamente",
+			"estado":  "ok",
+		})
+	}
+}
+
+func ReenviarVerificacion(mongoClient *database.MongoDBClient, dbName, usuariosCollection, verificacionesCollection string, mailer correo.Mailer) echo.HandlerFunc {
+	usuarios := database.NewRepository[modelos.UsuarioDto](mongoClient, dbName, usuariosCollection)
+	verificaciones := database.NewRepository[modelos.VerificacionCorreo](mongoClient, dbName, verificacionesCollection)
+	return func(c echo.Context) error {
+		dto := new(modelos.CorreoDto)
+
+		// Bindear el JSON
+		if err := c.Bind(dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Error al procesar el JSON: " + err.Error()})
+		}
+
+		// Validación de campos
+		if err := validaciones.ValidarCorreo(*dto); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		// La respuesta es la misma exista o no el correo, para no revelar qué cuentas existen
+		respuesta := map[string]string{
+			"mensaje": "Si el correo está registrado y pendiente de verificación recibirás un nuevo enlace",
+			"estado":  "ok",
+		}
+
+		usuario, err := usuarios.FindOne(context.TODO(), bson.M{"correo": dto.Correo})
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return c.JSON(http.StatusOK, respuesta)
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+		if usuario.EstaVerificado() {
+			return c.JSON(http.StatusOK, respuesta)
+		}
+
+		// Limitar los reenvíos según el último enlace generado para el usuario
+		ultimos, err := verificaciones.Find(context.TODO(), bson.M{"usuario_id": usuario.ID},
+			options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}).SetLimit(1))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "error al consultar la base de datos: " + err.Error()})
+		}
+		if len(ultimos) > 0 {
+			restante := time.Until(time.Unix(ultimos[0].Timestamp, 0).Add(esperaReenvio))
+			if restante > 0 {
+				segundos := int(math.Ceil(restante.Seconds()))
+				c.Response().Header().Set("Retry-After", strconv.Itoa(segundos))
+				return c.JSON(http.StatusTooManyRequests, map[string]interface{}{
+					"error":     "Debes esperar antes de solicitar un nuevo enlace",
+					"reintento": segundos,
+				})
+			}
+		}
+
+		if err := enviarVerificacion(context.TODO(), verificaciones, mailer, usuario, usuario.Correo); err != nil {
+			log.Printf("Error al reenviar la verificación a %s: %v", usuario.Correo, err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "No se pudo enviar el correo de verificación"})
+		}
+
+		return c.JSON(http.StatusOK, respuesta)
+	}
+}
@@ -0,0 +1,83 @@
+package utilidades
+
+import (
+	"clase_6_echo_mongo/config"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// LimitesLogin configura la protección contra fuerza bruta del login
+type LimitesLogin struct {
+	MaxFallosCuenta int           // Fallos seguidos de una cuenta antes de bloquearla
+	MaxFallosIP     int           // Fallos seguidos desde una IP antes de bloquearla
+	Ventana         time.Duration // Sin fallos durante este tiempo el contador vuelve a cero
+	Bloqueo         time.Duration // Duración del primer bloqueo, se duplica con cada fallo siguiente
+	BloqueoMaximo   time.Duration // Tope del bloqueo progresivo
+}
+
+// LimitesLoginDesdeEntorno lee LOGIN_MAX_FALLOS, LOGIN_MAX_FALLOS_IP, LOGIN_VENTANA,
+// LOGIN_BLOQUEO y LOGIN_BLOQUEO_MAXIMO (duraciones como "15m")
+func LimitesLoginDesdeEntorno() (LimitesLogin, error) {
+	limites := LimitesLogin{
+		MaxFallosCuenta: 5,
+		MaxFallosIP:     20,
+		Ventana:         15 * time.Minute,
+		Bloqueo:         time.Minute,
+		BloqueoMaximo:   time.Hour,
+	}
+
+	enteros := map[string]*int{
+		"LOGIN_MAX_FALLOS":    &limites.MaxFallosCuenta,
+		"LOGIN_MAX_FALLOS_IP": &limites.MaxFallosIP,
+	}
+	for variable, destino := range enteros {
+		if valor := os.Getenv(variable); valor != "" {
+			numero, err := strconv.Atoi(valor)
+			if err != nil || numero <= 0 {
+				return limites, fmt.Errorf("%s debe ser un número mayor que 0", variable)
+			}
+			*destino = numero
+		}
+	}
+
+	duraciones := map[string]*time.Duration{
+		"LOGIN_VENTANA":        &limites.Ventana,
+		"LOGIN_BLOQUEO":        &limites.Bloqueo,
+		"LOGIN_BLOQUEO_MAXIMO": &limites.BloqueoMaximo,
+	}
+	for variable, destino := range duraciones {
+		if valor := os.Getenv(variable); valor != "" {
+			duracion, err := time.ParseDuration(valor)
+			if err != nil || duracion <= 0 {
+				return limites, fmt.Errorf("%s debe ser una duración mayor que 0, ej. 15m", variable)
+			}
+			*destino = duracion
+		}
+	}
+
+	if limites.BloqueoMaximo < limites.Bloqueo {
+		return limites, fmt.Errorf("LOGIN_BLOQUEO_MAXIMO no puede ser menor que LOGIN_BLOQUEO")
+	}
+	if limites.Ventana > config.RetencionIntentosLogin || limites.BloqueoMaximo > config.RetencionIntentosLogin {
+		return limites, fmt.Errorf("LOGIN_VENTANA y LOGIN_BLOQUEO_MAXIMO no pueden superar %s, la retención de intentos_login", config.RetencionIntentosLogin)
+	}
+	return limites, nil
+}
+
+// DuracionBloqueo calcula el bloqueo para la cantidad de fallos acumulados:
+// cero bajo el máximo, y desde ahí Bloqueo, 2×Bloqueo, 4×Bloqueo... hasta BloqueoMaximo
+func (l LimitesLogin) DuracionBloqueo(fallos, maximo int) time.Duration {
+	if fallos < maximo {
+		return 0
+	}
+	duracion := l.Bloqueo
+	for i := maximo; i < fallos && duracion < l.BloqueoMaximo; i++ {
+		duracion *= 2
+	}
+	if duracion > l.BloqueoMaximo {
+		duracion = l.BloqueoMaximo
+	}
+	return duracion
+}
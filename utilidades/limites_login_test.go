@@ -0,0 +1,63 @@
+package utilidades
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDuracionBloqueo(t *testing.T) {
+	limites := LimitesLogin{Bloqueo: time.Minute, BloqueoMaximo: 10 * time.Minute}
+	casos := []struct {
+		fallos   int
+		esperada time.Duration
+	}{
+		{fallos: 0, esperada: 0},
+		{fallos: 4, esperada: 0},
+		{fallos: 5, esperada: time.Minute},
+		{fallos: 6, esperada: 2 * time.Minute},
+		{fallos: 8, esperada: 8 * time.Minute},
+		{fallos: 9, esperada: 10 * time.Minute}, // 16 minutos superaría el tope
+		{fallos: 1000, esperada: 10 * time.Minute},
+	}
+	for _, caso := range casos {
+		if duracion := limites.DuracionBloqueo(caso.fallos, 5); duracion != caso.esperada {
+			t.Errorf("DuracionBloqueo(%d, 5) = %s, se esperaba %s", caso.fallos, duracion, caso.esperada)
+		}
+	}
+}
+
+func TestLimitesLoginDesdeEntorno(t *testing.T) {
+	casos := []struct {
+		nombre  string
+		entorno map[string]string
+		error   string // "" si la configuración es válida
+	}{
+		{nombre: "por defecto"},
+		{nombre: "valores propios", entorno: map[string]string{"LOGIN_MAX_FALLOS": "3", "LOGIN_VENTANA": "1h", "LOGIN_BLOQUEO_MAXIMO": "2h"}},
+		{nombre: "fallos no numéricos", entorno: map[string]string{"LOGIN_MAX_FALLOS": "muchos"}, error: "LOGIN_MAX_FALLOS"},
+		{nombre: "fallos en cero", entorno: map[string]string{"LOGIN_MAX_FALLOS_IP": "0"}, error: "LOGIN_MAX_FALLOS_IP"},
+		{nombre: "duración inválida", entorno: map[string]string{"LOGIN_BLOQUEO": "5"}, error: "LOGIN_BLOQUEO"},
+		{nombre: "duración negativa", entorno: map[string]string{"LOGIN_VENTANA": "-1m"}, error: "LOGIN_VENTANA"},
+		{nombre: "máximo menor que el bloqueo", entorno: map[string]string{"LOGIN_BLOQUEO": "2h", "LOGIN_BLOQUEO_MAXIMO": "1h"}, error: "menor"},
+		{nombre: "ventana mayor que la retención", entorno: map[string]string{"LOGIN_VENTANA": "48h"}, error: "retención"},
+		{nombre: "bloqueo mayor que la retención", entorno: map[string]string{"LOGIN_BLOQUEO_MAXIMO": "25h"}, error: "retención"},
+	}
+	for _, caso := range casos {
+		t.Run(caso.nombre, func(t *testing.T) {
+			for _, variable := range []string{"LOGIN_MAX_FALLOS", "LOGIN_MAX_FALLOS_IP", "LOGIN_VENTANA", "LOGIN_BLOQUEO", "LOGIN_BLOQUEO_MAXIMO"} {
+				t.Setenv(variable, caso.entorno[variable])
+			}
+			_, err := LimitesLoginDesdeEntorno()
+			if caso.error == "" {
+				if err != nil {
+					t.Fatalf("LimitesLoginDesdeEntorno: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), caso.error) {
+				t.Fatalf("error = %v, se esperaba uno con %q", err, caso.error)
+			}
+		})
+	}
+}
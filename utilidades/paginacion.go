@@ -0,0 +1,196 @@
+package utilidades
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	LimitePorDefecto = 20
+	LimiteMaximo     = 100
+)
+
+// CampoOrden representa un campo de ordenamiento, ej. "-precio" => {Campo: "precio", Desc: true}
+type CampoOrden struct {
+	Campo string
+	Desc  bool
+}
+
+// Paginacion agrupa los valores de ?page=, ?limit= y ?after= ya validados
+type Paginacion struct {
+	Pagina int64
+	Limite int64
+	Cursor bson.D // Valores del último documento entregado, solo en modo cursor
+}
+
+// LeerPaginacion valida los parámetros page, limit y after de la query string
+func LeerPaginacion(page, limit, after string) (Paginacion, error) {
+	p := Paginacion{Pagina: 1, Limite: LimitePorDefecto}
+
+	if page != "" {
+		valor, err := strconv.ParseInt(page, 10, 64)
+		if err != nil || valor < 1 {
+			return p, errors.New("el parámetro 'page' debe ser un entero mayor que 0")
+		}
+		p.Pagina = valor
+	}
+
+	if limit != "" {
+		valor, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || valor < 1 || valor > LimiteMaximo {
+			return p, errors.New("el parámetro 'limit' debe ser un entero entre 1 y " + strconv.Itoa(LimiteMaximo))
+		}
+		p.Limite = valor
+	}
+
+	if after != "" {
+		// El cursor ya fija la posición; combinarlo con page saltaría documentos
+		if page != "" {
+			return p, errors.New("los parámetros 'page' y 'after' no se pueden usar juntos")
+		}
+		cursor, err := DecodificarCursor(after)
+		if err != nil {
+			return p, err
+		}
+		p.Cursor = cursor
+	}
+
+	return p, nil
+}
+
+// Skip devuelve cuántos documentos omitir. En modo cursor siempre es 0.
+func (p Paginacion) Skip() int64 {
+	if p.Cursor != nil {
+		return 0
+	}
+	return (p.Pagina - 1) * p.Limite
+}
+
+// LeerOrden interpreta ?sort=precio,-timestamp usando solo los campos permitidos.
+// Siempre agrega "_id" al final para que el orden sea estable entre páginas.
+func LeerOrden(sort string, permitidos map[string]bool, porDefecto []CampoOrden) ([]CampoOrden, error) {
+	var orden []CampoOrden
+	for _, parte := range strings.Split(sort, ",") {
+		parte = strings.TrimSpace(parte)
+		if parte == "" {
+			continue
+		}
+		campo := CampoOrden{Campo: parte}
+		if strings.HasPrefix(parte, "-") {
+			campo = CampoOrden{Campo: parte[1:], Desc: true}
+		}
+		if !permitidos[campo.Campo] {
+			return nil, errors.New("no se puede ordenar por el campo '" + campo.Campo + "'")
+		}
+		orden = append(orden, campo)
+	}
+
+	if len(orden) == 0 {
+		orden = append(orden, porDefecto...)
+	}
+
+	for _, campo := range orden {
+		if campo.Campo == "_id" {
+			return orden, nil
+		}
+	}
+	return append(orden, CampoOrden{Campo: "_id", Desc: orden[len(orden)-1].Desc}), nil
+}
+
+// EtapaSort construye el documento para la etapa $sort
+func EtapaSort(orden []CampoOrden) bson.D {
+	sort := bson.D{}
+	for _, campo := range orden {
+		direccion := 1
+		if campo.Desc {
+			direccion = -1
+		}
+		sort = append(sort, bson.E{Key: campo.Campo, Value: direccion})
+	}
+	return sort
+}
+
+// FiltroCursor arma la condición para continuar después del último documento entregado:
+// (a > x) OR (a == x AND b > y) OR ... respetando la dirección de cada campo
+func FiltroCursor(orden []CampoOrden, cursor bson.D) (bson.M, error) {
+	if len(cursor) != len(orden) {
+		return nil, errors.New("el cursor no corresponde al orden solicitado")
+	}
+	for i, campo := range orden {
+		if cursor[i].Key != campo.Campo {
+			return nil, errors.New("el cursor no corresponde al orden solicitado")
+		}
+	}
+
+	var condiciones []bson.M
+	for i, campo := range orden {
+		condicion := bson.M{}
+		for j := 0; j < i; j++ {
+			condicion[orden[j].Campo] = cursor[j].Value
+		}
+		operador := "$gt"
+		if campo.Desc {
+			operador = "$lt"
+		}
+		condicion[campo.Campo] = bson.M{operador: cursor[i].Value}
+		condiciones = append(condiciones, condicion)
+	}
+
+	return bson.M{"$or": condiciones}, nil
+}
+
+// CodificarCursor toma los valores de orden del último documento y los serializa como texto opaco.
+// El documento puede ser un bson.M o cualquier struct con etiquetas bson.
+func CodificarCursor(orden []CampoOrden, documento interface{}) (string, error) {
+	datos, err := bson.Marshal(documento)
+	if err != nil {
+		return "", err
+	}
+	var campos bson.M
+	if err := bson.Unmarshal(datos, &campos); err != nil {
+		return "", err
+	}
+
+	valores := bson.D{}
+	for _, campo := range orden {
+		valores = append(valores, bson.E{Key: campo.Campo, Value: campos[campo.Campo]})
+	}
+	datos, err = bson.Marshal(valores)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(datos), nil
+}
+
+// DecodificarCursor revierte CodificarCursor
+func DecodificarCursor(cursor string) (bson.D, error) {
+	datos, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errors.New("el parámetro 'after' no es un cursor válido")
+	}
+	var valores bson.D
+	if err := bson.Unmarshal(datos, &valores); err != nil || len(valores) == 0 {
+		return nil, errors.New("el parámetro 'after' no es un cursor válido")
+	}
+	// Los valores van directo a los filtros: un documento como {$ne: null} se interpretaría como operador
+	for _, valor := range valores {
+		if !valorEscalar(valor.Value) {
+			return nil, errors.New("el parámetro 'after' no es un cursor válido")
+		}
+	}
+	return valores, nil
+}
+
+// valorEscalar acepta solo los tipos BSON que CodificarCursor puede producir para un campo de orden
+func valorEscalar(valor interface{}) bool {
+	switch valor.(type) {
+	case nil, string, bool, int32, int64, float64, primitive.DateTime, primitive.ObjectID, primitive.Decimal128:
+		return true
+	}
+	return false
+}
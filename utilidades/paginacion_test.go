@@ -0,0 +1,130 @@
+package utilidades
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCursorIdaYVuelta(t *testing.T) {
+	orden := []CampoOrden{{Campo: "precio", Desc: true}, {Campo: "timestamp"}, {Campo: "_id", Desc: true}}
+	id := primitive.NewObjectID()
+	documento := struct {
+		ID        primitive.ObjectID `bson:"_id"`
+		Nombre    string             `bson:"nombre"`
+		Precio    float64            `bson:"precio"`
+		Timestamp time.Time          `bson:"timestamp"`
+	}{ID: id, Nombre: "no va en el cursor", Precio: 9.5, Timestamp: time.UnixMilli(1700000000000)}
+
+	cursor, err := CodificarCursor(orden, documento)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valores, err := DecodificarCursor(cursor)
+	if err != nil {
+		t.Fatalf("DecodificarCursor: %v", err)
+	}
+	esperados := bson.D{
+		{Key: "precio", Value: 9.5},
+		{Key: "timestamp", Value: primitive.DateTime(1700000000000)},
+		{Key: "_id", Value: id},
+	}
+	if len(valores) != len(esperados) {
+		t.Fatalf("cursor = %v, se esperaba %v", valores, esperados)
+	}
+	for i := range esperados {
+		if valores[i] != esperados[i] {
+			t.Fatalf("cursor = %v, se esperaba %v", valores, esperados)
+		}
+	}
+
+	filtro, err := FiltroCursor(orden, valores)
+	if err != nil {
+		t.Fatalf("FiltroCursor: %v", err)
+	}
+	condiciones := filtro["$or"].([]bson.M)
+	if len(condiciones) != 3 {
+		t.Fatalf("FiltroCursor devolvió %v", filtro)
+	}
+	ultima := condiciones[2]
+	if ultima["precio"] != 9.5 || ultima["_id"].(bson.M)["$lt"] != id {
+		t.Fatalf("la última condición no respeta el orden: %v", ultima)
+	}
+
+	// Un cursor de otro orden no se acepta
+	if _, err := FiltroCursor(orden[1:], valores); err == nil {
+		t.Fatal("FiltroCursor aceptó un cursor de otro orden")
+	}
+}
+
+func TestDecodificarCursorInvalido(t *testing.T) {
+	codificar := func(documento interface{}) string {
+		datos, err := bson.Marshal(documento)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(datos)
+	}
+	casos := map[string]string{
+		"no es base64":       "%%%",
+		"no es BSON":         base64.RawURLEncoding.EncodeToString([]byte("texto")),
+		"documento vacío":    codificar(bson.D{}),
+		"operador en valor":  codificar(bson.D{{Key: "precio", Value: bson.M{"$ne": nil}}}),
+		"arreglo como valor": codificar(bson.D{{Key: "precio", Value: bson.A{1, 2}}}),
+	}
+	for nombre, cursor := range casos {
+		t.Run(nombre, func(t *testing.T) {
+			if _, err := DecodificarCursor(cursor); err == nil {
+				t.Fatalf("se aceptó el cursor %q", cursor)
+			}
+		})
+	}
+}
+
+func TestLeerPaginacion(t *testing.T) {
+	cursor, err := CodificarCursor([]CampoOrden{{Campo: "_id"}}, bson.M{"_id": primitive.NewObjectID()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	casos := []struct {
+		nombre             string
+		page, limit, after string
+		pagina, limite     int64
+		skip               int64
+		error              string // "" si los parámetros son válidos
+	}{
+		{nombre: "por defecto", pagina: 1, limite: LimitePorDefecto},
+		{nombre: "página y límite", page: "3", limit: "10", pagina: 3, limite: 10, skip: 20},
+		{nombre: "cursor", after: cursor, limit: "5", pagina: 1, limite: 5},
+		{nombre: "página en cero", page: "0", error: "page"},
+		{nombre: "página no numérica", page: "dos", error: "page"},
+		{nombre: "límite sobre el máximo", limit: "101", error: "limit"},
+		{nombre: "página y cursor juntos", page: "2", after: cursor, error: "no se pueden usar juntos"},
+		{nombre: "página 1 y cursor juntos", page: "1", after: cursor, error: "no se pueden usar juntos"},
+		{nombre: "cursor inválido", after: "%%%", error: "after"},
+	}
+	for _, caso := range casos {
+		t.Run(caso.nombre, func(t *testing.T) {
+			p, err := LeerPaginacion(caso.page, caso.limit, caso.after)
+			if caso.error != "" {
+				if err == nil || !strings.Contains(err.Error(), caso.error) {
+					t.Fatalf("error = %v, se esperaba uno con %q", err, caso.error)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LeerPaginacion: %v", err)
+			}
+			if p.Pagina != caso.pagina || p.Limite != caso.limite || p.Skip() != caso.skip {
+				t.Fatalf("paginación = %+v (skip %d), se esperaba página %d, límite %d y skip %d", p, p.Skip(), caso.pagina, caso.limite, caso.skip)
+			}
+			if (caso.after != "") != (p.Cursor != nil) {
+				t.Fatalf("cursor = %v con after %q", p.Cursor, caso.after)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package utilidades
+
+import (
+	"clase_6_echo_mongo/database"
+	"clase_6_echo_mongo/modelos"
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrArchivoBorrandose indica que el mismo contenido se está borrando porque se eliminó su última foto
+var ErrArchivoBorrandose = errors.New("El mismo contenido se está eliminando, vuelve a intentarlo en unos segundos")
+
+// TomarReferencia suma una foto a las que usan el archivo. Mientras la referencia exista nadie borra el
+// archivo; si su borrado ya empezó devuelve ErrArchivoBorrandose.
+func TomarReferencia(ctx context.Context, archivos *database.Repository[modelos.ReferenciaArchivo], nombre string) error {
+	_, err := archivos.Collection().UpdateOne(ctx,
+		bson.M{"_id": nombre, "borrando": bson.M{"$ne": true}},
+		bson.M{"$inc": bson.M{"referencias": 1}},
+		options.Update().SetUpsert(true),
+	)
+	// El upsert choca con el _id cuando el documento existe pero está marcado como borrando
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrArchivoBorrandose
+	}
+	return err
+}
+
+// SoltarReferencia resta la foto de las que usan el archivo. Devuelve true si era la última: el archivo
+// queda reclamado para borrarlo y el llamador debe terminar con TerminarBorrado. Los archivos anteriores
+// a los contadores no tienen documento y se conservan; gc los limpia si quedan huérfanos.
+func SoltarReferencia(ctx context.Context, archivos *database.Repository[modelos.ReferenciaArchivo], nombre string) (bool, error) {
+	var referencia modelos.ReferenciaArchivo
+	err := archivos.Collection().FindOneAndUpdate(ctx,
+		bson.M{"_id": nombre, "referencias": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"referencias": -1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&referencia)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if referencia.Referencias > 0 {
+		return false, nil
+	}
+	return ReclamarBorrado(ctx, archivos, nombre)
+}
+
+// ReclamarBorrado marca como borrando un archivo sin referencias. Devuelve false si otra foto volvió a
+// usarlo o si otro proceso ya lo está borrando.
+func ReclamarBorrado(ctx context.Context, archivos *database.Repository[modelos.ReferenciaArchivo], nombre string) (bool, error) {
+	resultado, err := archivos.Collection().UpdateOne(ctx,
+		bson.M{"_id": nombre, "referencias": bson.M{"$lte": 0}, "borrando": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"borrando": true}},
+	)
+	if err != nil {
+		return false, err
+	}
+	if resultado.MatchedCount > 0 {
+		return true, nil
+	}
+
+	// Sin documento el archivo es anterior a los contadores y nadie lo referencia: se reclama creándolo
+	_, err = archivos.Collection().InsertOne(ctx, modelos.ReferenciaArchivo{Nombre: nombre, Borrando: true})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// TerminarBorrado cierra un borrado reclamado. Si los archivos se borraron el contador desaparece; si no,
+// se libera la marca para que el reintento lo vuelva a reclamar.
+func TerminarBorrado(ctx context.Context, archivos *database.Repository[modelos.ReferenciaArchivo], nombre string, borrados bool) error {
+	filtro := bson.M{"_id": nombre, "borrando": true}
+	if borrados {
+		_, err := archivos.Collection().DeleteOne(ctx, filtro)
+		return err
+	}
+	_, err := archivos.Collection().UpdateOne(ctx, filtro, bson.M{"$unset": bson.M{"borrando": ""}})
+	return err
+}
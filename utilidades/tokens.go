@@ -0,0 +1,23 @@
+package utilidades
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// GenerarTokenAleatorio devuelve un token opaco con la cantidad de bytes aleatorios indicada
+func GenerarTokenAleatorio(bytes int) (string, error) {
+	buf := make([]byte, bytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashToken es lo que se guarda en la base de datos en lugar del token entregado al cliente
+func HashToken(token string) string {
+	suma := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(suma[:])
+}
@@ -1,56 +1,95 @@
 package utilidades
 
 import (
-	"io"
+	"bytes"
+	"clase_6_echo_mongo/almacenamiento"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"mime/multipart"
-	"os"
-	"path/filepath"
-	"strconv"
-	"strings"
-	"time"
+	"net/http"
 )
 
-const rutaLocal = "public/uploads/productos"
+// ArchivoSubido resume lo que quedó guardado para una foto
+type ArchivoSubido struct {
+	Nombre    string
+	Variantes map[string]string // Nombre de la variante => nombre del archivo
+}
+
+// ArchivoPreparado es una imagen ya validada y con su nombre definitivo, lista para guardarse
+type ArchivoPreparado struct {
+	Nombre string // Hash SHA-256 del contenido + extensión del tipo detectado
+	Tipo   string
+	Datos  []byte
+}
 
-func SubirArchivo(file *multipart.FileHeader) (map[string]string, error) {
-	// Obtiene el nombre original del archivo
-	src, err := file.Open()
+// PrepararArchivo valida la imagen según los límites y le asigna un nombre derivado de su contenido.
+// Dos subidas de la misma imagen producen el mismo nombre, así el archivo se puede reutilizar.
+// Los rechazos por contenido, tamaño o dimensiones se devuelven como *ErrorArchivo.
+func PrepararArchivo(limites LimitesSubida, file *multipart.FileHeader) (*ArchivoPreparado, error) {
+	datos, tipo, extension, err := limites.LeerImagen(file)
 	if err != nil {
-		return map[string]string{
-			"error": "Erro al abrir el archivo",
-		}, err
+		return nil, err
 	}
-	defer src.Close()
 
-	// Renombramos el archivo
-	var extension = strings.Split(file.Filename, ".")[1]
-	unixTime := time.Now().Unix()
-	nomnbreArchivo := strconv.FormatInt(unixTime, 10) + "." + extension
-
-	// Path destino del archivo
-	dstPath := filepath.Join(rutaLocal, nomnbreArchivo)
+	hash := sha256.Sum256(datos)
+	return &ArchivoPreparado{
+		Nombre: hex.EncodeToString(hash[:]) + "." + extension,
+		Tipo:   tipo,
+		Datos:  datos,
+	}, nil
+}
 
-	dst, err := os.Create(dstPath)
+// SubirArchivo guarda el original junto a las variantes configuradas. Si falla una variante devuelve
+// también lo que alcanzó a guardar, sin borrarlo: otra subida concurrente del mismo contenido puede
+// estar usando esos archivos, y solo quien conoce las referencias puede decidir si eliminarlos.
+func SubirArchivo(ctx context.Context, storage almacenamiento.Storage, variantes ConfigVariantes, archivo *ArchivoPreparado) (*ArchivoSubido, error) {
+	// Las variantes se generan antes de guardar nada, así una imagen corrupta no deja archivos
+	redimensionadas, err := RedimensionarImagen(archivo.Datos, variantes)
 	if err != nil {
-		return map[string]string{
-			"error": "Erro al crear el archivo",
-		}, err
+		if errors.Is(err, ErrImagenInvalida) {
+			return nil, &ErrorArchivo{Codigo: http.StatusUnprocessableEntity, Motivo: "imagen_invalida", Mensaje: err.Error()}
+		}
+		return nil, err
 	}
-	defer dst.Close()
 
-	if _, err = io.Copy(dst, src); err != nil {
-		return map[string]string{"error": "Erro al copiar el archivo"}, err
+	if err := storage.Put(ctx, archivo.Nombre, bytes.NewReader(archivo.Datos), archivo.Tipo); err != nil {
+		return nil, fmt.Errorf("error al guardar el archivo: %w", err)
 	}
 
-	return map[string]string{
-		"nombre": nomnbreArchivo,
-	}, nil
+	subido := &ArchivoSubido{Nombre: archivo.Nombre, Variantes: map[string]string{}}
+	for _, r := range redimensionadas {
+		nombre := NombreVariante(archivo.Nombre, r.Variante.Nombre, variantes.Extension())
+		if err := storage.Put(ctx, nombre, bytes.NewReader(r.Datos), variantes.ContentType()); err != nil {
+			return subido, fmt.Errorf("error al guardar la variante '%s': %w", r.Variante.Nombre, err)
+		}
+		subido.Variantes[r.Variante.Nombre] = nombre
+	}
+
+	return subido, nil
+}
+
+// Nombres devuelve el archivo original seguido de todas sus variantes
+func (a *ArchivoSubido) Nombres() []string {
+	nombres := []string{a.Nombre}
+	for _, nombre := range a.Variantes {
+		nombres = append(nombres, nombre)
+	}
+	return nombres
 }
 
-func EliminarArchivo(nombre string) (map[string]string, error) {
-	rutaArchivo := filepath.Join(rutaLocal, nombre)
-	if err := os.Remove(rutaArchivo); err != nil {
-		return map[string]string{"error": "Fallo eliminar archivo '" + nombre + "' o no existe en el servidor"}, err
+// EliminarArchivo elimina uno o más archivos. Los que ya no existen se ignoran salvo el primero,
+// que corresponde al archivo original.
+func EliminarArchivo(ctx context.Context, storage almacenamiento.Storage, nombres ...string) (map[string]string, error) {
+	for i, nombre := range nombres {
+		if err := storage.Delete(ctx, nombre); err != nil {
+			if i > 0 && err == almacenamiento.ErrNoExiste {
+				continue
+			}
+			return map[string]string{"error": "Fallo eliminar archivo '" + nombre + "' o no existe en el servidor"}, err
+		}
 	}
 	return nil, nil
 }
@@ -0,0 +1,178 @@
+package utilidades
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// ErrorArchivo es un rechazo del archivo subido junto al código HTTP que corresponde
+type ErrorArchivo struct {
+	Codigo  int                    // 413, 415 o 422
+	Motivo  string                 // Identificador estable para el cliente, ej. "tipo_no_permitido"
+	Mensaje string                 // Texto legible
+	Detalle map[string]interface{} // Datos adicionales, ej. el tipo detectado
+}
+
+func (e *ErrorArchivo) Error() string {
+	return e.Mensaje
+}
+
+// Respuesta arma el cuerpo JSON del error
+func (e *ErrorArchivo) Respuesta() map[string]interface{} {
+	respuesta := map[string]interface{}{
+		"error":  e.Mensaje,
+		"motivo": e.Motivo,
+	}
+	for clave, valor := range e.Detalle {
+		respuesta[clave] = valor
+	}
+	return respuesta
+}
+
+// LimitesSubida agrupa las restricciones que debe cumplir una foto
+type LimitesSubida struct {
+	TiposPermitidos map[string]string // Tipo MIME => extensión con la que se guarda
+	TamanoMaximo    int64             // En bytes
+	AnchoMaximo     int
+	AltoMaximo      int
+}
+
+// Tipos de imagen aceptados si no se define FOTOS_TIPOS_PERMITIDOS
+var tiposImagen = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/webp": "webp",
+	"image/gif":  "gif",
+}
+
+// LimitesSubidaDesdeEntorno lee FOTOS_TIPOS_PERMITIDOS ("image/jpeg,image/png"),
+// FOTOS_TAMANO_MAXIMO (bytes) y FOTOS_DIMENSION_MAXIMA ("6000x6000")
+func LimitesSubidaDesdeEntorno() (LimitesSubida, error) {
+	limites := LimitesSubida{
+		TiposPermitidos: tiposImagen,
+		TamanoMaximo:    4 << 20, // 4 MB, bajo el BodyLimit de 5M configurado en main
+		AnchoMaximo:     6000,
+		AltoMaximo:      6000,
+	}
+
+	if tipos := os.Getenv("FOTOS_TIPOS_PERMITIDOS"); tipos != "" {
+		limites.TiposPermitidos = map[string]string{}
+		for _, tipo := range strings.Split(tipos, ",") {
+			tipo = strings.ToLower(strings.TrimSpace(tipo))
+			extension, ok := tiposImagen[tipo]
+			if !ok {
+				return limites, fmt.Errorf("FOTOS_TIPOS_PERMITIDOS: el tipo '%s' no es una imagen soportada", tipo)
+			}
+			limites.TiposPermitidos[tipo] = extension
+		}
+	}
+
+	if tamano := os.Getenv("FOTOS_TAMANO_MAXIMO"); tamano != "" {
+		valor, err := strconv.ParseInt(tamano, 10, 64)
+		if err != nil || valor <= 0 {
+			return limites, fmt.Errorf("FOTOS_TAMANO_MAXIMO debe ser un número de bytes mayor que 0")
+		}
+		limites.TamanoMaximo = valor
+	}
+
+	if dimension := os.Getenv("FOTOS_DIMENSION_MAXIMA"); dimension != "" {
+		ancho, alto, ok := strings.Cut(strings.ToLower(dimension), "x")
+		anchoMaximo, errAncho := strconv.Atoi(ancho)
+		altoMaximo, errAlto := strconv.Atoi(alto)
+		if !ok || errAncho != nil || errAlto != nil || anchoMaximo <= 0 || altoMaximo <= 0 {
+			return limites, fmt.Errorf("FOTOS_DIMENSION_MAXIMA debe tener el formato ANCHOxALTO, ej. 6000x6000")
+		}
+		limites.AnchoMaximo, limites.AltoMaximo = anchoMaximo, altoMaximo
+	}
+
+	return limites, nil
+}
+
+// tipos devuelve la lista ordenada de tipos permitidos, para los mensajes de error
+func (l LimitesSubida) tipos() []string {
+	tipos := make([]string, 0, len(l.TiposPermitidos))
+	for tipo := range l.TiposPermitidos {
+		tipos = append(tipos, tipo)
+	}
+	sort.Strings(tipos)
+	return tipos
+}
+
+// LeerImagen lee el archivo respetando el tamaño máximo y valida su contenido real:
+// el tipo se detecta desde los bytes (no desde el nombre ni la cabecera enviada por el cliente)
+// y las dimensiones se leen sin decodificar la imagen completa.
+// Devuelve los datos, el tipo MIME detectado y la extensión que le corresponde.
+func (l LimitesSubida) LeerImagen(file *multipart.FileHeader) ([]byte, string, string, error) {
+	tamanoExcedido := &ErrorArchivo{
+		Codigo:  http.StatusRequestEntityTooLarge,
+		Motivo:  "tamano_excedido",
+		Mensaje: fmt.Sprintf("El archivo supera el tamaño máximo de %d bytes", l.TamanoMaximo),
+		Detalle: map[string]interface{}{"tamano_maximo": l.TamanoMaximo},
+	}
+	if file.Size > l.TamanoMaximo {
+		return nil, "", "", tamanoExcedido
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return nil, "", "", fmt.Errorf("error al abrir el archivo: %w", err)
+	}
+	defer src.Close()
+
+	// Se lee un byte más del límite para detectar archivos que mienten sobre su tamaño
+	datos, err := io.ReadAll(io.LimitReader(src, l.TamanoMaximo+1))
+	if err != nil {
+		return nil, "", "", fmt.Errorf("error al leer el archivo: %w", err)
+	}
+	if int64(len(datos)) > l.TamanoMaximo {
+		return nil, "", "", tamanoExcedido
+	}
+
+	tipo := mimetype.Detect(datos).String()
+	if corte := strings.Index(tipo, ";"); corte >= 0 {
+		tipo = tipo[:corte] // Sin parámetros como "; charset=utf-8"
+	}
+	extension, ok := l.TiposPermitidos[tipo]
+	if !ok {
+		return nil, "", "", &ErrorArchivo{
+			Codigo:  http.StatusUnsupportedMediaType,
+			Motivo:  "tipo_no_permitido",
+			Mensaje: "El tipo de archivo '" + tipo + "' no está permitido",
+			Detalle: map[string]interface{}{"tipo_detectado": tipo, "tipos_permitidos": l.tipos()},
+		}
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(datos))
+	if err != nil {
+		return nil, "", "", &ErrorArchivo{
+			Codigo:  http.StatusUnprocessableEntity,
+			Motivo:  "imagen_invalida",
+			Mensaje: ErrImagenInvalida.Error() + ": " + err.Error(),
+		}
+	}
+	if config.Width > l.AnchoMaximo || config.Height > l.AltoMaximo {
+		return nil, "", "", &ErrorArchivo{
+			Codigo:  http.StatusRequestEntityTooLarge,
+			Motivo:  "dimensiones_excedidas",
+			Mensaje: fmt.Sprintf("La imagen de %dx%d supera el máximo de %dx%d", config.Width, config.Height, l.AnchoMaximo, l.AltoMaximo),
+			Detalle: map[string]interface{}{
+				"ancho":        config.Width,
+				"alto":         config.Height,
+				"ancho_maximo": l.AnchoMaximo,
+				"alto_maximo":  l.AltoMaximo,
+			},
+		}
+	}
+
+	return datos, tipo, extension, nil
+}
@@ -0,0 +1,154 @@
+package utilidades
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"strconv"
+	"strings"
+
+	_ "image/gif" // Registra el decodificador GIF
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // Registra el decodificador WebP
+)
+
+// ErrImagenInvalida indica que el archivo subido no se pudo decodificar como imagen
+var ErrImagenInvalida = errors.New("el archivo no es una imagen válida")
+
+// Variante es un tamaño adicional que se genera para cada foto, ej. {Nombre: "thumb", Ancho: 150}
+type Variante struct {
+	Nombre string
+	Ancho  int
+}
+
+// ConfigVariantes indica qué variantes generar y en qué formato guardarlas
+type ConfigVariantes struct {
+	Variantes []Variante
+	Formato   string // jpeg, png o webp
+	Calidad   int    // Solo aplica a jpeg
+}
+
+// Extension devuelve la extensión de archivo del formato configurado
+func (cfg ConfigVariantes) Extension() string {
+	if cfg.Formato == "jpeg" {
+		return "jpg"
+	}
+	return cfg.Formato
+}
+
+// ContentType devuelve el tipo MIME del formato configurado
+func (cfg ConfigVariantes) ContentType() string {
+	return "image/" + cfg.Formato
+}
+
+// ConfigVariantesDesdeEntorno lee FOTOS_VARIANTES ("thumb:150,medium:600,large:1200"),
+// FOTOS_FORMATO (jpeg, png, webp) y FOTOS_CALIDAD (1-100)
+func ConfigVariantesDesdeEntorno() (ConfigVariantes, error) {
+	cfg := ConfigVariantes{Formato: "jpeg", Calidad: 85}
+
+	definicion := os.Getenv("FOTOS_VARIANTES")
+	if definicion == "" {
+		definicion = "thumb:150,medium:600,large:1200"
+	}
+	for _, parte := range strings.Split(definicion, ",") {
+		nombre, ancho, ok := strings.Cut(strings.TrimSpace(parte), ":")
+		valor, err := strconv.Atoi(ancho)
+		if !ok || nombre == "" || err != nil || valor <= 0 {
+			return cfg, fmt.Errorf("FOTOS_VARIANTES inválida en '%s', se espera nombre:ancho", parte)
+		}
+		cfg.Variantes = append(cfg.Variantes, Variante{Nombre: nombre, Ancho: valor})
+	}
+
+	if formato := strings.ToLower(os.Getenv("FOTOS_FORMATO")); formato != "" {
+		if formato == "jpg" {
+			formato = "jpeg"
+		}
+		if formato != "jpeg" && formato != "png" && formato != "webp" {
+			return cfg, fmt.Errorf("FOTOS_FORMATO '%s' no soportado (use jpeg, png o webp)", formato)
+		}
+		cfg.Formato = formato
+	}
+
+	if calidad := os.Getenv("FOTOS_CALIDAD"); calidad != "" {
+		valor, err := strconv.Atoi(calidad)
+		if err != nil || valor < 1 || valor > 100 {
+			return cfg, errors.New("FOTOS_CALIDAD debe ser un entero entre 1 y 100")
+		}
+		cfg.Calidad = valor
+	}
+
+	return cfg, nil
+}
+
+// NombreVariante arma el nombre del archivo de una variante a partir del original,
+// ej. ("1761105318.png", "thumb", "jpg") => "1761105318_thumb.jpg"
+func NombreVariante(original, variante, extension string) string {
+	base := original
+	if punto := strings.LastIndex(original, "."); punto > 0 {
+		base = original[:punto]
+	}
+	return base + "_" + variante + "." + extension
+}
+
+// ImagenRedimensionada es el resultado codificado de una variante
+type ImagenRedimensionada struct {
+	Variante Variante
+	Datos    []byte
+}
+
+// RedimensionarImagen decodifica la imagen original y genera cada variante respetando la proporción.
+// Las imágenes más angostas que la variante no se agrandan.
+func RedimensionarImagen(original []byte, cfg ConfigVariantes) ([]ImagenRedimensionada, error) {
+	img, _, err := image.Decode(bytes.NewReader(original))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrImagenInvalida, err.Error())
+	}
+
+	limites := img.Bounds()
+	var resultados []ImagenRedimensionada
+	for _, variante := range cfg.Variantes {
+		ancho := variante.Ancho
+		if ancho > limites.Dx() {
+			ancho = limites.Dx()
+		}
+		alto := limites.Dy() * ancho / limites.Dx()
+		if alto < 1 {
+			alto = 1
+		}
+
+		destino := image.NewRGBA(image.Rect(0, 0, ancho, alto))
+		if cfg.Formato == "jpeg" {
+			// JPEG no soporta transparencia, se usa fondo blanco
+			draw.Draw(destino, destino.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		}
+		draw.CatmullRom.Scale(destino, destino.Bounds(), img, limites, draw.Over, nil)
+
+		datos, err := codificarImagen(destino, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("no se pudo generar la variante '%s': %w", variante.Nombre, err)
+		}
+		resultados = append(resultados, ImagenRedimensionada{Variante: variante, Datos: datos})
+	}
+	return resultados, nil
+}
+
+func codificarImagen(img image.Image, cfg ConfigVariantes) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch cfg.Formato {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "webp":
+		err = nativewebp.Encode(&buf, img, nil)
+	default:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: cfg.Calidad})
+	}
+	return buf.Bytes(), err
+}
@@ -36,6 +36,39 @@ func passwordValidator(fl validator.FieldLevel) bool {
 	return hasMinLen && hasUpper && hasLower && hasNumber && hasSpecial
 }
 
+// scopeValidator acepta solo los scopes recurso:accion definidos en modelos.ScopesValidos
+func scopeValidator(fl validator.FieldLevel) bool {
+	return modelos.ScopesValidos[fl.Field().String()]
+}
+
+// ean13Validator acepta códigos de barra EAN-13: 13 dígitos con el dígito verificador correcto
+func ean13Validator(fl validator.FieldLevel) bool {
+	return EAN13Valido(fl.Field().String())
+}
+
+// EAN13Valido comprueba el largo y el dígito verificador de un código EAN-13
+func EAN13Valido(codigo string) bool {
+	if len(codigo) != 13 {
+		return false
+	}
+	suma := 0
+	for i, c := range codigo {
+		if c < '0' || c > '9' {
+			return false
+		}
+		digito := int(c - '0')
+		if i == 12 {
+			return (10-suma%10)%10 == digito
+		}
+		// Las posiciones pares (contando desde 1) pesan 3
+		if i%2 == 1 {
+			digito *= 3
+		}
+		suma += digito
+	}
+	return false
+}
+
 func ValidarUsuario(dto modelos.UsuarioDto) error {
 	validate := validator.New()
 
@@ -112,6 +145,12 @@ func ValidarLogin(dto modelos.LoginDto) error {
 
 func ValidarProducto(dto modelos.Producto) error {
 	validate := validator.New()
+
+	// Registrar la validación personalizada
+	if err := validate.RegisterValidation("ean13", ean13Validator); err != nil {
+		return err
+	}
+
 	if err := validate.Struct(&dto); err != nil {
 		var mensajes []string
 
@@ -131,6 +170,8 @@ func ValidarProducto(dto modelos.Producto) error {
 				msg = fmt.Sprintf("El campo '%s' debe ser mayor que %s", campo, e.Param())
 			case "gte":
 				msg = fmt.Sprintf("El campo '%s' debe ser mayor o igual que %s", campo, e.Param())
+			case "ean13":
+				msg = fmt.Sprintf("El campo '%s' debe ser un código EAN-13 válido", campo)
 			default:
 				msg = fmt.Sprintf("Error en '%s': %s no es válido (%s)", campo, valor, tag)
 			}
@@ -171,3 +212,109 @@ func ValidarUploadFotoProducto(dto modelos.UploadFotoProducto) error {
 	}
 	return nil
 }
+
+// validarEstructura aplica las reglas de las etiquetas validate y une los mensajes en uno solo
+func validarEstructura(dto interface{}) error {
+	validate := validator.New()
+
+	// Registrar las validaciones personalizadas
+	if err := validate.RegisterValidation("password", passwordValidator); err != nil {
+		return err
+	}
+	if err := validate.RegisterValidation("scope", scopeValidator); err != nil {
+		return err
+	}
+	if err := validate.RegisterValidation("ean13", ean13Validator); err != nil {
+		return err
+	}
+
+	if err := validate.Struct(dto); err != nil {
+		var mensajes []string
+		for _, e := range err.(validator.ValidationErrors) {
+			campo := e.Field() // Nombre del campo
+			tag := e.Tag()     // Regla que falló
+			valor := e.Value() // Valor que causó el error
+
+			// Mensaje personalizado
+			var msg string
+			switch tag {
+			case "required":
+				msg = fmt.Sprintf("El campo '%s' es requerido", campo)
+			case "min":
+				msg = fmt.Sprintf("El campo '%s' debe tener al menos %s caracteres", campo, e.Param())
+			case "email":
+				msg = fmt.Sprintf("El campo '%s' debe contener un correo válido", campo)
+			case "password":
+				msg = fmt.Sprintf("El campo '%s' debe presentar un formato válido", campo)
+			case "required_without":
+				msg = fmt.Sprintf("El campo '%s' es requerido si no se envía '%s'", campo, e.Param())
+			case "scope":
+				msg = fmt.Sprintf("El scope '%v' no es válido, use recurso:accion (ej. productos:leer)", valor)
+			case "oneof":
+				msg = fmt.Sprintf("El campo '%s' debe ser uno de: %s", campo, e.Param())
+			case "ip":
+				msg = fmt.Sprintf("El campo '%s' debe contener una IP válida", campo)
+			case "ean13":
+				msg = fmt.Sprintf("El campo '%s' debe ser un código EAN-13 válido", campo)
+			default:
+				msg = fmt.Sprintf("Error en '%s': %v no es válido (%s)", campo, valor, tag)
+			}
+			mensajes = append(mensajes, msg)
+		}
+		// Unir mensajes en solo uno
+		return errors.New(strings.Join(mensajes, "; "))
+	}
+	return nil
+}
+
+func ValidarCorreo(dto modelos.CorreoDto) error {
+	return validarEstructura(&dto)
+}
+
+func ValidarResetPassword(dto modelos.ResetPasswordDto) error {
+	return validarEstructura(&dto)
+}
+
+func ValidarDesbloqueo(dto modelos.DesbloqueoDto) error {
+	return validarEstructura(&dto)
+}
+
+func ValidarCodigoMFA(dto modelos.CodigoMFADto) error {
+	return validarEstructura(&dto)
+}
+
+func ValidarLoginMFA(dto modelos.LoginMFADto) error {
+	return validarEstructura(&dto)
+}
+
+func ValidarPerfil(dto modelos.PerfilDto) error {
+	return validarEstructura(&dto)
+}
+
+func ValidarCambioPassword(dto modelos.CambioPasswordDto) error {
+	return validarEstructura(&dto)
+}
+
+func ValidarCambioCorreo(dto modelos.CambioCorreoDto) error {
+	return validarEstructura(&dto)
+}
+
+func ValidarConfirmarPassword(dto modelos.ConfirmarPasswordDto) error {
+	return validarEstructura(&dto)
+}
+
+func ValidarCambioRol(dto modelos.CambioRolDto) error {
+	return validarEstructura(&dto)
+}
+
+func ValidarCambioEstado(dto modelos.CambioEstadoDto) error {
+	return validarEstructura(&dto)
+}
+
+func ValidarCrearApiKey(dto modelos.CrearApiKeyDto) error {
+	return validarEstructura(&dto)
+}
+
+func ValidarUpdateProducto(dto modelos.UpdateProducto) error {
+	return validarEstructura(&dto)
+}
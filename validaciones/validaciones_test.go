@@ -0,0 +1,23 @@
+package validaciones
+
+import "testing"
+
+func TestEAN13Valido(t *testing.T) {
+	casos := map[string]bool{
+		"4006381333931":  true,
+		"7501031311309":  true,
+		"0000000000000":  true,
+		"4006381333932":  false, // dígito verificador incorrecto
+		"400638133393":   false, // 12 dígitos
+		"40063813339310": false, // 14 dígitos
+		"40063813339a1":  false,
+		"4006381333 31":  false,
+		"":               false,
+		"４００６３８１３３３９３１": false, // dígitos de ancho completo
+	}
+	for codigo, esperado := range casos {
+		if valido := EAN13Valido(codigo); valido != esperado {
+			t.Errorf("EAN13Valido(%q) = %v, se esperaba %v", codigo, valido, esperado)
+		}
+	}
+}